@@ -0,0 +1,446 @@
+package csvproc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Aggregate functions accepted by ParseAggregates.
+const (
+	AggCount    = "count"
+	AggSum      = "sum"
+	AggMin      = "min"
+	AggMax      = "max"
+	AggAvg      = "avg"
+	AggDistinct = "distinct" // exact up to 1024 values, approximate beyond, see HyperLogLog
+)
+
+// Aggregation output formats.
+const (
+	AggFormatTable = "table"
+	AggFormatJSON  = "json"
+)
+
+// KeyExpr extracts a value from a row for grouping or aggregation. Besides a
+// plain column name it accepts "Age/10" to bucket numbers into ranges of
+// width 10 and "domain(Email)" for the part of an address after the "@".
+type KeyExpr struct {
+	Name   string
+	column string
+	width  float64
+	domain bool
+}
+
+func ParseKeyExpr(s string) (KeyExpr, error) {
+	s = strings.TrimSpace(s)
+	expr := KeyExpr{Name: s, column: s}
+	switch {
+	case s == "":
+		return expr, fmt.Errorf("empty column")
+	case strings.HasPrefix(s, "domain(") && strings.HasSuffix(s, ")"):
+		expr.column = strings.TrimSpace(s[len("domain(") : len(s)-1])
+		expr.domain = true
+	case strings.Contains(s, "/"):
+		col, width, _ := strings.Cut(s, "/")
+		w, err := strconv.ParseFloat(strings.TrimSpace(width), 64)
+		if err != nil || w <= 0 {
+			return expr, fmt.Errorf("invalid bucket width in %q", s)
+		}
+		expr.column, expr.width = strings.TrimSpace(col), w
+	}
+	return expr, nil
+}
+
+// Eval returns the value of the expression for row. Empty values and values
+// that cannot be bucketed are reported as missing.
+func (e KeyExpr) Eval(row *Row) (string, bool) {
+	v := strings.TrimSpace(row.Get(e.column))
+	if v == "" {
+		return "", false
+	}
+	switch {
+	case e.domain:
+		_, domain, ok := strings.Cut(v, "@")
+		return strings.ToLower(domain), ok && domain != ""
+	case e.width > 0:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "", false
+		}
+		lo := math.Floor(f/e.width) * e.width
+		return strconv.FormatFloat(lo, 'f', -1, 64) + "-" + strconv.FormatFloat(lo+e.width, 'f', -1, 64), true
+	}
+	return v, true
+}
+
+// AggSpec is one aggregate, e.g. "avg:Age" or "distinct:domain(Email)". A
+// bare "count" counts rows, "count:Col" counts non-empty values of Col.
+type AggSpec struct {
+	Func  string
+	Field *KeyExpr
+}
+
+func (s AggSpec) String() string {
+	if s.Field == nil {
+		return s.Func
+	}
+	return s.Func + "(" + s.Field.Name + ")"
+}
+
+// ParseAggregates parses a comma separated list of aggregates.
+func ParseAggregates(spec string) ([]AggSpec, error) {
+	var specs []AggSpec
+	for _, item := range SplitList(spec) {
+		fn, field, hasField := strings.Cut(item, ":")
+		s := AggSpec{Func: strings.ToLower(strings.TrimSpace(fn))}
+		switch s.Func {
+		case AggCount:
+		case AggSum, AggMin, AggMax, AggAvg, AggDistinct:
+			if !hasField {
+				return nil, fmt.Errorf("aggregate %q needs a column, e.g. %s:Age", item, s.Func)
+			}
+		default:
+			return nil, fmt.Errorf("unknown aggregate %q", item)
+		}
+		if hasField {
+			expr, err := ParseKeyExpr(field)
+			if err != nil {
+				return nil, fmt.Errorf("aggregate %q: %w", item, err)
+			}
+			s.Field = &expr
+		}
+		specs = append(specs, s)
+	}
+	if len(specs) == 0 {
+		specs = []AggSpec{{Func: AggCount}}
+	}
+	return specs, nil
+}
+
+// accumulator holds the running state of one AggSpec within one group.
+type accumulator struct {
+	n        int64
+	sum      float64
+	min, max float64
+	sketch   *HyperLogLog
+}
+
+func (a *accumulator) add(spec AggSpec, row *Row) {
+	if spec.Field == nil {
+		a.n++
+		return
+	}
+	v, ok := spec.Field.Eval(row)
+	if !ok {
+		return
+	}
+
+	switch spec.Func {
+	case AggCount:
+		a.n++
+	case AggDistinct:
+		if a.sketch == nil {
+			a.sketch = NewHyperLogLog()
+		}
+		a.sketch.Add(v)
+	default:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return
+		}
+		if a.n == 0 || f < a.min {
+			a.min = f
+		}
+		if a.n == 0 || f > a.max {
+			a.max = f
+		}
+		a.sum += f
+		a.n++
+	}
+}
+
+func (a *accumulator) merge(other *accumulator) {
+	if other.sketch != nil {
+		if a.sketch == nil {
+			a.sketch = NewHyperLogLog()
+		}
+		a.sketch.Merge(other.sketch)
+	}
+	if other.n == 0 {
+		return
+	}
+	if a.n == 0 || other.min < a.min {
+		a.min = other.min
+	}
+	if a.n == 0 || other.max > a.max {
+		a.max = other.max
+	}
+	a.sum += other.sum
+	a.n += other.n
+}
+
+// value returns the result of the aggregate, or nil when no value was seen.
+func (a *accumulator) value(spec AggSpec) any {
+	switch spec.Func {
+	case AggCount:
+		return a.n
+	case AggDistinct:
+		if a.sketch == nil {
+			return uint64(0)
+		}
+		return a.sketch.Count()
+	}
+	if a.n == 0 {
+		return nil
+	}
+	switch spec.Func {
+	case AggSum:
+		return a.sum
+	case AggMin:
+		return a.min
+	case AggMax:
+		return a.max
+	default:
+		return a.sum / float64(a.n)
+	}
+}
+
+type aggGroup struct {
+	key  []string
+	accs []accumulator
+}
+
+// aggPartial is the aggregate of the rows seen by one worker or one file.
+// It is not safe for concurrent use; partials are merged into the
+// Aggregator when a worker exits.
+type aggPartial struct {
+	agg    *Aggregator
+	groups map[string]*aggGroup
+}
+
+func (p *aggPartial) add(row *Row) {
+	if p == nil {
+		return
+	}
+	key := make([]string, len(p.agg.groupBy))
+	for i, expr := range p.agg.groupBy {
+		key[i], _ = expr.Eval(row)
+	}
+	g := p.group(key)
+	for i, spec := range p.agg.specs {
+		g.accs[i].add(spec, row)
+	}
+}
+
+func (p *aggPartial) group(key []string) *aggGroup {
+	k := strings.Join(key, "\x00")
+	g, ok := p.groups[k]
+	if !ok {
+		g = &aggGroup{key: key, accs: make([]accumulator, len(p.agg.specs))}
+		p.groups[k] = g
+	}
+	return g
+}
+
+func (p *aggPartial) merge(other *aggPartial) {
+	if p == nil || other == nil {
+		return
+	}
+	for _, og := range other.groups {
+		g := p.group(og.key)
+		for i := range g.accs {
+			g.accs[i].merge(&og.accs[i])
+		}
+	}
+}
+
+// Aggregator computes group-by aggregates over the valid rows of a run.
+// Every worker aggregates into its own partial, and the partials are merged
+// when the workers exit.
+type Aggregator struct {
+	groupBy []KeyExpr
+	specs   []AggSpec
+
+	mu     sync.Mutex
+	merged *aggPartial
+}
+
+// NewAggregator parses the comma separated group-by expressions and
+// aggregates, e.g. NewAggregator("City", "count,avg:Age").
+func NewAggregator(groupBy, aggregates string) (*Aggregator, error) {
+	a := &Aggregator{}
+	for _, item := range SplitList(groupBy) {
+		expr, err := ParseKeyExpr(item)
+		if err != nil {
+			return nil, fmt.Errorf("group by: %w", err)
+		}
+		a.groupBy = append(a.groupBy, expr)
+	}
+	specs, err := ParseAggregates(aggregates)
+	if err != nil {
+		return nil, err
+	}
+	a.specs = specs
+	a.merged = a.newPartial()
+	return a, nil
+}
+
+func (a *Aggregator) newPartial() *aggPartial {
+	if a == nil {
+		return nil
+	}
+	return &aggPartial{agg: a, groups: make(map[string]*aggGroup)}
+}
+
+func (a *Aggregator) mergePartial(p *aggPartial) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.merged.merge(p)
+}
+
+// AggregateResult is the merged outcome of an Aggregator.
+type AggregateResult struct {
+	GroupBy    []string         `json:"group_by"`
+	Aggregates []string         `json:"aggregates"`
+	Groups     []AggregateGroup `json:"groups"`
+}
+
+// AggregateGroup holds the values of one group in the order of
+// AggregateResult.Aggregates. Missing values are nil.
+type AggregateGroup struct {
+	Key    []string `json:"key"`
+	Values []any    `json:"values"`
+}
+
+// Result returns the groups merged so far, sorted by key.
+func (a *Aggregator) Result() *AggregateResult {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	res := &AggregateResult{GroupBy: []string{}, Groups: []AggregateGroup{}}
+	for _, expr := range a.groupBy {
+		res.GroupBy = append(res.GroupBy, expr.Name)
+	}
+	for _, spec := range a.specs {
+		res.Aggregates = append(res.Aggregates, spec.String())
+	}
+	for _, g := range a.merged.groups {
+		group := AggregateGroup{Key: g.key, Values: make([]any, len(a.specs))}
+		for i, spec := range a.specs {
+			group.Values[i] = g.accs[i].value(spec)
+		}
+		res.Groups = append(res.Groups, group)
+	}
+	sort.Slice(res.Groups, func(i, j int) bool {
+		return lessKey(res.Groups[i].Key, res.Groups[j].Key)
+	})
+	return res
+}
+
+// lessKey orders keys column by column, numerically where both values are
+// numbers or numeric buckets.
+func lessKey(a, b []string) bool {
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		x, xok := keyNumber(a[i])
+		y, yok := keyNumber(b[i])
+		if xok && yok && x != y {
+			return x < y
+		}
+		return a[i] < b[i]
+	}
+	return false
+}
+
+// keyNumber returns the number of a key value, or the lower bound of a
+// bucket such as "-20--10". The minus of a negative lower bound is not the
+// separator.
+func keyNumber(v string) (float64, bool) {
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f, true
+	}
+	if len(v) > 1 {
+		if i := strings.Index(v[1:], "-"); i >= 0 {
+			v = v[:i+1]
+		}
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	return f, err == nil
+}
+
+// AggregateFormat picks a format from the file extension of path.
+func AggregateFormat(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return AggFormatJSON
+	}
+	return AggFormatTable
+}
+
+// WriteAggregate writes res to path ("-" for stdout) in format.
+func WriteAggregate(res *AggregateResult, path, format string) error {
+	if format == "" {
+		format = AggregateFormat(path)
+	}
+
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	switch format {
+	case AggFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	case AggFormatTable:
+		return res.WriteTable(w)
+	default:
+		return fmt.Errorf("unknown aggregate format %q", format)
+	}
+}
+
+// WriteTable writes res as an aligned text table.
+func (res *AggregateResult) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(append(append([]string{}, res.GroupBy...), res.Aggregates...), "\t"))
+	for _, g := range res.Groups {
+		cells := make([]string, 0, len(g.Key)+len(g.Values))
+		for _, k := range g.Key {
+			if k == "" {
+				k = "(empty)"
+			}
+			cells = append(cells, k)
+		}
+		for _, v := range g.Values {
+			switch v := v.(type) {
+			case nil:
+				cells = append(cells, "-")
+			case float64:
+				cells = append(cells, strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64))
+			default:
+				cells = append(cells, fmt.Sprint(v))
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,54 @@
+package csvproc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLessKey(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string // in the expected order
+	}{
+		{"numbers", []string{"-20", "-3", "-0.5", "0", "2", "10"}},
+		{"buckets", []string{"-20--10", "-10-0", "0-10", "10-20", "100-110"}},
+		{"strings", []string{"Bandung", "Jakarta", "Surabaya"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var keys [][]string
+			for i := len(tt.keys) - 1; i >= 0; i-- {
+				keys = append(keys, []string{tt.keys[i]})
+			}
+			slices.SortFunc(keys, func(a, b []string) int {
+				switch {
+				case lessKey(a, b):
+					return -1
+				case lessKey(b, a):
+					return 1
+				}
+				return 0
+			})
+			for i, key := range keys {
+				if key[0] != tt.keys[i] {
+					t.Fatalf("sorted to %v, want %v", keys, tt.keys)
+				}
+			}
+		})
+	}
+}
+
+func TestBucketKeys(t *testing.T) {
+	expr, err := ParseKeyExpr("Age/10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := NewHeader([]string{"Age"})
+	tests := map[string]string{"-15": "-20--10", "-5": "-10-0", "5": "0-10", "31": "30-40"}
+	for age, want := range tests {
+		got, ok := expr.Eval(&Row{Header: header, Fields: []string{age}})
+		if !ok || got != want {
+			t.Errorf("Age %s: got bucket %q, want %q", age, got, want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package csvproc
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checkpoint statuses.
+const (
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// CheckpointEntry is the committed progress of one job.
+type CheckpointEntry struct {
+	File        string    `json:"file"`
+	Status      string    `json:"status"`
+	Checksum    string    `json:"checksum"`
+	Header      []string  `json:"header,omitempty"`
+	Rows        int       `json:"rows"`
+	ValidRows   int       `json:"valid_rows"`
+	InvalidRows int       `json:"invalid_rows"`
+	Offset      int64     `json:"offset"` // byte offset after the last committed row
+	NextLine    int       `json:"next_line"`
+	ChunkSize   int64     `json:"chunk_size"` // of the run that committed the entry, 0 if it did not split files
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Checkpoint records per-job progress in a JSON file so an interrupted run
+// can be resumed. It is safe for concurrent use by the workers.
+type Checkpoint struct {
+	mu        sync.Mutex
+	path      string
+	entries   map[string]*CheckpointEntry
+	checksums map[string]*checksumCall
+	dirty     bool
+}
+
+// checksumCall is a checksum being computed, or computed, for one input.
+// The chunks of a split file share it so the file is only hashed once.
+type checksumCall struct {
+	done chan struct{}
+	sum  string
+	err  error
+}
+
+// LoadCheckpoint reads the checkpoint at path. A missing file yields an
+// empty checkpoint.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	c := NewCheckpoint(path)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("parse checkpoint %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// NewCheckpoint returns an empty checkpoint that is saved to path.
+func NewCheckpoint(path string) *Checkpoint {
+	return &Checkpoint{
+		path:      path,
+		entries:   make(map[string]*CheckpointEntry),
+		checksums: make(map[string]*checksumCall),
+	}
+}
+
+// checkpointKey identifies a job across runs. Chunks are keyed by their
+// start offset, which is stable as long as the chunk size is unchanged.
+func checkpointKey(job FileJob) string {
+	key := job.FilePath
+	if job.Entry != "" {
+		key += ":" + job.Entry
+	}
+	if job.Chunks > 1 {
+		key += fmt.Sprintf("#%d", job.Offset)
+	}
+	return key
+}
+
+// CheckChunkSize returns an error if entries were committed by a run with
+// another chunk size. Chunks are keyed by their offsets, so the progress of
+// split files would not be found and they would be processed again.
+func (c *Checkpoint) CheckChunkSize(size int64) error {
+	size = max(size, 0)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		if entry.ChunkSize != size {
+			return fmt.Errorf("checkpoint %s was written with chunk size %d, not %d", c.path, entry.ChunkSize, size)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the committed progress of job, provided the input has not
+// changed since it was recorded.
+func (c *Checkpoint) Lookup(job FileJob) (*CheckpointEntry, error) {
+	checksum, err := c.Checksum(job)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[checkpointKey(job)]
+	if !ok || entry.Checksum != checksum {
+		return nil, nil
+	}
+	copied := *entry
+	return &copied, nil
+}
+
+// Commit records the progress of job. It fails if the checksum of the input
+// cannot be computed, since the entry could never be resumed.
+func (c *Checkpoint) Commit(job FileJob, entry CheckpointEntry) error {
+	checksum, err := c.Checksum(job)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.File = job.Name()
+	entry.Checksum = checksum
+	entry.UpdatedAt = time.Now()
+	c.entries[checkpointKey(job)] = &entry
+	c.dirty = true
+	return nil
+}
+
+// Checksum returns the SHA-256 of the job's input file, or the CRC-32 of a
+// zip entry. Checksums are computed once per input and cached; concurrent
+// callers wait for the first one. Failures are not cached.
+//
+// The cache is keyed by the size and modification time of the file as well,
+// so that a file replaced under the same name, as happens in watch mode, is
+// hashed again instead of matching the entries of its predecessor.
+func (c *Checkpoint) Checksum(job FileJob) (string, error) {
+	info, err := os.Stat(job.FilePath)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s:%s:%d:%d", job.FilePath, job.Entry, info.Size(), info.ModTime().UnixNano())
+
+	c.mu.Lock()
+	call, ok := c.checksums[key]
+	if ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.sum, call.err
+	}
+	call = &checksumCall{done: make(chan struct{})}
+	c.checksums[key] = call
+	c.mu.Unlock()
+
+	call.sum, call.err = inputChecksum(job)
+	if call.err != nil {
+		c.mu.Lock()
+		delete(c.checksums, key)
+		c.mu.Unlock()
+	}
+	close(call.done)
+	return call.sum, call.err
+}
+
+func inputChecksum(job FileJob) (string, error) {
+	if job.Entry != "" {
+		archive, err := zip.OpenReader(job.FilePath)
+		if err != nil {
+			return "", err
+		}
+		defer archive.Close()
+		for _, f := range archive.File {
+			if f.Name == job.Entry {
+				return fmt.Sprintf("crc32:%08x:%d", f.CRC32, f.UncompressedSize64), nil
+			}
+		}
+		return "", fmt.Errorf("zip entry %s not found", job.Entry)
+	}
+
+	file, err := os.Open(job.FilePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Save writes the checkpoint atomically if it changed since the last save.
+// After a failed write the checkpoint stays dirty and the next Save tries
+// again.
+func (c *Checkpoint) Save() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	c.dirty = false
+	c.mu.Unlock()
+	if err == nil {
+		err = c.write(data)
+	}
+	if err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+	}
+	return err
+}
+
+func (c *Checkpoint) write(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), "."+filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// Run saves the checkpoint every interval until ctx is done, then saves it
+// one last time. Failed saves are logged to log.
+func (c *Checkpoint) Run(ctx context.Context, interval time.Duration, log io.Writer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Save(); err != nil {
+				fmt.Fprintf(log, "checkpoint: %v\n", err)
+			}
+		case <-ctx.Done():
+			if err := c.Save(); err != nil {
+				fmt.Fprintf(log, "checkpoint: %v\n", err)
+			}
+			return
+		}
+	}
+}
+
+// nextLine returns the line number following a record that starts at line.
+func nextLine(line int, record []string) int {
+	next := line + 1
+	for _, field := range record {
+		next += strings.Count(field, "\n")
+	}
+	return next
+}
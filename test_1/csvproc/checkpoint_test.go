@@ -0,0 +1,282 @@
+package csvproc
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCheckpointLookup(t *testing.T) {
+	path := sampleFiles(t, 1)[0]
+	job := FileJob{FilePath: path, FileNum: 1}
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	c := NewCheckpoint(checkpointPath)
+	if err := c.Commit(job, CheckpointEntry{Status: StatusRunning, Rows: 42, Offset: 1234}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := loaded.Lookup(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.Rows != 42 || entry.Offset != 1234 || entry.File != job.Name() {
+		t.Fatalf("got entry %+v, want 42 rows at offset 1234", entry)
+	}
+
+	// A changed input invalidates its entry
+	if err := os.WriteFile(path, []byte("ID\n1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := LoadCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := changed.Lookup(job); err != nil || entry != nil {
+		t.Errorf("got entry %+v, %v for a changed input, want none", entry, err)
+	}
+	// Also by the checkpoint that cached the checksum of the old input
+	if entry, err := c.Lookup(job); err != nil || entry != nil {
+		t.Errorf("got entry %+v, %v for a replaced input, want none", entry, err)
+	}
+
+	missing, err := LoadCheckpoint(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := missing.Lookup(job); err != nil || entry != nil {
+		t.Errorf("got entry %+v, %v from an empty checkpoint, want none", entry, err)
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		stopAt  int64 // rows handled before the first run is cancelled
+		extra   []Option
+	}{
+		{"one worker", 1, 130, nil},
+		{"many workers", 4, 250, nil},
+		{"chunked", 4, 250, []Option{WithChunkSize(1024)}},
+		{"reading ahead", 1, 130, []Option{WithLimits(Limits{RowBuffer: 64, ReadAhead: 16})}},
+		{"after the last row", 1, int64(sampleRows(4)), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := sampleFiles(t, 4)
+			checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+			rec := newRecorder()
+
+			// The first run is cancelled from inside the handler
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var handled atomic.Int64
+			stopping := RowHandlerFunc(func(ctx context.Context, row *Row) error {
+				rec.HandleRow(ctx, row)
+				if handled.Add(1) == tt.stopAt {
+					cancel()
+				}
+				return nil
+			})
+			opts := append([]Option{
+				WithWorkers(tt.workers),
+				WithHandler(stopping),
+				WithCheckpoint(NewCheckpoint(checkpointPath), false),
+				WithLogOutput(io.Discard),
+			}, tt.extra...)
+			New(opts...).WithContext(ctx).ProcessFiles(paths)
+
+			checkpoint, err := LoadCheckpoint(checkpointPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts = append([]Option{
+				WithWorkers(tt.workers),
+				WithHandler(rec),
+				WithCheckpoint(checkpoint, true),
+				WithLogOutput(io.Discard),
+			}, tt.extra...)
+			rows, resumed := 0, 0
+			for result := range New(opts...).Process(context.Background(), paths) {
+				if result.Error != nil {
+					t.Errorf("%s: %v", result.FileName, result.Error)
+				}
+				rows += result.RowCount
+				resumed += result.ResumedRows
+			}
+
+			want := sampleRows(len(paths))
+			if rows != want || rec.rows() != want {
+				t.Errorf("got %d rows, %d handled, want %d", rows, rec.rows(), want)
+			}
+			if dups := rec.duplicates(); len(dups) > 0 {
+				t.Errorf("%d rows handled twice, e.g. %s", len(dups), dups[0])
+			}
+			if resumed == 0 {
+				t.Error("no rows were resumed")
+			}
+		})
+	}
+}
+
+func TestCheckpointNotHashedBeforeFirstRows(t *testing.T) {
+	path := sampleFiles(t, 1)[0]
+	c := NewCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"))
+
+	// Rows up to the first commit are handled without waiting for the hash
+	hashedAt := 0
+	handled := 0
+	p := New(WithWorkers(1), WithCheckpoint(c, false), WithLogOutput(io.Discard), WithHandler(RowHandlerFunc(func(ctx context.Context, row *Row) error {
+		handled++
+		c.mu.Lock()
+		if len(c.checksums) > 0 && hashedAt == 0 {
+			hashedAt = handled
+		}
+		c.mu.Unlock()
+		return nil
+	})))
+	results := p.ProcessFiles([]string{path})
+	if err := results[0].Error; err != nil {
+		t.Fatal(err)
+	}
+	if hashedAt != 0 && hashedAt <= checkpointEvery {
+		t.Errorf("input was hashed before row %d, want after %d rows", hashedAt, checkpointEvery)
+	}
+
+	entry, err := c.Lookup(FileJob{FilePath: path, FileNum: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.Status != StatusDone {
+		t.Errorf("got entry %+v, want a done entry", entry)
+	}
+}
+
+func TestCheckpointResumeSink(t *testing.T) {
+	for _, kind := range []string{SinkCSV, SinkJSONL} {
+		t.Run(kind, func(t *testing.T) {
+			paths := sampleFiles(t, 4)
+			dir := t.TempDir()
+			checkpointPath := filepath.Join(dir, "checkpoint.json")
+			output := filepath.Join(dir, "out."+kind)
+
+			// The first run is cancelled after stopAt rows, the second resumes
+			// it and appends to its output
+			run := func(resume bool, stopAt int64) {
+				t.Helper()
+				sink, err := NewSink(SinkConfig{Kind: kind, Output: output, Append: resume})
+				if err != nil {
+					t.Fatal(err)
+				}
+				async := NewAsyncSink(sink, 16)
+				checkpoint := NewCheckpoint(checkpointPath)
+				if resume {
+					if checkpoint, err = LoadCheckpoint(checkpointPath); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				var handled atomic.Int64
+				handler := RowHandlerFunc(func(ctx context.Context, row *Row) error {
+					if err := async.WriteRow(ctx, row); err != nil {
+						return err
+					}
+					if handled.Add(1) == stopAt {
+						cancel()
+					}
+					return nil
+				})
+				New(
+					WithWorkers(2),
+					WithHandler(handler),
+					WithCheckpoint(checkpoint, resume),
+					WithSinkFlush(async.Flush),
+					WithLogOutput(io.Discard),
+				).WithContext(ctx).ProcessFiles(paths)
+				if err := async.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if err := checkpoint.Save(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			run(false, 130)
+			run(true, -1)
+
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			if kind == SinkCSV {
+				if lines[0] != "ID,Name,Email,Age,City" {
+					t.Fatalf("got header %q", lines[0])
+				}
+				lines = lines[1:]
+			}
+			seen := make(map[string]int)
+			for _, line := range lines {
+				seen[line]++
+				if line == "ID,Name,Email,Age,City" {
+					t.Error("header written again")
+				}
+			}
+			if want := sampleRows(len(paths)); len(lines) != want || len(seen) != want {
+				t.Errorf("got %d rows, %d distinct, want %d", len(lines), len(seen), want)
+			}
+		})
+	}
+}
+
+func TestCheckpointChunkSize(t *testing.T) {
+	path := writeCSV(t, 500)
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	c := NewCheckpoint(checkpointPath)
+	New(WithWorkers(2), WithChunkSize(1024), WithCheckpoint(c, false), WithLogOutput(io.Discard)).ProcessFiles([]string{path})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCheckpoint(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		size int64
+		ok   bool
+	}{
+		{1024, true},
+		{2048, false},
+		{0, false},
+	}
+	for _, tt := range tests {
+		if err := loaded.CheckChunkSize(tt.size); (err == nil) != tt.ok {
+			t.Errorf("chunk size %d: got %v, want ok = %v", tt.size, err, tt.ok)
+		}
+	}
+
+	// Without splitting every entry records a chunk size of 0
+	unsplit := NewCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"))
+	New(WithWorkers(2), WithChunkSize(0), WithCheckpoint(unsplit, false), WithLogOutput(io.Discard)).ProcessFiles([]string{path})
+	if err := unsplit.CheckChunkSize(0); err != nil {
+		t.Error(err)
+	}
+	if err := unsplit.CheckChunkSize(1024); err == nil {
+		t.Error("accepted another chunk size")
+	}
+}
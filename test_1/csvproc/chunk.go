@@ -0,0 +1,138 @@
+package csvproc
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SplitJob splits a large plain CSV file into newline-aligned byte ranges of
+// roughly chunkSize bytes. Boundaries are only placed on newlines outside of
+// quoted fields. Each chunk carries the header and the global line and row
+// numbers of its first record so results can be merged back together.
+//
+// Compressed inputs, zip entries, files smaller than two chunks and
+// dialects that are not Splittable are returned unchanged as a single job.
+func SplitJob(job FileJob, chunkSize int64, dialect Dialect) ([]FileJob, error) {
+	if chunkSize <= 0 || job.Entry != "" || !dialect.Splittable() {
+		return []FileJob{job}, nil
+	}
+
+	file, err := os.Open(job.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < 2*chunkSize || isCompressed(file) {
+		return []FileJob{job}, nil
+	}
+
+	var (
+		chunks     []FileJob
+		buf        = make([]byte, 1<<20)
+		pos        int64
+		inQuotes   bool
+		hasContent bool
+		lines      = 1 // line number of the current byte
+		rows       int // records seen so far, the header included
+		header     []string
+		start      int64 // offset of the current chunk
+		startLine  int
+		startRow   int
+	)
+
+	cut := func(end int64) {
+		chunk := job
+		chunk.Chunk = len(chunks)
+		chunk.Offset, chunk.Length = start, end-start
+		chunk.FirstLine, chunk.FirstRow = startLine, startRow
+		chunks = append(chunks, chunk)
+		start, startLine, startRow = end, lines, rows-1
+	}
+
+	for {
+		n, err := file.Read(buf)
+		for i := 0; i < n; i++ {
+			switch c := buf[i]; c {
+			case '"':
+				inQuotes = !inQuotes
+				hasContent = true
+			case '\n':
+				lines++
+				if inQuotes {
+					continue
+				}
+				if hasContent {
+					rows++
+				}
+				hasContent = false
+
+				end := pos + int64(i) + 1
+				if header == nil && rows == 1 {
+					h, herr := readHeader(file, end, dialect)
+					if herr != nil {
+						return nil, herr
+					}
+					header = h
+					start, startLine, startRow = end, lines, 0
+					continue
+				}
+				if header != nil && end-start >= chunkSize && info.Size()-end >= chunkSize/2 {
+					cut(end)
+				}
+			case '\r':
+			default:
+				hasContent = true
+			}
+		}
+		pos += int64(n)
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if header == nil || len(chunks) == 0 {
+		return []FileJob{job}, nil
+	}
+	cut(info.Size())
+
+	for i := range chunks {
+		chunks[i].Chunks = len(chunks)
+		chunks[i].Header = header
+	}
+	return chunks, nil
+}
+
+// readHeader parses the first record, which ends at offset end.
+func readHeader(file *os.File, end int64, dialect Dialect) ([]string, error) {
+	r := csv.NewReader(dialect.decode(io.NewSectionReader(file, 0, end)))
+	r.Comma = dialect.Delimiter
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	return header, nil
+}
+
+func isCompressed(file *os.File) bool {
+	magic := make([]byte, 4)
+	n, err := file.ReadAt(magic, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false
+	}
+	magic = magic[:n]
+	return bytes.HasPrefix(magic, gzipMagic) || bytes.HasPrefix(magic, zstdMagic) || bytes.HasPrefix(magic, zipMagic)
+}
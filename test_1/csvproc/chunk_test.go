@@ -0,0 +1,170 @@
+package csvproc
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCSV writes rows records after a header; every third record has a
+// quoted field spanning lines so that chunks must not be cut inside quotes.
+func writeCSV(t *testing.T, rows int) string {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("ID,Name,Note\n")
+	for i := range rows {
+		note := fmt.Sprintf("note %d", i)
+		if i%3 == 0 {
+			note = fmt.Sprintf("\"first line %d\nsecond, line\"", i)
+		}
+		fmt.Fprintf(&b, "%d,User_%d,%s\n", i+1, i+1, note)
+	}
+	path := filepath.Join(t.TempDir(), "quoted.csv")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSplitJob(t *testing.T) {
+	const rows = 500
+	path := writeCSV(t, rows)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		chunkSize int64
+		split     bool
+	}{
+		{"disabled", 0, false},
+		{"file smaller than two chunks", info.Size()/2 + 1, false},
+		{"two chunks", info.Size() / 3, true},
+		{"many chunks", 512, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := FileJob{FilePath: path, FileNum: 1}
+			dialect, err := CSVOptions{}.Dialect(job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunks, err := SplitJob(job, tt.chunkSize, dialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.split {
+				if len(chunks) != 1 || chunks[0].Chunks != 0 {
+					t.Fatalf("got %d chunks, want the whole file", len(chunks))
+				}
+				return
+			}
+			if len(chunks) < 2 {
+				t.Fatalf("got %d chunks, want several", len(chunks))
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			offset, records := chunks[0].Offset, 0
+			for i, chunk := range chunks {
+				if chunk.Chunk != i || chunk.Chunks != len(chunks) {
+					t.Errorf("chunk %d is numbered %d/%d", i, chunk.Chunk, chunk.Chunks)
+				}
+				if chunk.Offset != offset {
+					t.Errorf("chunk %d starts at %d, want %d", i, chunk.Offset, offset)
+				}
+				if !slices.Equal(chunk.Header, []string{"ID", "Name", "Note"}) {
+					t.Errorf("chunk %d has header %v", i, chunk.Header)
+				}
+				if chunk.FirstRow != records {
+					t.Errorf("chunk %d starts at row %d, want %d", i, chunk.FirstRow, records)
+				}
+
+				// Every chunk must parse on its own, starting at its first row
+				r := csv.NewReader(io.NewSectionReader(file, chunk.Offset, chunk.Length))
+				got, err := r.ReadAll()
+				if err != nil {
+					t.Fatalf("chunk %d: %v", i, err)
+				}
+				if want := fmt.Sprint(records + 1); len(got) == 0 || got[0][0] != want {
+					t.Errorf("chunk %d starts with record %v, want ID %s", i, got[0], want)
+				}
+				records += len(got)
+				offset += chunk.Length
+			}
+			if offset != info.Size() || records != rows {
+				t.Errorf("chunks cover %d bytes and %d rows, want %d and %d", offset, records, info.Size(), rows)
+			}
+		})
+	}
+}
+
+func TestMergeChunk(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	chunk := func(n, of, rows int, err error) jobResult {
+		return jobResult{
+			job:    FileJob{FileNum: 1, Chunk: n, Chunks: of},
+			result: ProcessResult{FileName: "a.csv", RowCount: rows, ValidRows: rows, Attempts: n + 1, Error: err},
+			start:  start.Add(time.Duration(n) * time.Second),
+			end:    start.Add(time.Duration(n+2) * time.Second),
+		}
+	}
+	errFirst, errLast := errors.New("first"), errors.New("last")
+
+	tests := []struct {
+		name     string
+		chunks   []jobResult
+		rows     int
+		attempts int
+		elapsed  time.Duration
+		err      error
+	}{
+		{"whole file", []jobResult{chunk(0, 1, 7, nil)}, 7, 1, 0, nil},
+		{"in order", []jobResult{chunk(0, 3, 1, nil), chunk(1, 3, 2, nil), chunk(2, 3, 3, nil)}, 6, 3, 4 * time.Second, nil},
+		{"out of order", []jobResult{chunk(2, 3, 3, nil), chunk(0, 3, 1, nil), chunk(1, 3, 2, nil)}, 6, 3, 4 * time.Second, nil},
+		{"earliest error wins", []jobResult{chunk(2, 3, 3, errLast), chunk(0, 3, 1, errFirst), chunk(1, 3, 2, nil)}, 6, 3, 4 * time.Second, errFirst},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pending := make(map[int]*pendingFile)
+			var (
+				result ProcessResult
+				done   bool
+			)
+			for i, jr := range tt.chunks {
+				result, done = mergeChunk(pending, jr)
+				if last := i == len(tt.chunks)-1; done != last {
+					t.Fatalf("chunk %d: done = %v, want %v", i, done, last)
+				}
+			}
+			if len(pending) != 0 {
+				t.Errorf("%d files still pending", len(pending))
+			}
+			if result.FileName != "a.csv" || result.RowCount != tt.rows || result.ValidRows != tt.rows {
+				t.Errorf("got %s with %d rows, %d valid, want a.csv with %d", result.FileName, result.RowCount, result.ValidRows, tt.rows)
+			}
+			if result.Attempts != tt.attempts {
+				t.Errorf("got %d attempts, want %d", result.Attempts, tt.attempts)
+			}
+			if len(tt.chunks) > 1 && result.ProcessTime != tt.elapsed {
+				t.Errorf("got process time %v, want %v", result.ProcessTime, tt.elapsed)
+			}
+			if !errors.Is(result.Error, tt.err) || (tt.err == nil) != (result.Error == nil) {
+				t.Errorf("got error %v, want %v", result.Error, tt.err)
+			}
+		})
+	}
+}
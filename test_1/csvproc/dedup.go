@@ -0,0 +1,359 @@
+package csvproc
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Dedup policies.
+const (
+	DedupKeepFirst = "keep-first" // keep the first row in input order, emitted by Flush
+	DedupKeepLast  = "keep-last"  // keep the last row in input order, emitted by Flush
+	DedupFail      = "fail"       // fail the file on a conflicting row
+	DedupConflicts = "conflicts"  // like keep-first, and drop conflicting rows into the conflicts file
+)
+
+// DuplicateError reports a row whose key was already seen in this run.
+// Rows with the same key but different values are conflicts. Non fatal
+// duplicates are dropped and counted by the processor.
+type DuplicateError struct {
+	Key       string
+	Row       string   // file:line of the later row in input order, the duplicate
+	FirstSeen string   // file:line of the earlier row with the same key
+	Conflict  bool     // same key, different values
+	Columns   []string // columns that differ
+	Fatal     bool
+}
+
+func (e *DuplicateError) Error() string {
+	if e.Conflict {
+		return fmt.Sprintf("key %s at %s conflicts with %s on %s", e.Key, e.Row, e.FirstSeen, strings.Join(e.Columns, ", "))
+	}
+	return fmt.Sprintf("key %s at %s duplicates %s", e.Key, e.Row, e.FirstSeen)
+}
+
+// Deduper drops rows whose key columns were already seen in any file of the
+// run. It is safe for concurrent use by the workers.
+//
+// Memory grows with the number of distinct keys of the whole input and is
+// not bounded by the limits of WithLimits. Except with DedupFail the row
+// kept for every key is held until Flush, its fields and some 200 bytes of
+// bookkeeping. DedupFail only remembers the key, the position and a hash of
+// every value, some 100 bytes plus the key and 8 bytes per column.
+type Deduper struct {
+	columns   []string
+	policy    string
+	conflicts *ConflictWriter
+	masker    *Masker
+
+	mu      sync.Mutex
+	seen    map[string]*seenRow
+	dropped map[string]*dedupCount // by file name, for the held policies
+}
+
+// seenRow is what a Deduper remembers of the row kept for a key: the whole
+// row if it is held, otherwise its position and a hash of every value, which
+// is enough to tell whether a later row conflicts with it.
+type seenRow struct {
+	row           *Row // held policies only
+	file          string
+	fileNum, line int
+	header        *Header
+	hashes        []uint64
+}
+
+func (d *Deduper) remember(row *Row) *seenRow {
+	s := &seenRow{file: row.File, fileNum: row.FileNum, line: row.Line, header: row.Header}
+	if d.Holds() {
+		s.row = row
+		return s
+	}
+	s.hashes = make([]uint64, len(row.Header.Columns))
+	for i, col := range row.Header.Columns {
+		s.hashes[i] = valueHash(row.Get(col))
+	}
+	return s
+}
+
+// before reports whether s comes before row in input order.
+func (s *seenRow) before(row *Row) bool {
+	if s.fileNum != row.FileNum {
+		return s.fileNum < row.FileNum
+	}
+	return s.line < row.Line
+}
+
+func (s *seenRow) String() string {
+	return fmt.Sprintf("%s:%d", s.file, s.line)
+}
+
+// diffColumns lists the columns of s whose values differ in row.
+func (s *seenRow) diffColumns(row *Row) []string {
+	if s.row != nil {
+		return diffColumns(s.row, row)
+	}
+	var cols []string
+	for i, col := range s.header.Columns {
+		if s.hashes[i] != valueHash(row.Get(col)) {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// valueHash hashes a value the way diffColumns compares it.
+func valueHash(v string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.TrimSpace(v)))
+	return h.Sum64()
+}
+
+// dedupCount is what a Deduper dropped from one file.
+type dedupCount struct {
+	duplicates, conflicts int
+}
+
+// NewDeduper keys rows on columns. conflicts receives the conflicting rows
+// with the DedupConflicts policy and may be nil otherwise.
+func NewDeduper(columns []string, policy string, conflicts *ConflictWriter) (*Deduper, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("dedup needs at least one key column")
+	}
+	switch policy {
+	case DedupKeepFirst, DedupKeepLast, DedupFail:
+	case DedupConflicts:
+		if conflicts == nil {
+			return nil, fmt.Errorf("dedup policy %s needs a conflicts file", policy)
+		}
+	default:
+		return nil, fmt.Errorf("unknown dedup policy %q", policy)
+	}
+	return &Deduper{
+		columns:   columns,
+		policy:    policy,
+		conflicts: conflicts,
+		seen:      make(map[string]*seenRow),
+		dropped:   make(map[string]*dedupCount),
+	}, nil
+}
+
+// WithMasker masks the rows and keys written to the conflicts file with m,
+// which may be nil.
+func (d *Deduper) WithMasker(m *Masker) *Deduper {
+	d.masker = m
+	return d
+}
+
+// key returns the key of row. Rows with an empty key column are invalid,
+// they would all share one key.
+func (d *Deduper) key(row *Row) (string, error) {
+	parts := make([]string, len(d.columns))
+	for i, col := range d.columns {
+		parts[i] = strings.TrimSpace(row.Get(col))
+		if parts[i] == "" {
+			return "", NewValidationError(col, "empty dedup key")
+		}
+	}
+	return strings.Join(parts, "|"), nil
+}
+
+// Stage checks row against the rows seen so far. Workers see rows in no
+// particular order, so except with DedupFail every row is held back with
+// ErrHoldRow and the row kept for a key is picked by input order. A dropped
+// row is counted against its own file, not the file that happened to be
+// processed later. Flush returns the kept rows once all files are processed.
+//
+// With DedupFail rows pass on as they arrive, so the row failing with a
+// DuplicateError, fatal if its values differ, is the one that arrives
+// second. Which file fails or has the duplicate counted therefore depends
+// on the order the workers reach the rows; the error still names the later
+// row in input order as the duplicate. The held policies do not depend on
+// arrival order.
+func (d *Deduper) Stage(ctx context.Context, row *Row) error {
+	key, err := d.key(row)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	seen, ok := d.seen[key]
+	if !ok {
+		d.seen[key] = d.remember(row)
+		d.mu.Unlock()
+		if !d.Holds() {
+			return nil
+		}
+		return ErrHoldRow
+	}
+
+	// The row of the pair that comes later in input order is the duplicate
+	current := d.remember(row)
+	first, later := seen, current
+	if !seen.before(row) {
+		first, later = current, seen
+	}
+	dup := &DuplicateError{
+		Key:       key,
+		Row:       later.String(),
+		FirstSeen: first.String(),
+		Columns:   seen.diffColumns(row),
+	}
+	dup.Conflict = len(dup.Columns) > 0
+
+	if d.Holds() {
+		kept, dropped := first, later
+		if d.policy == DedupKeepLast {
+			kept, dropped = later, first
+		}
+		d.seen[key] = kept
+		count, ok := d.dropped[dropped.file]
+		if !ok {
+			count = &dedupCount{}
+			d.dropped[dropped.file] = count
+		}
+		count.duplicates++
+		if dup.Conflict {
+			count.conflicts++
+		}
+	}
+	d.mu.Unlock()
+
+	if dup.Conflict {
+		switch d.policy {
+		case DedupFail:
+			dup.Fatal = true
+		case DedupConflicts:
+			if err := d.writeConflict(later.row, dup); err != nil {
+				return err
+			}
+		}
+	}
+	if d.Holds() {
+		return ErrHoldRow
+	}
+	return dup
+}
+
+// writeConflict writes row to the conflicts file, masked if there is a
+// masker.
+func (d *Deduper) writeConflict(row *Row, dup *DuplicateError) error {
+	if d.masker == nil {
+		return d.conflicts.Write(row, dup)
+	}
+	row = d.masker.MaskRow(row)
+	masked := *dup
+	masked.Key, _ = d.key(row)
+	return d.conflicts.Write(row, &masked)
+}
+
+// Holds reports whether rows are held back until Flush.
+func (d *Deduper) Holds() bool {
+	return d.policy != DedupFail
+}
+
+// diffColumns lists the columns whose values differ between a and b.
+func diffColumns(a, b *Row) []string {
+	var cols []string
+	for _, col := range a.Header.Columns {
+		if strings.TrimSpace(a.Get(col)) != strings.TrimSpace(b.Get(col)) {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// droppedResults returns the duplicates and conflicts dropped from every file by
+// the held policies, as results keyed by file name.
+func (d *Deduper) droppedResults() map[string]*ProcessResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	results := make(map[string]*ProcessResult, len(d.dropped))
+	for file, count := range d.dropped {
+		results[file] = &ProcessResult{FileName: file, Duplicates: count.duplicates, Conflicts: count.conflicts}
+	}
+	return results
+}
+
+// Flush hands the kept rows to sink in input order. It is a no-op for
+// DedupFail, which does not hold rows back. ConcurrentProcessor.FlushHeld
+// also aggregates and loads them.
+func (d *Deduper) Flush(ctx context.Context, sink Stage) error {
+	if !d.Holds() || sink == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	rows := make([]*seenRow, 0, len(d.seen))
+	for _, s := range d.seen {
+		rows = append(rows, s)
+	}
+	d.mu.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].before(rows[j].row) })
+	for _, s := range rows {
+		if err := sink(ctx, s.row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConflictWriter records conflicting rows in a CSV file. It is safe for
+// concurrent use.
+type ConflictWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *csv.Writer
+}
+
+func NewConflictWriter(path string) (*ConflictWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := csv.NewWriter(file)
+	if err := w.Write([]string{"file", "line", "key", "first_seen", "columns", "record"}); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &ConflictWriter{file: file, w: w}, nil
+}
+
+func (cw *ConflictWriter) Write(row *Row, dup *DuplicateError) error {
+	record, err := encodeRecord(row.Fields)
+	if err != nil {
+		return err
+	}
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	return cw.w.Write([]string{
+		row.File,
+		strconv.Itoa(row.Line),
+		dup.Key,
+		dup.FirstSeen,
+		strings.Join(dup.Columns, " "),
+		record,
+	})
+}
+
+func (cw *ConflictWriter) Close() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	cw.w.Flush()
+	if err := cw.w.Error(); err != nil {
+		cw.file.Close()
+		return err
+	}
+	return cw.file.Close()
+}
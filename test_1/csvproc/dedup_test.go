@@ -0,0 +1,185 @@
+package csvproc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeduperPolicies(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.csv": "ID,Name,Age\n1,Ana,30\n2,Budi,40\n3,Citra,50\n",
+		"b.csv": "ID,Name,Age\n2,Budi,40\n3,Citra,55\n4,Dewi,60\n",
+	}
+	var paths []string
+	for _, name := range []string{"a.csv", "b.csv"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	type counts struct{ valid, duplicates, conflicts int }
+	tests := []struct {
+		policy    string
+		emitted   []string // rows reaching the sink, in order
+		ageSum    float64
+		perFile   map[string]counts
+		conflicts int // rows in the conflicts file
+	}{
+		{
+			policy:  DedupKeepFirst,
+			emitted: []string{"a.csv:2", "a.csv:3", "a.csv:4", "b.csv:4"},
+			ageSum:  180,
+			perFile: map[string]counts{"a.csv": {3, 0, 0}, "b.csv": {3, 2, 1}},
+		},
+		{
+			policy:  DedupKeepLast,
+			emitted: []string{"a.csv:2", "b.csv:2", "b.csv:3", "b.csv:4"},
+			ageSum:  185,
+			perFile: map[string]counts{"a.csv": {3, 2, 1}, "b.csv": {3, 0, 0}},
+		},
+		{
+			policy:    DedupConflicts,
+			emitted:   []string{"a.csv:2", "a.csv:3", "a.csv:4", "b.csv:4"},
+			ageSum:    180,
+			perFile:   map[string]counts{"a.csv": {3, 0, 0}, "b.csv": {3, 2, 1}},
+			conflicts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			// Either file may be processed first
+			for run := range 10 {
+				var conflicts *ConflictWriter
+				conflictsPath := filepath.Join(t.TempDir(), "conflicts.csv")
+				if tt.policy == DedupConflicts {
+					var err error
+					if conflicts, err = NewConflictWriter(conflictsPath); err != nil {
+						t.Fatal(err)
+					}
+				}
+				d, err := NewDeduper([]string{"ID"}, tt.policy, conflicts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				agg, err := NewAggregator("", "sum:Age")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var mu sync.Mutex
+				var emitted []string
+				pipeline := NewPipeline()
+				pipeline.Transform = d.Stage
+				pipeline.Sink = func(ctx context.Context, row *Row) error {
+					mu.Lock()
+					defer mu.Unlock()
+					emitted = append(emitted, fmt.Sprintf("%s:%d", row.File, row.Line))
+					return nil
+				}
+
+				p := New(WithWorkers(2), WithHandler(pipeline), WithAggregator(agg), WithLogOutput(io.Discard))
+				p.ProcessFiles(paths)
+				if len(emitted) > 0 {
+					t.Fatalf("run %d: rows emitted before the flush: %v", run, emitted)
+				}
+				if err := p.FlushHeld(context.Background(), d, pipeline.Sink); err != nil {
+					t.Fatal(err)
+				}
+
+				if !slices.Equal(emitted, tt.emitted) {
+					t.Errorf("run %d: emitted %v, want %v", run, emitted, tt.emitted)
+				}
+				if got := agg.Result().Groups[0].Values[0]; got != tt.ageSum {
+					t.Errorf("run %d: sum of ages is %v, want %v", run, got, tt.ageSum)
+				}
+				report := p.Report(time.Now(), time.Now())
+				for _, f := range report.Files {
+					want := tt.perFile[f.File]
+					if got := (counts{f.ValidRows, f.Duplicates, f.Conflicts}); got != want {
+						t.Errorf("run %d: %s has %+v, want %+v", run, f.File, got, want)
+					}
+				}
+				if report.Totals.Duplicates != 2 || report.Totals.Conflicts != 1 {
+					t.Errorf("run %d: totals have %d duplicates, %d conflicts, want 2, 1", run, report.Totals.Duplicates, report.Totals.Conflicts)
+				}
+
+				if conflicts != nil {
+					if err := conflicts.Close(); err != nil {
+						t.Fatal(err)
+					}
+					data, err := os.ReadFile(conflictsPath)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if rows := strings.Count(string(data), "\n") - 1; rows != tt.conflicts {
+						t.Errorf("run %d: conflicts file has %d rows, want %d", run, rows, tt.conflicts)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDeduperFail(t *testing.T) {
+	d, err := NewDeduper([]string{"ID"}, DedupFail, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := NewHeader([]string{"ID", "Name"})
+	row := func(fileNum int, id, name string) *Row {
+		return &Row{File: fmt.Sprintf("%d.csv", fileNum), FileNum: fileNum, Line: 2, Header: header, Fields: []string{id, name}}
+	}
+
+	tests := []struct {
+		row       *Row
+		dup       bool
+		fatal     bool
+		invalid   bool
+		at, first string // positions named by the error
+	}{
+		{row(1, "1", "Ana"), false, false, false, "", ""},
+		{row(2, "1", "Ana"), true, false, false, "2.csv:2", "1.csv:2"},
+		{row(3, "1", "Budi"), true, true, false, "3.csv:2", "1.csv:2"},
+		{row(4, " ", "Citra"), false, false, true, "", ""},
+		{row(6, "2", "Dewi"), false, false, false, "", ""},
+		// The row arriving second fails, yet the error names the later row
+		// in input order as the duplicate
+		{row(5, "2", "Dian"), true, true, false, "6.csv:2", "5.csv:2"},
+	}
+	for i, tt := range tests {
+		err := d.Stage(context.Background(), tt.row)
+		var dup *DuplicateError
+		if got := errors.As(err, &dup); got != tt.dup || (got && dup.Fatal != tt.fatal) {
+			t.Errorf("row %d: got %v, want duplicate %v, fatal %v", i, err, tt.dup, tt.fatal)
+		}
+		if dup != nil && (dup.Row != tt.at || dup.FirstSeen != tt.first) {
+			t.Errorf("row %d: got duplicate %s of %s, want %s of %s", i, dup.Row, dup.FirstSeen, tt.at, tt.first)
+		}
+		var verr *ValidationError
+		if got := errors.As(err, &verr); got != tt.invalid {
+			t.Errorf("row %d: got %v, want invalid %v", i, err, tt.invalid)
+		}
+		if !tt.dup && !tt.invalid && err != nil {
+			t.Errorf("row %d: got %v, want it passed on", i, err)
+		}
+	}
+
+	// Rows that are not held are not kept either
+	for key, seen := range d.seen {
+		if seen.row != nil {
+			t.Errorf("key %s keeps its row", key)
+		}
+	}
+}
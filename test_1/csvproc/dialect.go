@@ -0,0 +1,400 @@
+package csvproc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"gopkg.in/yaml.v3"
+)
+
+// Encodings recognised by SniffDialect.
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	EncodingLatin1  = "latin-1"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// sniffSize is how much of an input is inspected by SniffDialect.
+const sniffSize = 64 << 10
+
+// Dialect describes how a CSV input is encoded, delimited and quoted.
+type Dialect struct {
+	Delimiter rune
+	Quote     rune // '"' or '\''
+	Encoding  string
+	BOM       bool // the input starts with a byte order mark
+}
+
+// SniffDialect guesses the dialect from the first bytes of an input. The
+// delimiter and quote are the combination that splits the sample lines
+// into the most consistent number of fields.
+func SniffDialect(sample []byte) Dialect {
+	d := Dialect{Delimiter: ',', Quote: '"', Encoding: EncodingUTF8}
+	switch {
+	case bytes.HasPrefix(sample, bomUTF8):
+		d.BOM = true
+	case bytes.HasPrefix(sample, bomUTF16LE):
+		d.Encoding, d.BOM = EncodingUTF16LE, true
+	case bytes.HasPrefix(sample, bomUTF16BE):
+		d.Encoding, d.BOM = EncodingUTF16BE, true
+	default:
+		d.Encoding = sniffEncoding(sample)
+	}
+
+	text, err := io.ReadAll(d.decode(bytes.NewReader(sample)))
+	if err != nil && len(text) == 0 {
+		return d
+	}
+	d.Delimiter, d.Quote = sniffDelimiter(string(text))
+	return d
+}
+
+// sniffEncoding tells UTF-16 without a BOM apart by its zero bytes, and
+// Latin-1 from UTF-8 by invalid sequences.
+func sniffEncoding(sample []byte) string {
+	var even, odd int
+	for i, b := range sample {
+		if b == 0 {
+			if i%2 == 0 {
+				even++
+			} else {
+				odd++
+			}
+		}
+	}
+	switch half := len(sample) / 4; {
+	case half > 0 && odd > half:
+		return EncodingUTF16LE
+	case half > 0 && even > half:
+		return EncodingUTF16BE
+	}
+
+	// The sample may end in the middle of a character
+	for i := 1; i < utf8.UTFMax && len(sample) > 0 && !utf8.Valid(sample); i++ {
+		sample = sample[:len(sample)-1]
+	}
+	if utf8.Valid(sample) {
+		return EncodingUTF8
+	}
+	return EncodingLatin1
+}
+
+func sniffDelimiter(text string) (rune, rune) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) > 1 {
+		lines = lines[:len(lines)-1] // possibly cut off by the sample size
+	}
+	if len(lines) > 50 {
+		lines = lines[:50]
+	}
+
+	bestDelim, bestQuote, bestScore, bestFields := ',', '"', -1.0, 0
+	for _, quote := range []rune{'"', '\''} {
+		for _, delim := range []rune{',', ';', '\t', '|'} {
+			counts := make(map[int]int)
+			total := 0
+			for _, line := range lines {
+				if strings.TrimSpace(line) == "" {
+					continue
+				}
+				counts[countFields(line, delim, quote)]++
+				total++
+			}
+			if total == 0 {
+				continue
+			}
+
+			fields, n := 0, 0
+			for f, c := range counts {
+				if c > n || (c == n && f > fields) {
+					fields, n = f, c
+				}
+			}
+			if fields < 2 {
+				continue
+			}
+			score := float64(n) / float64(total)
+			if score > bestScore || (score == bestScore && fields > bestFields) {
+				bestDelim, bestQuote, bestScore, bestFields = delim, quote, score, fields
+			}
+		}
+	}
+	return bestDelim, bestQuote
+}
+
+// countFields counts the fields of a line, ignoring delimiters inside
+// quoted fields. A quote only opens a field at its start.
+func countFields(line string, delim, quote rune) int {
+	fields, inQuotes, start := 1, false, true
+	for _, c := range line {
+		switch {
+		case inQuotes:
+			if c == quote {
+				inQuotes = false
+			}
+		case c == delim:
+			fields++
+			start = true
+			continue
+		case c == quote && start:
+			inQuotes = true
+		}
+		start = false
+	}
+	return fields
+}
+
+// Seekable reports whether offsets in the parsed text are offsets in the
+// input, which is needed to resume a file by seeking. It is not the case
+// when the input has to be converted to UTF-8.
+func (d Dialect) Seekable() bool {
+	return d.Encoding == EncodingUTF8
+}
+
+// Splittable reports whether the input can be split into chunks, which
+// are cut on newlines outside of double quoted fields.
+func (d Dialect) Splittable() bool {
+	return d.Seekable() && d.Quote == '"'
+}
+
+func (d Dialect) encoding() encoding.Encoding {
+	switch d.Encoding {
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	case EncodingLatin1:
+		return charmap.ISO8859_1
+	}
+	return nil
+}
+
+// decode converts r to UTF-8 without a BOM. encoding/csv only knows double
+// quotes, so with single quotes the two are swapped and restored by fields.
+func (d Dialect) decode(r io.Reader) io.Reader {
+	if d.BOM {
+		r = &bomSkipper{r: r}
+	}
+	if enc := d.encoding(); enc != nil {
+		r = transform.NewReader(r, enc.NewDecoder())
+	}
+	if d.Quote == '\'' {
+		r = quoteSwapper{r}
+	}
+	return r
+}
+
+// fields undoes the quote swapping of decode.
+func (d Dialect) fields(record []string) []string {
+	if d.Quote == '\'' {
+		for i, f := range record {
+			record[i] = swapQuotes(f)
+		}
+	}
+	return record
+}
+
+// bomSkipper drops a byte order mark at the start of the stream.
+type bomSkipper struct {
+	r    io.Reader
+	done bool
+}
+
+func (b *bomSkipper) Read(p []byte) (int, error) {
+	if !b.done {
+		b.done = true
+		head := make([]byte, 3)
+		n, err := io.ReadFull(b.r, head)
+		head = head[:n]
+		for _, bom := range [][]byte{bomUTF8, bomUTF16LE, bomUTF16BE} {
+			if bytes.HasPrefix(head, bom) {
+				head = head[len(bom):]
+				break
+			}
+		}
+		if len(head) > 0 || (err != nil && err != io.ErrUnexpectedEOF) {
+			b.r = io.MultiReader(bytes.NewReader(head), b.r)
+		}
+	}
+	return b.r.Read(p)
+}
+
+type quoteSwapper struct{ r io.Reader }
+
+func (q quoteSwapper) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	for i := range p[:n] {
+		switch p[i] {
+		case '"':
+			p[i] = '\''
+		case '\'':
+			p[i] = '"'
+		}
+	}
+	return n, err
+}
+
+func swapQuotes(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '"':
+			return '\''
+		case '\'':
+			return '"'
+		}
+		return r
+	}, s)
+}
+
+// CSVOptions controls how inputs are read. Zero values are sniffed from the
+// input or take the usual defaults.
+type CSVOptions struct {
+	Delimiter rune
+	Quote     rune
+	Encoding  string   // "" or "auto" to sniff
+	NoHeader  bool     // the first record is data
+	Columns   []string // column names of inputs without a header
+	Mapping   ColumnMap
+}
+
+// Dialect sniffs the dialect of the file behind job and applies the
+// explicitly configured parts on top.
+func (o CSVOptions) Dialect(job FileJob) (Dialect, error) {
+	whole := job
+	whole.Offset, whole.Length = 0, 0
+	input, err := openInput(whole)
+	if err != nil {
+		return Dialect{}, err
+	}
+	defer input.Close()
+
+	sample, err := io.ReadAll(io.LimitReader(input, sniffSize))
+	if err != nil {
+		return Dialect{}, err
+	}
+	d := SniffDialect(sample)
+	if o.Delimiter != 0 {
+		d.Delimiter = o.Delimiter
+	}
+	if o.Quote != 0 {
+		d.Quote = o.Quote
+	}
+	if o.Encoding != "" && o.Encoding != "auto" {
+		d.Encoding = o.Encoding
+	}
+	return d, nil
+}
+
+// ParseDelimiter accepts a single character or one of the names comma,
+// semicolon, tab and pipe. An empty string means "sniff".
+func ParseDelimiter(s string) (rune, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return 0, nil
+	case "comma":
+		return ',', nil
+	case "semicolon":
+		return ';', nil
+	case "tab", `\t`:
+		return '\t', nil
+	case "pipe":
+		return '|', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) || r == '\n' || r == '\r' || r == '"' {
+		return 0, fmt.Errorf("invalid delimiter %q", s)
+	}
+	return r, nil
+}
+
+// ParseEncoding normalises the name of a supported encoding.
+func ParseEncoding(s string) (string, error) {
+	switch strings.ToLower(strings.ReplaceAll(s, "_", "-")) {
+	case "", "auto":
+		return "", nil
+	case "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case "utf-16le", "utf16le":
+		return EncodingUTF16LE, nil
+	case "utf-16be", "utf16be":
+		return EncodingUTF16BE, nil
+	case "latin-1", "latin1", "iso-8859-1":
+		return EncodingLatin1, nil
+	}
+	return "", fmt.Errorf("unsupported encoding %q", s)
+}
+
+// ColumnMap renames input columns to the logical columns of the schema,
+// e.g. {"user_email": "Email"}. Keys match case-insensitively.
+type ColumnMap map[string]string
+
+// LoadColumnMap reads a column map from a .json, .yaml or .yml file.
+func LoadColumnMap(path string) (ColumnMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m ColumnMap
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &m)
+	default:
+		err = json.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse column map %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Apply returns the logical names of columns. Columns without a mapping
+// that equal one of known apart from case and surrounding space are
+// renamed to it; the rest are kept.
+func (m ColumnMap) Apply(columns []string, known []string) []string {
+	out := make([]string, len(columns))
+	for i, col := range columns {
+		col = strings.TrimSpace(col)
+		out[i] = col
+		if to, ok := m.lookup(col); ok {
+			out[i] = to
+			continue
+		}
+		for _, k := range known {
+			if strings.EqualFold(col, k) {
+				out[i] = k
+				break
+			}
+		}
+	}
+	return out
+}
+
+func (m ColumnMap) lookup(col string) (string, bool) {
+	if to, ok := m[col]; ok {
+		return to, true
+	}
+	for from, to := range m {
+		if strings.EqualFold(from, col) {
+			return to, true
+		}
+	}
+	return "", false
+}
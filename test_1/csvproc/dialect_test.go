@@ -0,0 +1,163 @@
+package csvproc
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func utf16Bytes(t *testing.T, s string, order unicode.Endianness, bom unicode.BOMPolicy) []byte {
+	t.Helper()
+	b, err := unicode.UTF16(order, bom).NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func latin1Bytes(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := charmap.ISO8859_1.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+const dialectSample = "ID,Name,City\n1,José,\"Jakarta, Pusat\"\n2,Zoë,Bandung\n"
+
+func TestSniffDialect(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample []byte
+		want   Dialect
+	}{
+		{"comma", []byte(dialectSample), Dialect{',', '"', EncodingUTF8, false}},
+		{"semicolon", []byte("ID;Name;Note\n1;Ana;\"a; b\"\n2;Budi;c\n"), Dialect{';', '"', EncodingUTF8, false}},
+		{"tab", []byte("ID\tName\n1\tAna\n2\tBudi\n"), Dialect{'\t', '"', EncodingUTF8, false}},
+		{"pipe", []byte("ID|Name|City\n1|Ana|Jakarta\n"), Dialect{'|', '"', EncodingUTF8, false}},
+		{"single quotes", []byte("ID,Name,City\n1,Ana,'Jakarta, Pusat'\n2,Budi,'Bandung, Barat'\n"), Dialect{',', '\'', EncodingUTF8, false}},
+		{"delimiter only inside quotes", []byte("ID,Note\n1,\"a;b;c;d\"\n2,\"e;f;g;h\"\n"), Dialect{',', '"', EncodingUTF8, false}},
+		{"utf-8 bom", append(slices.Clone(bomUTF8), dialectSample...), Dialect{',', '"', EncodingUTF8, true}},
+		{"utf-16le bom", utf16Bytes(t, dialectSample, unicode.LittleEndian, unicode.UseBOM), Dialect{',', '"', EncodingUTF16LE, true}},
+		{"utf-16be bom", utf16Bytes(t, dialectSample, unicode.BigEndian, unicode.UseBOM), Dialect{',', '"', EncodingUTF16BE, true}},
+		{"utf-16le", utf16Bytes(t, dialectSample, unicode.LittleEndian, unicode.IgnoreBOM), Dialect{',', '"', EncodingUTF16LE, false}},
+		{"utf-16be", utf16Bytes(t, dialectSample, unicode.BigEndian, unicode.IgnoreBOM), Dialect{',', '"', EncodingUTF16BE, false}},
+		{"latin-1", latin1Bytes(t, dialectSample), Dialect{',', '"', EncodingLatin1, false}},
+		// A multi-byte character cut off by the sample size is still UTF-8
+		{"utf-8 cut off", []byte(dialectSample + "3,Zo\xc3"), Dialect{',', '"', EncodingUTF8, false}},
+		{"empty", nil, Dialect{',', '"', EncodingUTF8, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SniffDialect(tt.sample); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVOptionsDialect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.csv")
+	if err := os.WriteFile(path, []byte("ID;Name\n1;Ana\n2;Budi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	job := FileJob{FilePath: path, FileNum: 1}
+
+	tests := []struct {
+		name string
+		opts CSVOptions
+		want Dialect
+	}{
+		{"sniffed", CSVOptions{}, Dialect{';', '"', EncodingUTF8, false}},
+		{"auto encoding", CSVOptions{Encoding: "auto"}, Dialect{';', '"', EncodingUTF8, false}},
+		{"explicit", CSVOptions{Delimiter: ',', Quote: '\'', Encoding: EncodingLatin1}, Dialect{',', '\'', EncodingLatin1, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.Dialect(job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessDialects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		city string // of the second row
+	}{
+		{"utf-8 bom", append(slices.Clone(bomUTF8), dialectSample...), "Bandung"},
+		{"utf-16le bom", utf16Bytes(t, dialectSample, unicode.LittleEndian, unicode.UseBOM), "Bandung"},
+		{"utf-16be", utf16Bytes(t, dialectSample, unicode.BigEndian, unicode.IgnoreBOM), "Bandung"},
+		{"latin-1", latin1Bytes(t, dialectSample), "Bandung"},
+		{"semicolon, single quotes", []byte("ID;Name;City\n1;José;'Jakarta, Pusat'\n2;Zoë;'Bandung; Barat'\n"), "Bandung; Barat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.csv")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var mu sync.Mutex
+			var got [][]string
+			p := New(WithWorkers(1), WithLogOutput(io.Discard), WithHandler(RowHandlerFunc(func(ctx context.Context, row *Row) error {
+				mu.Lock()
+				defer mu.Unlock()
+				got = append(got, []string{row.Get("ID"), row.Get("Name"), row.Get("City")})
+				return nil
+			})))
+			results := p.ProcessFiles([]string{path})
+			if err := results[0].Error; err != nil {
+				t.Fatal(err)
+			}
+			want := [][]string{{"1", "José", "Jakarta, Pusat"}, {"2", "Zoë", tt.city}}
+			if !slices.EqualFunc(got, want, slices.Equal) {
+				t.Errorf("got rows %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSplitJobSniffsOnce(t *testing.T) {
+	path := writeCSV(t, 500)
+	p := New(WithChunkSize(512), WithLogOutput(io.Discard))
+
+	chunks, err := p.splitJob(FileJob{FilePath: path, FileNum: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want several", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if chunk.dialect != chunks[0].dialect || chunk.dialect == nil {
+			t.Fatalf("chunk %d has dialect %v, want the one of the input", chunk.Chunk, chunk.dialect)
+		}
+	}
+
+	// The cached dialect is used even once the input no longer matches it
+	if err := os.WriteFile(path, []byte("ID;Name\n1;Ana\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.dialect(chunks[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Delimiter != ',' {
+		t.Errorf("dialect of a chunk was sniffed again: %+v", got)
+	}
+}
@@ -0,0 +1,620 @@
+package csvproc
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Kinds of changes reported by a Differ.
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// Diff output formats accepted by NewDiffWriter.
+const (
+	DiffJSONL = "jsonl"
+	DiffCSV   = "csv"
+)
+
+// MaxDiffPartitions bounds the partitions per snapshot. Every partition
+// keeps a spill file open, both snapshots together stay well below the
+// usual limit of 1024 open files.
+const MaxDiffPartitions = 250
+
+// DiffOptions configures a Differ.
+type DiffOptions struct {
+	Key []string // key columns, e.g. ID or Email
+
+	// Partitions is the number of hash partitions each snapshot is spilled
+	// to. Only one partition per snapshot is held in memory at a time, so
+	// more partitions bound memory for larger inputs. At most
+	// MaxDiffPartitions.
+	Partitions int
+
+	// MemoryBudget bounds the memory of comparing one partition, taken to
+	// be three times the size of its spill files. A partition above it is
+	// split again by another hash before it is compared, as happens when
+	// Partitions was estimated from compressed inputs. 0 means no bound.
+	MemoryBudget int64
+	TempDir      string // where partitions are spilled, os.TempDir() by default
+}
+
+// ColumnChange is a value that differs between the snapshots.
+type ColumnChange struct {
+	Column string `json:"column"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+// DiffEntry is a row that was added, removed or modified. Added rows carry
+// New, removed rows Old and modified rows their Changes.
+type DiffEntry struct {
+	Change  string
+	Key     string
+	Columns []string // names of the values in Old or New
+	Old     []string
+	New     []string
+	Changes []ColumnChange
+}
+
+// DiffStats sums up a comparison.
+type DiffStats struct {
+	Added      int `json:"added"`
+	Removed    int `json:"removed"`
+	Modified   int `json:"modified"`
+	Unchanged  int `json:"unchanged"`
+	Duplicates int `json:"duplicates"`  // later rows with a key already seen in the same snapshot
+	MissingKey int `json:"missing_key"` // rows with an empty key
+	Splits     int `json:"splits"`      // partitions split again to stay within the memory budget
+
+	// Columns present in only one of the snapshots. They are not compared.
+	OnlyOld []string `json:"only_old,omitempty"`
+	OnlyNew []string `json:"only_new,omitempty"`
+}
+
+// Differ compares two snapshots of CSV files by key. Rows of each snapshot
+// are fed in through the RowHandlers returned by Old and New, typically by
+// two processors running concurrently, and spilled to hash partition files.
+// Compare then diffs the snapshots one partition at a time.
+type Differ struct {
+	key      []string
+	dir      string
+	budget   int64
+	old, new *spillSide
+}
+
+func NewDiffer(opts DiffOptions) (*Differ, error) {
+	if len(opts.Key) == 0 {
+		return nil, errors.New("diff needs at least one key column")
+	}
+	if opts.Partitions > MaxDiffPartitions {
+		return nil, fmt.Errorf("diff takes at most %d partitions per snapshot", MaxDiffPartitions)
+	}
+	dir, err := os.MkdirTemp(opts.TempDir, "csvproc-diff-")
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Differ{key: opts.Key, dir: dir, budget: opts.MemoryBudget}
+	partitions := max(opts.Partitions, 1)
+	if d.old, err = newSpillSide(dir, "old", opts.Key, partitions); err == nil {
+		d.new, err = newSpillSide(dir, "new", opts.Key, partitions)
+	}
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Old returns the handler for rows of the old snapshot.
+func (d *Differ) Old() RowHandler { return d.old }
+
+// New returns the handler for rows of the new snapshot.
+func (d *Differ) New() RowHandler { return d.new }
+
+// Close removes the spilled partitions.
+func (d *Differ) Close() error {
+	for _, side := range []*spillSide{d.old, d.new} {
+		if side != nil {
+			side.close()
+		}
+	}
+	return os.RemoveAll(d.dir)
+}
+
+// Compare writes the differences to w, partition by partition and sorted by
+// key within a partition. Only one partition of each snapshot is held in
+// memory at a time. It must be called once all rows were handled.
+func (d *Differ) Compare(w DiffWriter) (DiffStats, error) {
+	var stats DiffStats
+	for _, side := range []*spillSide{d.old, d.new} {
+		if err := side.close(); err != nil {
+			return stats, err
+		}
+		stats.MissingKey += int(side.missingKey.Load())
+	}
+
+	// Columns are compared by name, at their position in either snapshot
+	oldCols, newCols := d.old.columns, d.new.columns
+	var common []diffColumn
+	for i, col := range oldCols {
+		if j := slices.Index(newCols, col); j >= 0 {
+			common = append(common, diffColumn{col, i, j})
+		} else {
+			stats.OnlyOld = append(stats.OnlyOld, col)
+		}
+	}
+	for _, col := range newCols {
+		if !slices.Contains(oldCols, col) {
+			stats.OnlyNew = append(stats.OnlyNew, col)
+		}
+	}
+
+	cmp := &comparison{w: w, stats: &stats, common: common, oldCols: oldCols, newCols: newCols}
+	for p := range d.old.parts {
+		if err := d.comparePartition(cmp, d.old.parts[p].path, d.new.parts[p].path, 0); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// comparison is the state shared by the partitions of one Compare.
+type comparison struct {
+	w                DiffWriter
+	stats            *DiffStats
+	common           []diffColumn
+	oldCols, newCols []string
+}
+
+// diffColumn is a column of both snapshots with its position in either.
+type diffColumn struct {
+	name     string
+	old, new int
+}
+
+// maxSplitLevel bounds how often a partition is split again. Rows sharing
+// a key always land in the same partition, so splitting may not help.
+const maxSplitLevel = 2
+
+// comparePartition diffs one partition of both snapshots. A partition
+// whose spill files exceed the memory budget is split into smaller ones
+// first, which are compared and removed one at a time.
+func (d *Differ) comparePartition(cmp *comparison, oldPath, newPath string, level int) error {
+	if n := d.splitCount(oldPath, newPath); n > 1 && level < maxSplitLevel {
+		cmp.stats.Splits++
+		oldParts, err := splitSpill(oldPath, n, level+1)
+		if err != nil {
+			return err
+		}
+		newParts, err := splitSpill(newPath, n, level+1)
+		if err != nil {
+			return err
+		}
+		for i := range n {
+			err := d.comparePartition(cmp, oldParts[i], newParts[i], level+1)
+			os.Remove(oldParts[i])
+			os.Remove(newParts[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	oldRows, dups, err := loadSpill(oldPath)
+	if err != nil {
+		return err
+	}
+	cmp.stats.Duplicates += dups
+	newRows, dups, err := loadSpill(newPath)
+	if err != nil {
+		return err
+	}
+	cmp.stats.Duplicates += dups
+
+	keys := make([]string, 0, len(oldRows)+len(newRows))
+	for key := range oldRows {
+		keys = append(keys, key)
+	}
+	for key := range newRows {
+		if _, ok := oldRows[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	stats, oldCols, newCols := cmp.stats, cmp.oldCols, cmp.newCols
+	for _, key := range keys {
+		prev, inOld := oldRows[key]
+		next, inNew := newRows[key]
+
+		var e DiffEntry
+		switch {
+		case !inOld:
+			stats.Added++
+			e = DiffEntry{Change: ChangeAdded, Key: displayKey(key), Columns: newCols, New: pad(next.values, len(newCols))}
+		case !inNew:
+			stats.Removed++
+			e = DiffEntry{Change: ChangeRemoved, Key: displayKey(key), Columns: oldCols, Old: pad(prev.values, len(oldCols))}
+		default:
+			var changes []ColumnChange
+			for _, c := range cmp.common {
+				o, n := field(prev.values, c.old), field(next.values, c.new)
+				if o != n {
+					changes = append(changes, ColumnChange{Column: c.name, Old: o, New: n})
+				}
+			}
+			if len(changes) == 0 {
+				stats.Unchanged++
+				continue
+			}
+			stats.Modified++
+			e = DiffEntry{Change: ChangeModified, Key: displayKey(key), Changes: changes}
+		}
+		if err := cmp.w.Write(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitCount returns into how many partitions the spill files must be split
+// to compare them within the memory budget.
+func (d *Differ) splitCount(paths ...string) int {
+	if d.budget <= 0 {
+		return 1
+	}
+	var size int64
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+	return int(min(3*size/d.budget+1, MaxDiffPartitions))
+}
+
+// splitSpill distributes the rows of a spill file over n new ones, hashed
+// at the given level so rows of one partition do not all hash alike again.
+func splitSpill(path string, n, level int) ([]string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	paths := make([]string, n)
+	files := make([]*os.File, n)
+	writers := make([]*csv.Writer, n)
+	closeAll := func() error {
+		var first error
+		for i, file := range files {
+			if file == nil {
+				continue
+			}
+			writers[i].Flush()
+			err := writers[i].Error()
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil && first == nil {
+				first = fmt.Errorf("spill %s: %w", paths[i], err)
+			}
+		}
+		return first
+	}
+	for i := range n {
+		paths[i] = fmt.Sprintf("%s.%d-%04d", strings.TrimSuffix(path, ".csv"), level, i)
+		if files[i], err = os.Create(paths[i]); err != nil {
+			closeAll()
+			return nil, err
+		}
+		writers[i] = csv.NewWriter(bufio.NewWriter(files[i]))
+	}
+
+	r := csv.NewReader(bufio.NewReader(in))
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("read spill %s: %w", path, err)
+		}
+		if err := writers[partitionOf(record[0], level, n)].Write(record); err != nil {
+			closeAll()
+			return nil, err
+		}
+	}
+	return paths, closeAll()
+}
+
+// partitionOf hashes a key to one of n partitions. Every level of splitting
+// uses a different hash.
+func partitionOf(key string, level, n int) int {
+	h := fnv.New32a()
+	if level > 0 {
+		h.Write([]byte{byte(level)})
+	}
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// spilledRow is a row read back from a partition.
+type spilledRow struct {
+	fileNum, line int
+	values        []string
+}
+
+// loadSpill reads a partition into memory. Of rows sharing a key the one
+// that comes first in the input is kept, whichever worker spilled it first.
+func loadSpill(path string) (map[string]spilledRow, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	rows := make(map[string]spilledRow)
+	dups := 0
+	r := csv.NewReader(bufio.NewReader(file))
+	r.FieldsPerRecord = -1
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return rows, dups, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("read spill %s: %w", path, err)
+		}
+
+		row := spilledRow{values: record[3:]}
+		row.fileNum, _ = strconv.Atoi(record[1])
+		row.line, _ = strconv.Atoi(record[2])
+		if prev, ok := rows[record[0]]; ok {
+			dups++
+			if prev.fileNum < row.fileNum || prev.fileNum == row.fileNum && prev.line < row.line {
+				continue
+			}
+		}
+		rows[record[0]] = row
+	}
+}
+
+// displayKey joins the parts of a composite key with commas.
+func displayKey(key string) string {
+	return strings.ReplaceAll(key, keySep, ",")
+}
+
+func field(values []string, i int) string {
+	if i < len(values) {
+		return values[i]
+	}
+	return ""
+}
+
+// pad extends values written before later columns were discovered.
+func pad(values []string, n int) []string {
+	for len(values) < n {
+		values = append(values, "")
+	}
+	return values
+}
+
+// keySep separates the parts of a composite key in the spill files.
+const keySep = "\x1f"
+
+// spillSide spills the rows of one snapshot to its hash partitions. Columns
+// are stored in the order they were first seen across all files of the
+// snapshot, so files with differently ordered headers line up.
+type spillSide struct {
+	key        []string
+	mu         sync.Mutex
+	columns    []string
+	parts      []*spillPart
+	missingKey atomic.Int64
+}
+
+type spillPart struct {
+	path   string
+	mu     sync.Mutex
+	file   *os.File
+	w      *csv.Writer
+	closed bool
+}
+
+func newSpillSide(dir, name string, key []string, partitions int) (*spillSide, error) {
+	s := &spillSide{key: key}
+	for i := range partitions {
+		path := filepath.Join(dir, fmt.Sprintf("%s-%04d.csv", name, i))
+		file, err := os.Create(path)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.parts = append(s.parts, &spillPart{path: path, file: file, w: csv.NewWriter(file)})
+	}
+	return s, nil
+}
+
+func (s *spillSide) HandleRow(ctx context.Context, row *Row) error {
+	parts := make([]string, len(s.key))
+	for i, col := range s.key {
+		if _, ok := row.Header.Index(col); !ok {
+			return fmt.Errorf("key column %q missing from header", col)
+		}
+		parts[i] = strings.TrimSpace(row.Get(col))
+	}
+	key := strings.Join(parts, keySep)
+	if strings.Trim(key, keySep) == "" {
+		s.missingKey.Add(1)
+		return nil
+	}
+
+	record := append([]string{key, strconv.Itoa(row.FileNum), strconv.Itoa(row.Line)}, s.values(row)...)
+	p := s.parts[partitionOf(key, 0, len(s.parts))]
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.w.Write(record)
+}
+
+// values returns the fields of row in the column order of the snapshot.
+func (s *spillSide) values(row *Row) []string {
+	s.mu.Lock()
+	for _, col := range row.Header.Columns {
+		if !slices.Contains(s.columns, col) {
+			s.columns = append(s.columns, col)
+		}
+	}
+	columns := s.columns
+	s.mu.Unlock()
+
+	values := make([]string, len(columns))
+	for i, col := range columns {
+		values[i] = row.Get(col)
+	}
+	return values
+}
+
+// close flushes and closes the partition files. It is safe to call twice.
+func (s *spillSide) close() error {
+	var first error
+	for _, p := range s.parts {
+		p.mu.Lock()
+		if !p.closed {
+			p.closed = true
+			p.w.Flush()
+			err := p.w.Error()
+			if cerr := p.file.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil && first == nil {
+				first = fmt.Errorf("spill %s: %w", p.path, err)
+			}
+		}
+		p.mu.Unlock()
+	}
+	return first
+}
+
+// DiffWriter receives the entries produced by Differ.Compare.
+type DiffWriter interface {
+	Write(e DiffEntry) error
+	Close() error
+}
+
+// NewDiffWriter writes entries to out as JSON lines or as CSV with one line
+// per column: change, key, column, old, new.
+func NewDiffWriter(out io.WriteCloser, format string) (DiffWriter, error) {
+	switch format {
+	case DiffJSONL, "":
+		return &jsonlDiffWriter{out: out, w: bufio.NewWriter(out)}, nil
+	case DiffCSV:
+		w := csv.NewWriter(out)
+		if err := w.Write([]string{"change", "key", "column", "old", "new"}); err != nil {
+			return nil, err
+		}
+		return &csvDiffWriter{out: out, w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown diff format %q", format)
+	}
+}
+
+type jsonlDiffWriter struct {
+	out io.WriteCloser
+	w   *bufio.Writer
+}
+
+func (d *jsonlDiffWriter) Write(e DiffEntry) error {
+	key, _ := json.Marshal(e.Key)
+	fmt.Fprintf(d.w, `{"change":%q,"key":%s`, e.Change, key)
+	switch e.Change {
+	case ChangeModified:
+		changes, _ := json.Marshal(e.Changes)
+		fmt.Fprintf(d.w, `,"changes":%s`, changes)
+	case ChangeAdded:
+		d.w.WriteString(`,"new":`)
+		writeJSONObject(d.w, e.Columns, e.New)
+	case ChangeRemoved:
+		d.w.WriteString(`,"old":`)
+		writeJSONObject(d.w, e.Columns, e.Old)
+	}
+	_, err := d.w.WriteString("}\n")
+	return err
+}
+
+// writeJSONObject writes keys and values as an object, keys in order.
+func writeJSONObject(w *bufio.Writer, keys, values []string) {
+	w.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		value, _ := json.Marshal(values[i])
+		w.Write(key)
+		w.WriteByte(':')
+		w.Write(value)
+	}
+	w.WriteByte('}')
+}
+
+func (d *jsonlDiffWriter) Close() error {
+	if err := d.w.Flush(); err != nil {
+		d.out.Close()
+		return err
+	}
+	return d.out.Close()
+}
+
+type csvDiffWriter struct {
+	out io.WriteCloser
+	w   *csv.Writer
+}
+
+func (d *csvDiffWriter) Write(e DiffEntry) error {
+	switch e.Change {
+	case ChangeModified:
+		for _, c := range e.Changes {
+			d.w.Write([]string{e.Change, e.Key, c.Column, c.Old, c.New})
+		}
+	case ChangeAdded:
+		for i, col := range e.Columns {
+			d.w.Write([]string{e.Change, e.Key, col, "", e.New[i]})
+		}
+	case ChangeRemoved:
+		for i, col := range e.Columns {
+			d.w.Write([]string{e.Change, e.Key, col, e.Old[i], ""})
+		}
+	}
+	return d.w.Error()
+}
+
+func (d *csvDiffWriter) Close() error {
+	d.w.Flush()
+	if err := d.w.Error(); err != nil {
+		d.out.Close()
+		return err
+	}
+	return d.out.Close()
+}
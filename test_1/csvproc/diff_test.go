@@ -0,0 +1,338 @@
+package csvproc
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// diffRecorder keeps the entries written by Compare as short lines.
+type diffRecorder struct {
+	lines []string
+}
+
+func (r *diffRecorder) Write(e DiffEntry) error {
+	var line string
+	switch e.Change {
+	case ChangeAdded:
+		line = fmt.Sprintf("+%s %s", e.Key, diffValues(e.Columns, e.New))
+	case ChangeRemoved:
+		line = fmt.Sprintf("-%s %s", e.Key, diffValues(e.Columns, e.Old))
+	case ChangeModified:
+		var changes []string
+		for _, c := range e.Changes {
+			changes = append(changes, fmt.Sprintf("%s:%s>%s", c.Column, c.Old, c.New))
+		}
+		line = fmt.Sprintf("~%s %s", e.Key, strings.Join(changes, " "))
+	}
+	r.lines = append(r.lines, line)
+	return nil
+}
+
+func (r *diffRecorder) Close() error { return nil }
+
+func diffValues(columns, values []string) string {
+	var pairs []string
+	for i, col := range columns {
+		pairs = append(pairs, col+"="+values[i])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// writeSnapshot writes the files of a snapshot in order and returns their
+// paths.
+func writeSnapshot(t *testing.T, files ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for i, data := range files {
+		path := filepath.Join(dir, fmt.Sprintf("%d.csv", i+1))
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// runDiff processes both snapshots and compares them. Entries of different
+// partitions come in no particular order, so they are returned sorted.
+func runDiff(t *testing.T, opts DiffOptions, old, new []string) ([]string, DiffStats) {
+	t.Helper()
+	opts.TempDir = t.TempDir()
+	d, err := NewDiffer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, side := range []struct {
+		paths   []string
+		handler RowHandler
+	}{{old, d.Old()}, {new, d.New()}} {
+		p := New(WithWorkers(3), WithHandler(side.handler), WithLogOutput(io.Discard))
+		for _, r := range p.ProcessFiles(side.paths) {
+			if r.Error != nil {
+				t.Fatalf("%s: %v", r.FileName, r.Error)
+			}
+		}
+	}
+
+	var rec diffRecorder
+	stats, err := d.Compare(&rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(rec.lines)
+	return rec.lines, stats
+}
+
+func TestDiffPartitions(t *testing.T) {
+	// IDs 1-10 are removed, every 20th ID is modified and 201-215 are added
+	var old, new strings.Builder
+	old.WriteString("ID,Name,Age\n")
+	new.WriteString("ID,Name,Age\n")
+	for id := 1; id <= 215; id++ {
+		if id <= 200 {
+			fmt.Fprintf(&old, "%d,user%d,%d\n", id, id, id)
+		}
+		age := id
+		if id%20 == 0 {
+			age++
+		}
+		if id > 10 {
+			fmt.Fprintf(&new, "%d,user%d,%d\n", id, id, age)
+		}
+	}
+	oldPaths, newPaths := writeSnapshot(t, old.String()), writeSnapshot(t, new.String())
+
+	want := DiffStats{Added: 15, Removed: 10, Modified: 10, Unchanged: 180}
+	tests := []struct {
+		name       string
+		partitions int
+		budget     int64
+		splits     int // at least
+	}{
+		{"1", 1, 0, 0},
+		{"7", 7, 0, 0},
+		{"64", 64, 0, 0},
+		{"max", MaxDiffPartitions, 0, 0},
+		{"within budget", 4, 1 << 20, 0},
+		// The spill files of both snapshots take about 7KB
+		{"split", 1, 4 << 10, 1},
+		// Both partitions and then their parts are split again
+		{"split twice", 2, 16, 3},
+	}
+	var first []string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, stats := runDiff(t, DiffOptions{Key: []string{"ID"}, Partitions: tt.partitions, MemoryBudget: tt.budget}, oldPaths, newPaths)
+			if stats.Splits < tt.splits || tt.splits == 0 && stats.Splits > 0 {
+				t.Errorf("got %d splits, want at least %d", stats.Splits, tt.splits)
+			}
+			stats.Splits = 0
+			if !statsEqual(stats, want) {
+				t.Errorf("got %+v, want %+v", stats, want)
+			}
+			if first == nil {
+				first = lines
+				if !slices.Contains(lines, "+201 ID=201 Name=user201 Age=201") || !slices.Contains(lines, "-1 ID=1 Name=user1 Age=1") || !slices.Contains(lines, "~40 Age:40>41") {
+					t.Errorf("unexpected entries %q", lines)
+				}
+			} else if !slices.Equal(lines, first) {
+				t.Errorf("entries differ from those of a single partition:\n%q\n%q", lines, first)
+			}
+		})
+	}
+}
+
+func TestDiffPartitionsSpread(t *testing.T) {
+	d, err := NewDiffer(DiffOptions{Key: []string{"ID"}, Partitions: 8, TempDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	p := New(WithWorkers(2), WithHandler(d.Old()), WithLogOutput(io.Discard))
+	p.ProcessFiles([]string{writeCSV(t, 400)})
+	if err := d.old.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0
+	for _, part := range d.old.parts {
+		rows, _, err := loadSpill(part.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) == 0 {
+			t.Errorf("partition %s is empty", part.path)
+		}
+		total += len(rows)
+	}
+	if total != 400 {
+		t.Errorf("partitions hold %d rows, want 400", total)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   []string
+		old   []string // files of the old snapshot
+		new   []string
+		want  []string
+		stats DiffStats
+	}{
+		{
+			name:  "unchanged",
+			key:   []string{"ID"},
+			old:   []string{"ID,Name\n1,Ana\n2,Budi\n"},
+			new:   []string{"ID,Name\n2,Budi\n1,Ana\n"},
+			stats: DiffStats{Unchanged: 2},
+		},
+		{
+			name: "added, removed and modified",
+			key:  []string{"ID"},
+			old:  []string{"ID,Name,Age\n1,Ana,30\n2,Budi,40\n3,Citra,50\n"},
+			new:  []string{"ID,Name,Age\n2,Budi,41\n3,Citra,50\n4,Dewi,60\n"},
+			want: []string{
+				"+4 ID=4 Name=Dewi Age=60",
+				"-1 ID=1 Name=Ana Age=30",
+				"~2 Age:40>41",
+			},
+			stats: DiffStats{Added: 1, Removed: 1, Modified: 1, Unchanged: 1},
+		},
+		{
+			name:  "key spread over files",
+			key:   []string{"ID"},
+			old:   []string{"ID,Name\n1,Ana\n", "ID,Name\n2,Budi\n"},
+			new:   []string{"ID,Name\n2,Budi\n", "ID,Name\n1,Anna\n"},
+			want:  []string{"~1 Name:Ana>Anna"},
+			stats: DiffStats{Modified: 1, Unchanged: 1},
+		},
+		{
+			name: "composite key",
+			key:  []string{"ID", "Region"},
+			old:  []string{"ID,Region,Name\n1,JKT,Ana\n1,BDG,Budi\n2,JKT,Citra\n"},
+			new:  []string{"ID,Region,Name\n1,JKT,Ana\n1,BDG,Bayu\n2,BDG,Citra\n"},
+			want: []string{
+				"+2,BDG ID=2 Region=BDG Name=Citra",
+				"-2,JKT ID=2 Region=JKT Name=Citra",
+				"~1,BDG Name:Budi>Bayu",
+			},
+			stats: DiffStats{Added: 1, Removed: 1, Modified: 1, Unchanged: 1},
+		},
+		{
+			name: "key trimmed",
+			key:  []string{"ID"},
+			old:  []string{"ID,Name\n 1 ,Ana\n"},
+			new:  []string{"ID,Name\n1,Ana\n"},
+			// The key is compared trimmed, the values as they are
+			want:  []string{"~1 ID: 1 >1"},
+			stats: DiffStats{Modified: 1},
+		},
+		{
+			// Of rows sharing a key the first in input order is kept
+			name:  "duplicate keys",
+			key:   []string{"ID"},
+			old:   []string{"ID,Name\n1,Ana\n2,Budi\n1,Anna\n", "ID,Name\n2,Bayu\n"},
+			new:   []string{"ID,Name\n1,Ana\n2,Budi\n2,Bayu\n"},
+			stats: DiffStats{Unchanged: 2, Duplicates: 3},
+		},
+		{
+			name:  "empty keys",
+			key:   []string{"ID"},
+			old:   []string{"ID,Name\n1,Ana\n,Budi\n"},
+			new:   []string{"ID,Name\n1,Ana\n  ,Citra\n,Dewi\n"},
+			stats: DiffStats{Unchanged: 1, MissingKey: 3},
+		},
+		{
+			// Only a key without any part is missing
+			name:  "empty composite keys",
+			key:   []string{"ID", "Region"},
+			old:   []string{"ID,Region\n,\n1,\n"},
+			new:   []string{"ID,Region\n,\n,JKT\n"},
+			want:  []string{"+,JKT ID= Region=JKT", "-1, ID=1 Region="},
+			stats: DiffStats{Added: 1, Removed: 1, MissingKey: 2},
+		},
+		{
+			name:  "reordered columns",
+			key:   []string{"ID"},
+			old:   []string{"ID,Name,Age\n1,Ana,30\n2,Budi,40\n"},
+			new:   []string{"Age,ID,Name\n30,1,Ana\n41,2,Budi\n"},
+			want:  []string{"~2 Age:40>41"},
+			stats: DiffStats{Modified: 1, Unchanged: 1},
+		},
+		{
+			// Files of one snapshot line up by column name
+			name:  "reordered within a snapshot",
+			key:   []string{"ID"},
+			old:   []string{"ID,Name\n1,Ana\n", "Name,ID\nBudi,2\n"},
+			new:   []string{"ID,Name\n1,Ana\n2,Bayu\n"},
+			want:  []string{"~2 Name:Budi>Bayu"},
+			stats: DiffStats{Modified: 1, Unchanged: 1},
+		},
+		{
+			name: "different columns",
+			key:  []string{"ID"},
+			old:  []string{"ID,Name,City\n1,Ana,Jakarta\n2,Budi,Bandung\n"},
+			new:  []string{"ID,Email,Name\n1,ana@example.com,Ana\n2,budi@example.com,Bayu\n3,citra@example.com,Citra\n"},
+			want: []string{
+				"+3 ID=3 Email=citra@example.com Name=Citra",
+				"~2 Name:Budi>Bayu",
+			},
+			stats: DiffStats{Added: 1, Modified: 1, Unchanged: 1, OnlyOld: []string{"City"}, OnlyNew: []string{"Email"}},
+		},
+		{
+			// Rows of files read before a later column was seen are padded
+			name:  "column added by a later file",
+			key:   []string{"ID"},
+			old:   []string{"ID,Name\n1,Ana\n"},
+			new:   []string{"ID,Name\n1,Ana\n2,Budi\n", "ID,Name,Email\n3,Citra,citra@example.com\n"},
+			want:  []string{"+2 ID=2 Name=Budi Email=", "+3 ID=3 Name=Citra Email=citra@example.com"},
+			stats: DiffStats{Added: 2, Unchanged: 1, OnlyNew: []string{"Email"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, partitions := range []int{1, 4} {
+				lines, stats := runDiff(t, DiffOptions{Key: tt.key, Partitions: partitions}, writeSnapshot(t, tt.old...), writeSnapshot(t, tt.new...))
+				if !slices.Equal(lines, tt.want) {
+					t.Errorf("%d partitions: got %q, want %q", partitions, lines, tt.want)
+				}
+				if !statsEqual(stats, tt.stats) {
+					t.Errorf("%d partitions: got %+v, want %+v", partitions, stats, tt.stats)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffMissingKeyColumn(t *testing.T) {
+	d, err := NewDiffer(DiffOptions{Key: []string{"Email"}, TempDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	p := New(WithWorkers(1), WithHandler(d.Old()), WithLogOutput(io.Discard))
+	if r := p.ProcessFiles(writeSnapshot(t, "ID,Name\n1,Ana\n"))[0]; r.Error == nil {
+		t.Error("accepted a file without the key column")
+	}
+
+	if _, err := NewDiffer(DiffOptions{}); err == nil {
+		t.Error("accepted a diff without key columns")
+	}
+	if _, err := NewDiffer(DiffOptions{Key: []string{"ID"}, Partitions: MaxDiffPartitions + 1}); err == nil {
+		t.Error("accepted too many partitions")
+	}
+}
+
+func statsEqual(a, b DiffStats) bool {
+	return a.Added == b.Added && a.Removed == b.Removed && a.Modified == b.Modified &&
+		a.Unchanged == b.Unchanged && a.Duplicates == b.Duplicates && a.MissingKey == b.MissingKey &&
+		slices.Equal(a.OnlyOld, b.OnlyOld) && slices.Equal(a.OnlyNew, b.OnlyNew)
+}
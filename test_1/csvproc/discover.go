@@ -0,0 +1,159 @@
+package csvproc
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiscoverOptions filters the files found while expanding directories and
+// glob patterns. Files named explicitly on the command line always pass the
+// extension filter.
+type DiscoverOptions struct {
+	Recursive  bool
+	Extensions []string
+	MinSize    int64
+	MaxSize    int64 // 0 means no limit
+}
+
+// DiscoverFiles expands the given files, directories and glob patterns into
+// a sorted, de-duplicated list of file paths.
+func DiscoverFiles(inputs []string, opts DiscoverOptions) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+
+	add := func(path string, info fs.FileInfo, explicit bool) {
+		if !explicit && !opts.MatchExtension(path) {
+			return
+		}
+		if !opts.matchSize(info.Size()) {
+			return
+		}
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, input := range inputs {
+		paths := []string{input}
+		explicit := true
+		if strings.ContainsAny(input, "*?[") {
+			matches, err := filepath.Glob(input)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", input, err)
+			}
+			paths, explicit = matches, false
+		}
+
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+
+			if !info.IsDir() {
+				add(path, info, explicit)
+				continue
+			}
+
+			err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					if p != path && !opts.Recursive {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				add(p, info, false)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// MatchExtension reports whether path ends in one of the extensions.
+func (o DiscoverOptions) MatchExtension(path string) bool {
+	if len(o.Extensions) == 0 {
+		return true
+	}
+	name := strings.ToLower(filepath.Base(path))
+	for _, ext := range o.Extensions {
+		if strings.HasSuffix(name, strings.ToLower(ext)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o DiscoverOptions) matchSize(size int64) bool {
+	if size < o.MinSize {
+		return false
+	}
+	return o.MaxSize == 0 || size <= o.MaxSize
+}
+
+// ParseSize parses a byte size such as "512", "64KB", "10MB" or "1GB".
+func ParseSize(input string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(input))
+	if s == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"G", 1 << 30},
+		{"M", 1 << 20},
+		{"K", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, multiplier = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", input)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q is too large", input)
+	}
+	return n * multiplier, nil
+}
+
+// SplitList splits a comma separated flag value, dropping empty items.
+func SplitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,112 @@
+package csvproc
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"", 0, true},
+		{"512", 512, true},
+		{" 64kb ", 64 << 10, true},
+		{"10MB", 10 << 20, true},
+		{"10 M", 10 << 20, true},
+		{"1G", 1 << 30, true},
+		{"3B", 3, true},
+		{"8589934591GB", 8589934591 << 30, true},
+		{"9223372036854775807", math.MaxInt64, true},
+		{"8589934592GB", 0, false}, // 2^63 bytes
+		{"9999999999GB", 0, false},
+		{"9223372036854775807K", 0, false},
+		{"9223372036854775808", 0, false},
+		{"-1KB", 0, false},
+		{"1.5MB", 0, false},
+		{"MB", 0, false},
+		{"10TB", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d, ok = %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDiscoverFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]int{ // path below dir to size
+		"a.csv":             10,
+		"b.CSV":             2000,
+		"c.csv.gz":          500,
+		"notes.txt":         10,
+		"sub/d.csv":         100,
+		"sub/deeper/e.csv":  100,
+		"sub/deeper/f.json": 100,
+		"other/g.csv":       5000,
+	}
+	for name, size := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := func(names ...string) []string {
+		paths := make([]string, len(names))
+		for i, name := range names {
+			paths[i] = filepath.Join(dir, name)
+		}
+		return paths
+	}
+	csvOnly := []string{".csv", ".csv.gz"}
+
+	tests := []struct {
+		name   string
+		inputs []string
+		opts   DiscoverOptions
+		want   []string
+	}{
+		{"directory", in(""), DiscoverOptions{Extensions: csvOnly}, in("a.csv", "b.CSV", "c.csv.gz")},
+		{"all extensions", in(""), DiscoverOptions{}, in("a.csv", "b.CSV", "c.csv.gz", "notes.txt")},
+		{"recursive", in(""), DiscoverOptions{Recursive: true, Extensions: csvOnly}, in("a.csv", "b.CSV", "c.csv.gz", "other/g.csv", "sub/d.csv", "sub/deeper/e.csv")},
+		{"recursive subdirectory", in("sub"), DiscoverOptions{Recursive: true, Extensions: csvOnly}, in("sub/d.csv", "sub/deeper/e.csv")},
+		{"glob", in("*.csv*"), DiscoverOptions{Extensions: []string{".csv"}}, in("a.csv")},
+		{"glob of directories", in("[os]*"), DiscoverOptions{Extensions: csvOnly}, in("other/g.csv", "sub/d.csv")},
+		{"glob without matches", in("*.parquet"), DiscoverOptions{}, nil},
+		// Files named explicitly skip the extension filter, not the size filter
+		{"explicit", in("notes.txt", "sub/deeper/f.json"), DiscoverOptions{Extensions: csvOnly, MaxSize: 50}, in("notes.txt")},
+		{"min size", in(""), DiscoverOptions{Recursive: true, Extensions: csvOnly, MinSize: 100}, in("b.CSV", "c.csv.gz", "other/g.csv", "sub/d.csv", "sub/deeper/e.csv")},
+		{"max size", in(""), DiscoverOptions{Recursive: true, Extensions: csvOnly, MaxSize: 100}, in("a.csv", "sub/d.csv", "sub/deeper/e.csv")},
+		{"size range", in(""), DiscoverOptions{Recursive: true, MinSize: 101, MaxSize: 2000}, in("b.CSV", "c.csv.gz")},
+		{"duplicates", in("a.csv", "*.csv", ""), DiscoverOptions{Extensions: []string{".csv"}}, in("a.csv", "b.CSV")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DiscoverFiles(tt.inputs, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := DiscoverFiles(in("missing.csv"), DiscoverOptions{}); err == nil {
+		t.Error("accepted a missing file")
+	}
+	if _, err := DiscoverFiles(in("[.csv"), DiscoverOptions{}); err == nil {
+		t.Error("accepted a malformed pattern")
+	}
+}
@@ -0,0 +1,6 @@
+package csvproc
+
+// Database drivers available to the sql sink.
+import (
+	_ "github.com/jackc/pgx/v5/stdlib" // registers "pgx"
+)
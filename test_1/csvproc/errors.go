@@ -0,0 +1,135 @@
+package csvproc
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"math/rand/v2"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Error classes carried by ProcessError.
+const (
+	ClassTransient  = "transient"  // I/O that may succeed when retried
+	ClassIO         = "io"         // I/O that will not fix itself
+	ClassParse      = "parse"      // malformed CSV
+	ClassValidation = "validation" // schema or header mismatch
+	ClassConflict   = "conflict"   // duplicate key with the fail policy
+	ClassCancelled  = "cancelled"
+	ClassUnknown    = "error"
+)
+
+// ProcessError is the classified error stored in ProcessResult.Error.
+type ProcessError struct {
+	Class string
+	Err   error
+}
+
+func (e *ProcessError) Error() string { return e.Err.Error() }
+
+func (e *ProcessError) Unwrap() error { return e.Err }
+
+// Retryable reports whether the job that failed with err is worth retrying.
+func Retryable(err error) bool {
+	return ErrorClass(err) == ClassTransient
+}
+
+// Classify wraps err in a ProcessError. Errors that are already classified
+// are returned unchanged.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var perr *ProcessError
+	if errors.As(err, &perr) {
+		return err
+	}
+	return &ProcessError{Class: classOf(err), Err: err}
+}
+
+// ErrorClass returns the class of err, classifying it if needed.
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	var perr *ProcessError
+	if errors.As(err, &perr) {
+		return perr.Class
+	}
+	return classOf(err)
+}
+
+// transientErrnos are failures seen while files are still being written,
+// on flaky network mounts or during permission changes.
+var transientErrnos = []syscall.Errno{
+	syscall.EAGAIN,
+	syscall.EBUSY,
+	syscall.EINTR,
+	syscall.EIO,
+	syscall.ESTALE,
+	syscall.ETIMEDOUT,
+	syscall.EACCES,
+	syscall.EPERM,
+}
+
+func classOf(err error) string {
+	var (
+		verr     *ValidationError
+		dup      *DuplicateError
+		parseErr *csv.ParseError
+		pathErr  *fs.PathError
+	)
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ClassCancelled
+	case errors.As(err, &verr):
+		return ClassValidation
+	case errors.As(err, &dup):
+		return ClassConflict
+	case errors.As(err, &parseErr):
+		return ClassParse
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, os.ErrDeadlineExceeded):
+		// Truncated input, most likely still being written
+		return ClassTransient
+	}
+
+	for _, errno := range transientErrnos {
+		if errors.Is(err, errno) {
+			return ClassTransient
+		}
+	}
+	if errors.As(err, &pathErr) {
+		return ClassIO
+	}
+	return ClassUnknown
+}
+
+// RetryConfig controls how jobs failing with transient errors are retried.
+type RetryConfig struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Delay returns the backoff before the given retry attempt (2 for the first
+// retry): BaseDelay doubled per attempt, capped at MaxDelay if it is set,
+// with +/-20% jitter so that workers do not retry in lockstep.
+func (rc RetryConfig) Delay(attempt int) time.Duration {
+	d := rc.BaseDelay
+	for i := 2; i < attempt && d <= math.MaxInt64/4; i++ {
+		if rc.MaxDelay > 0 && d >= rc.MaxDelay {
+			break
+		}
+		d *= 2
+	}
+	if rc.MaxDelay > 0 && d > rc.MaxDelay {
+		d = rc.MaxDelay
+	}
+	jitter := 0.8 + 0.4*rand.Float64()
+	return time.Duration(float64(d) * jitter)
+}
@@ -0,0 +1,35 @@
+package csvproc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		retry   RetryConfig
+		attempt int
+		want    time.Duration // before jitter
+	}{
+		{"first retry", RetryConfig{BaseDelay: time.Second, MaxDelay: time.Minute}, 2, time.Second},
+		{"doubles", RetryConfig{BaseDelay: time.Second, MaxDelay: time.Minute}, 4, 4 * time.Second},
+		{"capped", RetryConfig{BaseDelay: time.Second, MaxDelay: 5 * time.Second}, 6, 5 * time.Second},
+		{"no cap", RetryConfig{BaseDelay: time.Second}, 6, 16 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 20 {
+				d := tt.retry.Delay(tt.attempt)
+				if d < tt.want*8/10 || d > tt.want/10*12 {
+					t.Fatalf("got %v, want %v +/-20%%", d, tt.want)
+				}
+			}
+		})
+	}
+
+	// Without a cap the delay stops doubling before it overflows
+	if d := (RetryConfig{BaseDelay: time.Second}).Delay(200); d < time.Duration(1)<<60 {
+		t.Errorf("got %v after 200 attempts", d)
+	}
+}
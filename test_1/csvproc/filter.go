@@ -0,0 +1,781 @@
+package csvproc
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Filter is a compiled row filter such as
+//
+//	Age >= 30 && endsWith(Email, "@gmail.com")
+//
+// An expression combines columns, "string" and number literals, true, false
+// and null with comparisons (== != < <= > >=), regex matches (=~ !~ against
+// a literal pattern), && || ! and the functions in filterFuncs. Column names
+// that are not identifiers are written in backquotes, e.g. `First Name`.
+// Operand and argument types are checked when the filter is compiled.
+//
+// Column values are trimmed; empty and missing values are null. A column
+// compared with a number is compared numerically, a value that is not a
+// number equals no number and is neither less nor greater than one. Two
+// columns are compared as numbers when both hold one. Null only equals
+// null: == with null is true for null alone and != is its negation. Every
+// other comparison with null is false, =~ and !~ included, and so is null
+// where a condition is expected. Functions given null return null, except
+// isNull and coalesce. The result of coalesce has the type of its
+// arguments, so coalesce of conditions is a condition.
+type Filter struct {
+	src     string
+	eval    evalFunc
+	columns []string
+}
+
+// CompileFilter parses and type checks src.
+func CompileFilter(src string) (*Filter, error) {
+	toks, err := lexFilter(src)
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	p := &filterParser{toks: toks}
+	e, err := p.parseOr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = p.errorf(p.peek(), "unexpected %s", p.peek())
+	}
+	if err == nil && e.typ != typeBool {
+		err = fmt.Errorf("expression is a %s, not a condition", e.typ)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	return &Filter{src: src, eval: e.eval, columns: p.columns}, nil
+}
+
+func (f *Filter) String() string { return f.src }
+
+// Columns returns the columns the filter reads.
+func (f *Filter) Columns() []string { return f.columns }
+
+// CheckHeader reports columns of the filter missing from header, which
+// would otherwise silently be null for every row.
+func (f *Filter) CheckHeader(header *Header) error {
+	verr := &ValidationError{}
+	for _, col := range f.columns {
+		if _, ok := header.Index(col); !ok {
+			verr.Add(col, "column used by the filter missing from header")
+		}
+	}
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// Match reports whether row passes the filter.
+func (f *Filter) Match(row *Row) bool {
+	return f.eval(row).truth()
+}
+
+// filterType is the static type of an expression and the kind of a value.
+type filterType int
+
+const (
+	typeAny filterType = iota // a column: a string that may hold a number
+	typeBool
+	typeNumber
+	typeString
+	typeNull
+)
+
+func (t filterType) String() string {
+	switch t {
+	case typeBool:
+		return "bool"
+	case typeNumber:
+		return "number"
+	case typeString:
+		return "string"
+	case typeNull:
+		return "null"
+	}
+	return "column"
+}
+
+// accepts reports whether a value of static type t can be used where want
+// is expected.
+func (want filterType) accepts(t filterType) bool {
+	switch want {
+	case typeAny:
+		return true
+	case typeString, typeNumber:
+		return t == want || t == typeAny || t == typeNull
+	}
+	return t == want || t == typeNull
+}
+
+// filterValue is the result of evaluating an expression. Columns evaluate
+// to strings.
+type filterValue struct {
+	kind filterType
+	b    bool
+	n    float64
+	s    string
+}
+
+var nullValue = filterValue{kind: typeNull}
+
+func boolValue(b bool) filterValue { return filterValue{kind: typeBool, b: b} }
+
+func (v filterValue) truth() bool { return v.kind == typeBool && v.b }
+
+// number returns v as a number, parsing strings.
+func (v filterValue) number() (float64, bool) {
+	switch v.kind {
+	case typeNumber:
+		return v.n, true
+	case typeString:
+		n, err := strconv.ParseFloat(v.s, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// str returns v as a string, formatting numbers.
+func (v filterValue) str() string {
+	switch v.kind {
+	case typeNumber:
+		return strconv.FormatFloat(v.n, 'f', -1, 64)
+	case typeBool:
+		return strconv.FormatBool(v.b)
+	}
+	return v.s
+}
+
+type evalFunc func(row *Row) filterValue
+
+type filterExpr struct {
+	typ   filterType
+	eval  evalFunc
+	konst *filterValue // set for literals
+	tok   token
+}
+
+// filterFunc is a function callable from a filter. Params of typeAny take
+// any value; a variadic function repeats its last param.
+type filterFunc struct {
+	params   []filterType
+	variadic bool
+	result   filterType
+	unify    bool // the result has the common type of the arguments
+	nulls    bool // called with null arguments instead of returning null
+	call     func(args []filterValue) filterValue
+
+	// bind replaces call for functions that need to see their arguments at
+	// compile time
+	bind func(p *filterParser, args []*filterExpr) (evalFunc, error)
+}
+
+func stringFunc(fn func(s string) filterValue, result filterType) filterFunc {
+	return filterFunc{
+		params: []filterType{typeString},
+		result: result,
+		call:   func(args []filterValue) filterValue { return fn(args[0].str()) },
+	}
+}
+
+func predicate(fn func(s, t string) bool) filterFunc {
+	return filterFunc{
+		params: []filterType{typeString, typeString},
+		result: typeBool,
+		call: func(args []filterValue) filterValue {
+			return boolValue(fn(args[0].str(), args[1].str()))
+		},
+	}
+}
+
+var filterFuncs = map[string]filterFunc{
+	"contains":   predicate(strings.Contains),
+	"startsWith": predicate(strings.HasPrefix),
+	"endsWith":   predicate(strings.HasSuffix),
+	"equalFold":  predicate(strings.EqualFold),
+	"lower": stringFunc(func(s string) filterValue {
+		return filterValue{kind: typeString, s: strings.ToLower(s)}
+	}, typeString),
+	"upper": stringFunc(func(s string) filterValue {
+		return filterValue{kind: typeString, s: strings.ToUpper(s)}
+	}, typeString),
+	"trim": stringFunc(func(s string) filterValue {
+		return filterValue{kind: typeString, s: strings.TrimSpace(s)}
+	}, typeString),
+	"len": stringFunc(func(s string) filterValue {
+		return filterValue{kind: typeNumber, n: float64(utf8.RuneCountInString(s))}
+	}, typeNumber),
+	"number": {
+		params: []filterType{typeAny},
+		result: typeNumber,
+		call: func(args []filterValue) filterValue {
+			if n, ok := args[0].number(); ok {
+				return filterValue{kind: typeNumber, n: n}
+			}
+			return nullValue
+		},
+	},
+	"isNull": {
+		params: []filterType{typeAny},
+		result: typeBool,
+		nulls:  true,
+		call: func(args []filterValue) filterValue {
+			return boolValue(args[0].kind == typeNull)
+		},
+	},
+	"coalesce": {
+		params:   []filterType{typeAny},
+		variadic: true,
+		unify:    true,
+		nulls:    true,
+		call: func(args []filterValue) filterValue {
+			for _, v := range args {
+				if v.kind != typeNull {
+					return v
+				}
+			}
+			return nullValue
+		},
+	},
+	"matches": {
+		params: []filterType{typeString, typeString},
+		result: typeBool,
+		bind: func(p *filterParser, args []*filterExpr) (evalFunc, error) {
+			return p.regexMatch(args[0], args[1], false)
+		},
+	},
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokColumn // backquoted column name
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string // identifier, column name, operator or unquoted string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokColumn:
+		return "`" + t.text + "`"
+	}
+	return strconv.Quote(t.text)
+}
+
+var filterOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "-", "(", ")", ","}
+
+func lexFilter(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '_' || unicode.IsLetter(r):
+			j := i + size
+			for j < len(src) {
+				r, size := utf8.DecodeRuneInString(src[j:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				j += size
+			}
+			toks = append(toks, token{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+		case r >= '0' && r <= '9' || r == '.':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			if _, err := strconv.ParseFloat(src[i:j], 64); err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", src[i:j], i+1)
+			}
+			toks = append(toks, token{kind: tokNumber, text: src[i:j], pos: i})
+			i = j
+		case r == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %w", i+1, err)
+			}
+			toks = append(toks, token{kind: tokString, text: s, pos: i})
+			i = j + 1
+		case r == '`':
+			j := strings.IndexByte(src[i+1:], '`')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated column name at position %d", i+1)
+			}
+			toks = append(toks, token{kind: tokColumn, text: src[i+1 : i+1+j], pos: i})
+			i += j + 2
+		default:
+			op := ""
+			for _, o := range filterOps {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at position %d", r, i+1)
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+type filterParser struct {
+	toks    []token
+	i       int
+	columns []string
+}
+
+func (p *filterParser) peek() token { return p.toks[p.i] }
+
+func (p *filterParser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the operator op.
+func (p *filterParser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, args...), t.pos+1)
+}
+
+func (p *filterParser) condition(e *filterExpr, op string) error {
+	if e.typ != typeBool {
+		return p.errorf(e.tok, "operand of %s must be a condition, not a %s", op, e.typ)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (*filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range []*filterExpr{left, right} {
+			if err := p.condition(e, "||"); err != nil {
+				return nil, err
+			}
+		}
+		l, r := left.eval, right.eval
+		left = &filterExpr{typ: typeBool, tok: left.tok, eval: func(row *Row) filterValue {
+			return boolValue(l(row).truth() || r(row).truth())
+		}}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (*filterExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range []*filterExpr{left, right} {
+			if err := p.condition(e, "&&"); err != nil {
+				return nil, err
+			}
+		}
+		l, r := left.eval, right.eval
+		left = &filterExpr{typ: typeBool, tok: left.tok, eval: func(row *Row) filterValue {
+			return boolValue(l(row).truth() && r(row).truth())
+		}}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (*filterExpr, error) {
+	t := p.peek()
+	if !p.accept("!") {
+		return p.parseComparison()
+	}
+	e, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.condition(e, "!"); err != nil {
+		return nil, err
+	}
+	eval := e.eval
+	return &filterExpr{typ: typeBool, tok: t, eval: func(row *Row) filterValue {
+		return boolValue(!eval(row).truth())
+	}}, nil
+}
+
+func (p *filterParser) parseComparison() (*filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind != tokOp {
+		return left, nil
+	}
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return p.compare(t, left, right)
+	case "=~", "!~":
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		eval, err := p.regexMatch(left, right, t.text == "!~")
+		if err != nil {
+			return nil, err
+		}
+		return &filterExpr{typ: typeBool, tok: left.tok, eval: eval}, nil
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (*filterExpr, error) {
+	t := p.peek()
+	if !p.accept("-") {
+		return p.parsePrimary()
+	}
+	e, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if !typeNumber.accepts(e.typ) {
+		return nil, p.errorf(t, "cannot negate a %s", e.typ)
+	}
+	if e.konst != nil && e.typ == typeNumber {
+		v := filterValue{kind: typeNumber, n: -e.konst.n}
+		return constant(v, t), nil
+	}
+	eval := e.eval
+	return &filterExpr{typ: typeNumber, tok: t, eval: func(row *Row) filterValue {
+		if n, ok := eval(row).number(); ok {
+			return filterValue{kind: typeNumber, n: -n}
+		}
+		return nullValue
+	}}, nil
+}
+
+func (p *filterParser) parsePrimary() (*filterExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		n, _ := strconv.ParseFloat(t.text, 64)
+		return constant(filterValue{kind: typeNumber, n: n}, t), nil
+	case tokString:
+		return constant(filterValue{kind: typeString, s: t.text}, t), nil
+	case tokColumn:
+		return p.column(t), nil
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return constant(boolValue(t.text == "true"), t), nil
+		case "null":
+			return constant(nullValue, t), nil
+		}
+		if p.accept("(") {
+			return p.call(t)
+		}
+		return p.column(t), nil
+	case tokOp:
+		if t.text == "(" {
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, p.errorf(p.peek(), "expected ) instead of %s", p.peek())
+			}
+			return e, nil
+		}
+	}
+	return nil, p.errorf(t, "unexpected %s", t)
+}
+
+func constant(v filterValue, t token) *filterExpr {
+	return &filterExpr{
+		typ:   v.kind,
+		konst: &v,
+		tok:   t,
+		eval:  func(*Row) filterValue { return v },
+	}
+}
+
+func (p *filterParser) column(t token) *filterExpr {
+	col := t.text
+	if !slices.Contains(p.columns, col) {
+		p.columns = append(p.columns, col)
+	}
+	return &filterExpr{typ: typeAny, tok: t, eval: func(row *Row) filterValue {
+		v := strings.TrimSpace(row.Get(col))
+		if v == "" {
+			return nullValue
+		}
+		return filterValue{kind: typeString, s: v}
+	}}
+}
+
+func (p *filterParser) call(name token) (*filterExpr, error) {
+	fn, ok := filterFuncs[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown function %s", name.text)
+	}
+
+	var args []*filterExpr
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, p.errorf(p.peek(), "expected , or ) instead of %s", p.peek())
+			}
+		}
+	}
+
+	if len(args) < len(fn.params) || len(args) > len(fn.params) && !fn.variadic {
+		return nil, p.errorf(name, "%s takes %d arguments, got %d", name.text, len(fn.params), len(args))
+	}
+	for i, arg := range args {
+		want := fn.params[min(i, len(fn.params)-1)]
+		if !want.accepts(arg.typ) {
+			return nil, p.errorf(arg.tok, "argument %d of %s must be a %s, not a %s", i+1, name.text, want, arg.typ)
+		}
+	}
+
+	result := fn.result
+	if fn.unify {
+		var err error
+		if result, err = p.unify(name, args); err != nil {
+			return nil, err
+		}
+	}
+
+	if fn.bind != nil {
+		eval, err := fn.bind(p, args)
+		if err != nil {
+			return nil, err
+		}
+		return &filterExpr{typ: result, tok: name, eval: eval}, nil
+	}
+
+	evals := make([]evalFunc, len(args))
+	for i, arg := range args {
+		evals[i] = arg.eval
+	}
+	return &filterExpr{typ: result, tok: name, eval: func(row *Row) filterValue {
+		values := make([]filterValue, len(evals))
+		for i, eval := range evals {
+			values[i] = eval(row)
+			if values[i].kind == typeNull && !fn.nulls {
+				return nullValue
+			}
+		}
+		return fn.call(values)
+	}}, nil
+}
+
+// unify returns the common type of the arguments of the function name.
+// Null fits any type; strings, numbers and columns mix into a column, while
+// conditions only mix with conditions.
+func (p *filterParser) unify(name token, args []*filterExpr) (filterType, error) {
+	result := typeNull
+	for _, arg := range args {
+		switch {
+		case arg.typ == typeNull || arg.typ == result:
+		case result == typeNull:
+			result = arg.typ
+		case arg.typ == typeBool || result == typeBool:
+			return 0, p.errorf(arg.tok, "arguments of %s mix %s and %s", name.text, result, arg.typ)
+		default:
+			result = typeAny
+		}
+	}
+	return result, nil
+}
+
+// compareMode is how the operands of a comparison are compared, decided
+// from their static types.
+type compareMode int
+
+const (
+	compareAuto compareMode = iota // two columns: numbers if both are
+	compareNumber
+	compareString
+	compareBool
+)
+
+func (p *filterParser) compare(op token, left, right *filterExpr) (*filterExpr, error) {
+	lt, rt := left.typ, right.typ
+	mode := compareAuto
+	switch {
+	case lt == typeNull || rt == typeNull:
+		if op.text != "==" && op.text != "!=" {
+			return nil, p.errorf(op, "null can only be compared with == and !=")
+		}
+	case lt == typeBool || rt == typeBool:
+		if lt != rt {
+			return nil, p.errorf(op, "cannot compare %s with %s", lt, rt)
+		}
+		if op.text != "==" && op.text != "!=" {
+			return nil, p.errorf(op, "conditions can only be compared with == and !=")
+		}
+		mode = compareBool
+	case lt == typeNumber || rt == typeNumber:
+		if lt == typeString || rt == typeString {
+			return nil, p.errorf(op, "cannot compare %s with %s", lt, rt)
+		}
+		mode = compareNumber
+	case lt == typeString || rt == typeString:
+		mode = compareString
+	}
+
+	l, r := left.eval, right.eval
+	var test func(c int) bool
+	switch op.text {
+	case "==":
+		test = func(c int) bool { return c == 0 }
+	case "!=":
+		test = func(c int) bool { return c != 0 }
+	case "<":
+		test = func(c int) bool { return c < 0 }
+	case "<=":
+		test = func(c int) bool { return c <= 0 }
+	case ">":
+		test = func(c int) bool { return c > 0 }
+	case ">=":
+		test = func(c int) bool { return c >= 0 }
+	}
+	return &filterExpr{typ: typeBool, tok: left.tok, eval: func(row *Row) filterValue {
+		a, b := l(row), r(row)
+		if a.kind == typeNull || b.kind == typeNull {
+			switch op.text {
+			case "==":
+				return boolValue(a.kind == b.kind)
+			case "!=":
+				return boolValue(a.kind != b.kind)
+			}
+			return boolValue(false)
+		}
+		c, ok := compareFilterValues(a, b, mode)
+		if !ok {
+			// A value that is not a number differs from every number
+			return boolValue(op.text == "!=")
+		}
+		return boolValue(test(c))
+	}}, nil
+}
+
+func compareFilterValues(a, b filterValue, mode compareMode) (int, bool) {
+	switch mode {
+	case compareBool:
+		if a.b == b.b {
+			return 0, true
+		}
+		return 1, true
+	case compareString:
+		return strings.Compare(a.str(), b.str()), true
+	case compareAuto:
+		if _, ok := a.number(); !ok {
+			return strings.Compare(a.str(), b.str()), true
+		}
+		if _, ok := b.number(); !ok {
+			return strings.Compare(a.str(), b.str()), true
+		}
+	}
+	x, okA := a.number()
+	y, okB := b.number()
+	if !okA || !okB {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+// regexMatch matches subject against pattern, which must be a string
+// literal so that it is compiled only once.
+func (p *filterParser) regexMatch(subject, pattern *filterExpr, negate bool) (evalFunc, error) {
+	if !typeString.accepts(subject.typ) {
+		return nil, p.errorf(subject.tok, "cannot match a %s against a pattern", subject.typ)
+	}
+	if pattern.konst == nil || pattern.typ != typeString {
+		return nil, p.errorf(pattern.tok, "pattern must be a string literal")
+	}
+	re, err := regexp.Compile(pattern.konst.s)
+	if err != nil {
+		return nil, p.errorf(pattern.tok, "invalid pattern: %v", err)
+	}
+	eval := subject.eval
+	return func(row *Row) filterValue {
+		v := eval(row)
+		if v.kind == typeNull {
+			return boolValue(false)
+		}
+		return boolValue(re.MatchString(v.str()) != negate)
+	}, nil
+}
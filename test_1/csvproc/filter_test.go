@@ -0,0 +1,197 @@
+package csvproc
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func filterRow(values map[string]string) *Row {
+	var cols, fields []string
+	for col, v := range values {
+		cols = append(cols, col)
+		fields = append(fields, v)
+	}
+	return &Row{Header: NewHeader(cols), Fields: fields}
+}
+
+func TestFilterMatch(t *testing.T) {
+	row := filterRow(map[string]string{
+		"Name":  "Ana",
+		"Email": "ana@gmail.com",
+		"Age":   "31",
+		"Min":   "9",
+		"Max":   "10",
+		"Code":  "abc",
+		"City":  " ",
+		"Note":  "",
+	})
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// Comparisons bind tighter than !, ! tighter than && and && than ||
+		{`true || false && false`, true},
+		{`(true || false) && false`, false},
+		{`!false && false`, false},
+		{`!(false && false)`, true},
+		{`!Age == 31`, false},
+		{`Age > 30 && Age < 40 || Name == "Bob"`, true},
+		{`Name == "Bob" || Age > 30 && Age < 40`, true},
+		{`Name == "Bob" || Age > 30 && Age > 40`, false},
+		{`!!(Age == 31)`, true},
+
+		// Null: blank and missing columns, == and != against null
+		{`City == null`, true},
+		{`Note == null`, true},
+		{`Missing == null`, true},
+		{`Name == null`, false},
+		{`Name != null`, true},
+		{`City != null`, false},
+		{`City == "x"`, false},
+		{`City != "x"`, true},
+		{`City < 5`, false},
+		{`City >= 5`, false},
+		{`isNull(City) && !isNull(Name)`, true},
+		{`len(City) == null`, true},
+		{`City =~ ".*"`, false},
+		{`City !~ "x"`, false},
+		{`matches(City, ".*")`, false},
+
+		// Columns against numbers and strings
+		{`Age == 31`, true},
+		{`Age == 31.0`, true},
+		{`Age == "31.0"`, false},
+		{`Age > -1`, true},
+		{`Code > 5`, false},
+		{`Code < 5`, false},
+		{`Code == 5`, false},
+		{`Code != 5`, true},
+		{`Min < Max`, true},
+		{`Code > Max`, true},
+		{`Name < "Bob"`, true},
+		{`number(Max) == 10`, true},
+		{`number(Code) == null`, true},
+
+		// Functions and regex matches
+		{`endsWith(Email, "@gmail.com")`, true},
+		{`lower(Name) == "ana" && upper(Name) == "ANA"`, true},
+		{`len(Name) == 3`, true},
+		{`Email =~ "^[a-z]+@"`, true},
+		{`Email !~ "^[a-z]+@"`, false},
+		{"`Name` == \"Ana\"", true},
+		{`coalesce(City, Name) == "Ana"`, true},
+		{`coalesce(City, 42) == 42`, true},
+		{`coalesce(City, null) == null`, true},
+		{`coalesce(Age == 1, false)`, false},
+		{`coalesce(isNull(City), false) && true`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := CompileFilter(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Match(row); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{`Age`, "expression is a column, not a condition"},
+		{`coalesce(Age, 1)`, "expression is a column, not a condition"},
+		{`Age > 1 && Name`, "operand of && must be a condition"},
+		{`!Name`, "operand of ! must be a condition"},
+		{`Name == "x" || 1`, "operand of || must be a condition"},
+		{`Age == true`, "cannot compare column with bool"},
+		{`"a" < 1`, "cannot compare string with number"},
+		{`true < false`, "conditions can only be compared with == and !="},
+		{`Age < null`, "null can only be compared with == and !="},
+		{`-"a" == 1`, "cannot negate a string"},
+		{`len(1) == 1`, "argument 1 of len must be a string, not a number"},
+		{`len() == 1`, "len takes 1 arguments, got 0"},
+		{`contains(Name) `, "contains takes 2 arguments, got 1"},
+		{`nope(Name)`, "unknown function nope"},
+		{`coalesce(Age == 1, 0)`, "arguments of coalesce mix bool and number"},
+		{`Name =~ Email`, "pattern must be a string literal"},
+		{`Name =~ "("`, "invalid pattern"},
+		{`(Age > 1 && true) == 1`, "cannot compare bool with number"},
+		{`Age > 1 )`, `unexpected ")"`},
+		{`(Age > 1`, "expected ) instead of end of expression"},
+		{`Name == "x`, "unterminated string"},
+		{"`Name == 1", "unterminated column name"},
+		{`Age == 1.2.3`, "invalid number"},
+		{`Age # 1`, "unexpected '#'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := CompileFilter(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFilterCounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.csv")
+	data := "ID,Name,Age\n1,Ana,31\n2,Budi,\n3,Citra,45\n4,Dewi,19\n5,Eko,abc\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr     string
+		passed   int
+		filtered int
+		err      bool
+	}{
+		{`Age >= 30`, 2, 3, false},
+		{`Age != null`, 4, 1, false},
+		{`isNull(Age) || Age < 20`, 2, 3, false},
+		{`City == "x"`, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := CompileFilter(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			handled := 0
+			p := New(WithWorkers(1), WithFilter(f), WithLogOutput(io.Discard), WithHandler(RowHandlerFunc(func(ctx context.Context, row *Row) error {
+				handled++
+				return nil
+			})))
+			results := p.ProcessFiles([]string{path})
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			r := results[0]
+			if tt.err {
+				if r.Error == nil {
+					t.Error("missing column did not fail the file")
+				}
+				return
+			}
+			if r.Error != nil {
+				t.Fatal(r.Error)
+			}
+			if r.Passed != tt.passed || r.Filtered != tt.filtered || handled != tt.passed {
+				t.Errorf("got %d passed, %d filtered, %d handled, want %d, %d", r.Passed, r.Filtered, handled, tt.passed, tt.filtered)
+			}
+			if r.RowCount != 5 || r.ValidRows != tt.passed {
+				t.Errorf("got %d rows, %d valid, want 5, %d", r.RowCount, r.ValidRows, tt.passed)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package csvproc
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+)
+
+// Kinds of errors GenerateFiles can inject into a row.
+const (
+	InjectQuote         = "malformed-quote"
+	InjectMissingColumn = "missing-column"
+	InjectBadEmail      = "bad-email"
+)
+
+// GenerateOptions configures GenerateFiles. Files are deterministic: the
+// same seed, schema and targets always produce byte-identical output.
+type GenerateOptions struct {
+	Seed   uint64
+	Files  int
+	Prefix string // file names are <Prefix>_<n>.csv, "sample" by default
+
+	// Schema describes the columns to generate. Without one the user
+	// export columns ID, Name, Email, Age and City are used.
+	Schema *Schema
+
+	// Rows and Bytes are the target size of every file. Generation stops
+	// at whichever is reached first; with neither set a file has 1000 rows.
+	Rows  int64
+	Bytes int64
+
+	// ErrorRate is the fraction of rows, between 0 and 1, that get one
+	// injected error.
+	ErrorRate float64
+}
+
+// GeneratedFile describes a file written by GenerateFiles.
+type GeneratedFile struct {
+	Path     string         `json:"path"`
+	Rows     int64          `json:"rows"`
+	Bytes    int64          `json:"bytes"`
+	Injected map[string]int `json:"injected,omitempty"`
+}
+
+// userSchema matches the columns of the user export files.
+var userSchema = func() *Schema {
+	minAge, maxAge := 18.0, 80.0
+	s := &Schema{Columns: []ColumnRule{
+		{Name: ColumnID, Type: TypeInt, Required: true},
+		{Name: ColumnName, Type: TypeString, Required: true},
+		{Name: ColumnEmail, Type: TypeString, Required: true, Format: "email"},
+		{Name: ColumnAge, Type: TypeInt, Required: true, Min: &minAge, Max: &maxAge},
+		{Name: ColumnCity, Type: TypeString, Required: true},
+	}}
+	s.compile()
+	return s
+}()
+
+// GenerateFiles writes opts.Files synthetic CSV files into dir.
+func GenerateFiles(dir string, opts GenerateOptions) ([]GeneratedFile, error) {
+	if opts.ErrorRate < 0 || opts.ErrorRate > 1 {
+		return nil, fmt.Errorf("error rate %v is not between 0 and 1", opts.ErrorRate)
+	}
+	if opts.Schema == nil {
+		opts.Schema = userSchema
+	}
+	if len(opts.Schema.Columns) == 0 {
+		return nil, fmt.Errorf("schema has no columns")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "sample"
+	}
+	if opts.Rows <= 0 && opts.Bytes <= 0 {
+		opts.Rows = 1000
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	files := make([]GeneratedFile, opts.Files)
+	for i := range files {
+		path := filepath.Join(dir, fmt.Sprintf("%s_%d.csv", opts.Prefix, i+1))
+		gen := newGenerator(opts, uint64(i))
+		if err := gen.writeFile(path, &files[i]); err != nil {
+			return nil, fmt.Errorf("generate %s: %w", path, err)
+		}
+	}
+	return files, nil
+}
+
+type generator struct {
+	opts GenerateOptions
+	rnd  *rand.Rand
+	gens []func(p *person, id int64) string
+
+	emailCol int // -1 without an email column
+}
+
+// person is the identity shared by the name and email columns of a row.
+type person struct {
+	first, last string
+}
+
+func newGenerator(opts GenerateOptions, file uint64) *generator {
+	g := &generator{
+		opts:     opts,
+		rnd:      rand.New(rand.NewPCG(opts.Seed, file)),
+		emailCol: -1,
+	}
+	for i := range opts.Schema.Columns {
+		col := &opts.Schema.Columns[i]
+		if col.Format == "email" && g.emailCol < 0 {
+			g.emailCol = i
+		}
+		g.gens = append(g.gens, g.column(col))
+	}
+	return g
+}
+
+func (g *generator) writeFile(path string, info *GeneratedFile) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	buf := appendRecord(nil, g.opts.Schema.ColumnNames(), -1)
+	info.Path = path
+	info.Bytes = int64(len(buf))
+	info.Injected = map[string]int{}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+
+	fields := make([]string, len(g.gens))
+	for id := int64(1); ; id++ {
+		if g.opts.Rows > 0 && info.Rows >= g.opts.Rows {
+			break
+		}
+		if g.opts.Bytes > 0 && info.Bytes >= g.opts.Bytes {
+			break
+		}
+
+		p := &person{first: pick(g.rnd, firstNames), last: pick(g.rnd, lastNames)}
+		for i, gen := range g.gens {
+			fields[i] = gen(p, id)
+		}
+
+		raw := -1
+		record := fields
+		if g.opts.ErrorRate > 0 && g.rnd.Float64() < g.opts.ErrorRate {
+			var kind string
+			record, raw, kind = g.inject(fields)
+			info.Injected[kind]++
+		}
+
+		buf = appendRecord(buf[:0], record, raw)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		info.Rows++
+		info.Bytes += int64(len(buf))
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// inject corrupts a copy of fields. It returns the index of a field that
+// must be written without quoting, or -1.
+func (g *generator) inject(fields []string) ([]string, int, string) {
+	kinds := []string{InjectQuote}
+	if len(fields) > 1 {
+		kinds = append(kinds, InjectMissingColumn)
+	}
+	if g.emailCol >= 0 {
+		kinds = append(kinds, InjectBadEmail)
+	}
+	record := append([]string(nil), fields...)
+
+	switch kind := pick(g.rnd, kinds); kind {
+	case InjectQuote:
+		// A pair of bare quotes keeps the quote count even, so the file can
+		// still be split into chunks on newlines.
+		i := g.rnd.IntN(len(record))
+		v := strings.NewReplacer(`"`, "", ",", "", "\n", "", "\r", "").Replace(record[i])
+		if v == "" {
+			v = "x"
+		}
+		// Never at the start, where the quotes would form a valid field
+		cut := 1 + g.rnd.IntN(len(v))
+		record[i] = v[:cut] + `"` + v[cut:] + `"`
+		return record, i, kind
+	case InjectMissingColumn:
+		i := g.rnd.IntN(len(record))
+		return append(record[:i], record[i+1:]...), -1, kind
+	default:
+		email := record[g.emailCol]
+		local, domain, _ := strings.Cut(email, "@")
+		switch g.rnd.IntN(4) {
+		case 0:
+			email = local + domain
+		case 1:
+			email = local + "@@" + domain
+		case 2:
+			email = local + "@"
+		default:
+			email = local + " @" + domain
+		}
+		record[g.emailCol] = email
+		return record, -1, kind
+	}
+}
+
+// column returns the value generator for col. Well known column names get
+// realistic values, everything else is derived from the column type.
+func (g *generator) column(col *ColumnRule) func(p *person, id int64) string {
+	name := strings.ToLower(col.Name)
+	var gen func(p *person, id int64) string
+
+	switch {
+	case col.Format == "email" || strings.Contains(name, "email"):
+		gen = func(p *person, id int64) string { return g.email(p) }
+	case col.pattern != nil:
+		re, _ := syntax.Parse(col.Pattern, syntax.Perl)
+		gen = func(p *person, id int64) string {
+			var b strings.Builder
+			for range 10 {
+				b.Reset()
+				genRegexp(g.rnd, re, &b)
+				if col.pattern.MatchString(b.String()) {
+					break
+				}
+			}
+			return b.String()
+		}
+	case col.Type == TypeInt && name == "id":
+		gen = func(p *person, id int64) string { return strconv.FormatInt(id, 10) }
+	case col.Type == TypeInt || col.Type == TypeFloat:
+		lo, hi := 0.0, 1000.0
+		if name == "age" {
+			lo, hi = 18, 80
+		}
+		if col.Min != nil {
+			lo = *col.Min
+		}
+		if col.Max != nil {
+			hi = *col.Max
+		}
+		hi = max(hi, lo)
+		if col.Type == TypeInt {
+			gen = func(p *person, id int64) string {
+				return strconv.Itoa(int(lo) + g.rnd.IntN(int(hi-lo)+1))
+			}
+		} else {
+			gen = func(p *person, id int64) string {
+				return strconv.FormatFloat(lo+g.rnd.Float64()*(hi-lo), 'f', 2, 64)
+			}
+		}
+	case col.Type == TypeBool:
+		gen = func(p *person, id int64) string { return strconv.FormatBool(g.rnd.IntN(2) == 1) }
+	case strings.Contains(name, "first"):
+		gen = func(p *person, id int64) string { return p.first }
+	case strings.Contains(name, "last"):
+		gen = func(p *person, id int64) string { return p.last }
+	case strings.Contains(name, "name"):
+		gen = func(p *person, id int64) string { return p.first + " " + p.last }
+	case strings.Contains(name, "city"):
+		gen = func(p *person, id int64) string { return pick(g.rnd, cities) }
+	case strings.Contains(name, "phone"):
+		gen = func(p *person, id int64) string {
+			return fmt.Sprintf("+62 8%02d-%04d-%04d", 11+g.rnd.IntN(89), g.rnd.IntN(10000), g.rnd.IntN(10000))
+		}
+	default:
+		gen = func(p *person, id int64) string { return pick(g.rnd, words) }
+	}
+
+	if col.Required {
+		return gen
+	}
+	// Optional columns are occasionally left empty
+	return func(p *person, id int64) string {
+		if g.rnd.IntN(50) == 0 {
+			return ""
+		}
+		return gen(p, id)
+	}
+}
+
+func (g *generator) email(p *person) string {
+	local := strings.ToLower(p.first)
+	switch g.rnd.IntN(3) {
+	case 0:
+		local += "." + strings.ToLower(p.last)
+	case 1:
+		local += strconv.Itoa(g.rnd.IntN(1000))
+	}
+	return local + "@" + pick(g.rnd, emailDomains)
+}
+
+// genRegexp appends a random string matching re to b. Unbounded
+// repetitions are capped at a few occurrences.
+func genRegexp(rnd *rand.Rand, re *syntax.Regexp, b *strings.Builder) {
+	repeat := func(lo, hi int) {
+		for range lo + rnd.IntN(hi-lo+1) {
+			genRegexp(rnd, re.Sub[0], b)
+		}
+	}
+
+	switch re.Op {
+	case syntax.OpLiteral:
+		b.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		b.WriteRune(classRune(rnd, re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		b.WriteByte(byte('a' + rnd.IntN(26)))
+	case syntax.OpCapture:
+		genRegexp(rnd, re.Sub[0], b)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			genRegexp(rnd, sub, b)
+		}
+	case syntax.OpAlternate:
+		genRegexp(rnd, re.Sub[rnd.IntN(len(re.Sub))], b)
+	case syntax.OpStar:
+		repeat(0, 3)
+	case syntax.OpPlus:
+		repeat(1, 3)
+	case syntax.OpQuest:
+		repeat(0, 1)
+	case syntax.OpRepeat:
+		hi := re.Max
+		if hi < 0 {
+			hi = re.Min + 3
+		}
+		repeat(re.Min, hi)
+	}
+}
+
+// classRune picks a rune from the ranges of a character class, preferring
+// printable ASCII.
+func classRune(rnd *rand.Rand, ranges []rune) rune {
+	var ascii []rune
+	for i := 0; i+1 < len(ranges); i += 2 {
+		lo, hi := max(ranges[i], ' '), min(ranges[i+1], '~')
+		if lo <= hi {
+			ascii = append(ascii, lo, hi)
+		}
+	}
+	if len(ascii) > 0 {
+		ranges = ascii
+	}
+	if len(ranges) == 0 {
+		return 'x'
+	}
+	i := rnd.IntN(len(ranges)/2) * 2
+	return ranges[i] + rune(rnd.IntN(int(ranges[i+1]-ranges[i])+1))
+}
+
+// appendRecord appends fields as a CSV line to buf. The field at index raw
+// is written as is, even if it would need quoting.
+func appendRecord(buf []byte, fields []string, raw int) []byte {
+	for i, f := range fields {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if i == raw || !strings.ContainsAny(f, ",\"\r\n") && !strings.HasPrefix(f, " ") {
+			buf = append(buf, f...)
+			continue
+		}
+		buf = append(buf, '"')
+		buf = append(buf, strings.ReplaceAll(f, `"`, `""`)...)
+		buf = append(buf, '"')
+	}
+	return append(buf, '\n')
+}
+
+func pick[T any](rnd *rand.Rand, items []T) T {
+	return items[rnd.IntN(len(items))]
+}
+
+var (
+	firstNames = []string{
+		"Budi", "Siti", "Agus", "Dewi", "Andi", "Rina", "Eko", "Putri", "Rizky", "Ayu",
+		"Fajar", "Indah", "Hendra", "Lestari", "Yusuf", "Nur", "Dimas", "Sari", "Bayu", "Wulan",
+		"Michael", "Sarah", "David", "Emily", "Kevin", "Jessica", "Daniel", "Olivia", "Ryan", "Grace",
+	}
+	lastNames = []string{
+		"Santoso", "Wijaya", "Saputra", "Hidayat", "Pratama", "Kusuma", "Nugroho", "Halim", "Setiawan", "Gunawan",
+		"Siregar", "Nasution", "Lubis", "Simanjuntak", "Wibowo", "Susanto", "Tanoto", "Hartono", "Rahman", "Putra",
+		"Smith", "Johnson", "Tan", "Lim", "Wong",
+	}
+	cities = []string{
+		"Jakarta", "Surabaya", "Bandung", "Medan", "Semarang", "Makassar", "Palembang", "Depok", "Tangerang", "Bekasi",
+		"Yogyakarta", "Malang", "Denpasar", "Bogor", "Balikpapan", "Pekanbaru", "Padang", "Manado", "Pontianak", "Batam",
+	}
+	emailDomains = []string{
+		"gmail.com", "gmail.com", "gmail.com", "yahoo.com", "yahoo.co.id", "hotmail.com", "outlook.com", "icloud.com", "majoo.id",
+	}
+	words = []string{
+		"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliet",
+		"kilo", "lima", "mike", "november", "oscar", "papa", "quebec", "romeo", "sierra", "tango",
+	}
+)
@@ -0,0 +1,129 @@
+package csvproc
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateDeterministic(t *testing.T) {
+	opts := GenerateOptions{Seed: 42, Files: 3, Rows: 500, ErrorRate: 0.1}
+	generate := func(opts GenerateOptions) [][]byte {
+		t.Helper()
+		files, err := GenerateFiles(t.TempDir(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var contents [][]byte
+		for _, f := range files {
+			data, err := os.ReadFile(f.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents = append(contents, data)
+		}
+		return contents
+	}
+
+	first, again := generate(opts), generate(opts)
+	for i := range first {
+		if !bytes.Equal(first[i], again[i]) {
+			t.Errorf("file %d differs between runs with the same seed", i+1)
+		}
+	}
+	if bytes.Equal(first[0], first[1]) {
+		t.Error("two files of one run are equal")
+	}
+
+	opts.Seed++
+	if other := generate(opts); bytes.Equal(first[0], other[0]) {
+		t.Error("a different seed produced the same file")
+	}
+}
+
+func TestGenerateSizeTargets(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  GenerateOptions
+		rows  int64 // exact row count, or 0 if the byte target decides
+		bytes int64 // minimum size, or 0 if the row target decides
+	}{
+		{"default", GenerateOptions{}, 1000, 0},
+		{"rows", GenerateOptions{Rows: 250}, 250, 0},
+		{"bytes", GenerateOptions{Bytes: 64 << 10}, 0, 64 << 10},
+		{"rows reached first", GenerateOptions{Rows: 10, Bytes: 64 << 10}, 10, 0},
+		{"bytes reached first", GenerateOptions{Rows: 1 << 20, Bytes: 16 << 10}, 0, 16 << 10},
+		{"bytes with errors", GenerateOptions{Bytes: 32 << 10, ErrorRate: 0.5}, 0, 32 << 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Seed, tt.opts.Files = 7, 1
+			files, err := GenerateFiles(t.TempDir(), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := files[0]
+			data, err := os.ReadFile(f.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Bytes != int64(len(data)) {
+				t.Errorf("reported %d bytes, file has %d", f.Bytes, len(data))
+			}
+			if lines := int64(bytes.Count(data, []byte("\n"))) - 1; f.Rows != lines {
+				t.Errorf("reported %d rows, file has %d lines after the header", f.Rows, lines)
+			}
+			if tt.rows > 0 && f.Rows != tt.rows {
+				t.Errorf("got %d rows, want %d", f.Rows, tt.rows)
+			}
+			if tt.bytes > 0 {
+				// Generation stops with the row that reaches the target
+				last := bytes.LastIndexByte(data[:len(data)-1], '\n') + 1
+				if f.Bytes < tt.bytes || int64(last) >= tt.bytes {
+					t.Errorf("got %d bytes, the last row starting at %d, want to just reach %d", f.Bytes, last, tt.bytes)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateErrorRate(t *testing.T) {
+	const rows = 20000
+	for _, rate := range []float64{0, 0.05, 0.3, 1} {
+		dir := t.TempDir()
+		files, err := GenerateFiles(dir, GenerateOptions{Seed: 3, Files: 1, Rows: rows, ErrorRate: rate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		injected := 0
+		for kind, n := range files[0].Injected {
+			if kind != InjectQuote && kind != InjectMissingColumn && kind != InjectBadEmail {
+				t.Errorf("rate %v: unknown kind %q", rate, kind)
+			}
+			injected += n
+		}
+		// Far more than the binomial deviation, which is below 0.4%
+		if got := float64(injected) / rows; math.Abs(got-rate) > 0.015 {
+			t.Errorf("rate %v: injected errors into %.3f of the rows", rate, got)
+		}
+
+		// Every injected error makes its row invalid against the schema
+		pipeline := NewPipeline()
+		pipeline.Validate = userSchema.Validate
+		p := New(WithWorkers(1), WithSchema(userSchema, nil), WithHandler(pipeline), WithLogOutput(io.Discard))
+		result := p.ProcessFiles([]string{files[0].Path})[0]
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		if result.InvalidRows != injected || result.RowCount != rows {
+			t.Errorf("rate %v: got %d of %d rows invalid, want %d", rate, result.InvalidRows, result.RowCount, injected)
+		}
+	}
+
+	if _, err := GenerateFiles(filepath.Join(t.TempDir(), "x"), GenerateOptions{Files: 1, ErrorRate: 1.5}); err == nil {
+		t.Error("accepted an error rate above 1")
+	}
+}
@@ -0,0 +1,99 @@
+package csvproc
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+)
+
+// hllPrecision gives 2^14 registers, a standard error of about 0.8%.
+const hllPrecision = 14
+
+// hllSparseMax is how many distinct hashes a sketch keeps before it
+// switches to registers, which take about as much memory.
+const hllSparseMax = 1024
+
+var hllSeed = maphash.MakeSeed()
+
+// HyperLogLog estimates the number of distinct strings added to it. Up to
+// hllSparseMax strings it keeps their 64-bit hashes and counts exactly,
+// beyond that it uses a fixed 16KB of registers. Sketches built in the same
+// process can be merged.
+type HyperLogLog struct {
+	sparse    map[uint64]struct{} // hashes, until registers is allocated
+	registers []uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{sparse: make(map[uint64]struct{})}
+}
+
+func (h *HyperLogLog) Add(s string) {
+	h.addHash(maphash.String(hllSeed, s))
+}
+
+func (h *HyperLogLog) addHash(x uint64) {
+	if h.registers == nil {
+		h.sparse[x] = struct{}{}
+		if len(h.sparse) > hllSparseMax {
+			h.densify()
+		}
+		return
+	}
+	i := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// densify moves the sparse hashes into registers.
+func (h *HyperLogLog) densify() {
+	if h.registers != nil {
+		return
+	}
+	h.registers = make([]uint8, 1<<hllPrecision)
+	for x := range h.sparse {
+		h.addHash(x)
+	}
+	h.sparse = nil
+}
+
+// Merge folds other into h, as if all strings of other had been added to h.
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	if other.registers == nil {
+		for x := range other.sparse {
+			h.addHash(x)
+		}
+		return
+	}
+	h.densify()
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// Count returns the estimated number of distinct strings, exact while the
+// sketch is sparse.
+func (h *HyperLogLog) Count() uint64 {
+	if h.registers == nil {
+		return uint64(len(h.sparse))
+	}
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
@@ -0,0 +1,189 @@
+package csvproc
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte{0x50, 0x4b, 0x03, 0x04}
+)
+
+// IsZipArchive reports whether path is a zip archive, by extension or by
+// its magic bytes.
+func IsZipArchive(path string) bool {
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		return true
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(zipMagic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, zipMagic)
+}
+
+// ExpandJobs turns file paths into jobs. Every file entry of a zip archive
+// whose name ends in one of extensions becomes its own job, hidden entries
+// such as __MACOSX/._users.csv excepted. An archive that cannot be listed or
+// has no such entries is kept as a single job so the failure shows up in its
+// result.
+func ExpandJobs(filePaths []string, extensions []string) []FileJob {
+	jobs := make([]FileJob, 0, len(filePaths))
+	add := func(job FileJob) {
+		job.FileNum = len(jobs) + 1
+		jobs = append(jobs, job)
+	}
+
+	for _, path := range filePaths {
+		if !IsZipArchive(path) {
+			add(FileJob{FilePath: path})
+			continue
+		}
+
+		entries, err := zipEntries(path, extensions)
+		if err != nil || len(entries) == 0 {
+			add(FileJob{FilePath: path})
+			continue
+		}
+		for _, entry := range entries {
+			add(FileJob{FilePath: path, Entry: entry})
+		}
+	}
+	return jobs
+}
+
+func zipEntries(path string, extensions []string) ([]string, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	match := DiscoverOptions{Extensions: extensions}
+	var entries []string
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() || hiddenEntry(f.Name) || !match.MatchExtension(f.Name) {
+			continue
+		}
+		entries = append(entries, f.Name)
+	}
+	return entries, nil
+}
+
+// hiddenEntry reports whether a zip entry is a dot file or lies in a hidden
+// directory, like the resource forks macOS adds under __MACOSX.
+func hiddenEntry(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part == "__MACOSX" || strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// openInput returns the decompressed contents of a job, or the byte range of
+// a chunk. Gzip and zstd are detected from their magic bytes, so misnamed
+// files are handled as well.
+func openInput(job FileJob) (io.ReadCloser, error) {
+	if job.Length > 0 {
+		file, err := os.Open(job.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		section := io.NewSectionReader(file, job.Offset, job.Length)
+		return multiCloser{Reader: section, closers: []io.Closer{file}}, nil
+	}
+
+	var src io.ReadCloser
+	if job.Entry != "" {
+		archive, err := zip.OpenReader(job.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := archive.Open(job.Entry)
+		if err != nil {
+			archive.Close()
+			return nil, err
+		}
+		src = multiCloser{Reader: entry, closers: []io.Closer{entry, archive}}
+	} else {
+		if IsZipArchive(job.FilePath) {
+			return nil, fmt.Errorf("zip archive has no entries to process")
+		}
+		file, err := os.Open(job.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		src = file
+	}
+
+	r, err := decompress(src)
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func decompress(src io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(src)
+	magic, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return multiCloser{Reader: gz, closers: []io.Closer{gz, src}}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return multiCloser{Reader: zr, closers: []io.Closer{zstdCloser{zr}, src}}, nil
+	default:
+		return multiCloser{Reader: br, closers: []io.Closer{src}}, nil
+	}
+}
+
+// multiCloser closes every closer in order and reports the first error.
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m multiCloser) Close() error {
+	var first error
+	for _, c := range m.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+type zstdCloser struct{ d *zstd.Decoder }
+
+func (z zstdCloser) Close() error {
+	z.d.Close()
+	return nil
+}
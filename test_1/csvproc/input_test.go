@@ -0,0 +1,201 @@
+package csvproc
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const inputSample = "ID,Name\n1,Ana\n2,Budi\n3,Citra\n"
+
+func gzipBytes(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data string) []byte {
+	t.Helper()
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zw.Close()
+	return zw.EncodeAll([]byte(data), nil)
+}
+
+// zipBytes builds an archive of the named entries, in order. Names ending
+// in a slash are directories.
+func zipBytes(t *testing.T, entries ...[2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenInputMagicBytes(t *testing.T) {
+	tests := []struct {
+		name string // misleading on purpose
+		data []byte
+	}{
+		{"plain.csv", []byte(inputSample)},
+		{"gzip.csv", gzipBytes(t, inputSample)},
+		{"gzip.csv.zst", gzipBytes(t, inputSample)},
+		{"zstd.csv.gz", zstdBytes(t, inputSample)},
+		{"zstd.txt", zstdBytes(t, inputSample)},
+		{"zip.csv", zipBytes(t, [2]string{"users.csv", inputSample})},
+		{"zip.gz", zipBytes(t, [2]string{"users.csv", inputSample})},
+		// Entries are decompressed by their magic bytes as well
+		{"zipped gzip.zip", zipBytes(t, [2]string{"users.csv", string(gzipBytes(t, inputSample))})},
+		{"zipped zstd.zip", zipBytes(t, [2]string{"users.csv", string(zstdBytes(t, inputSample))})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			jobs := ExpandJobs([]string{path}, []string{".csv"})
+			if len(jobs) != 1 {
+				t.Fatalf("got %d jobs, want 1", len(jobs))
+			}
+			r, err := openInput(jobs[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != inputSample {
+				t.Errorf("got %q", got)
+			}
+		})
+	}
+}
+
+func TestExpandJobsZipEntries(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "export.zip")
+	data := zipBytes(t,
+		[2]string{"a.csv", inputSample},
+		[2]string{"nested/", ""},
+		[2]string{"nested/b.csv", inputSample},
+		[2]string{"c.csv.gz", string(gzipBytes(t, inputSample))},
+		[2]string{"notes.txt", "not a csv"},
+		[2]string{".hidden.csv", inputSample},
+		[2]string{"nested/.cache/d.csv", inputSample},
+		[2]string{"__MACOSX/._a.csv", "\x00\x05\x16\x07"},
+		[2]string{"__MACOSX/nested/._b.csv", "\x00\x05\x16\x07"},
+	)
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "plain.csv")
+	if err := os.WriteFile(plain, []byte(inputSample), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		extensions []string
+		want       []string
+	}{
+		{"extensions", []string{".csv", ".csv.gz"}, []string{"a.csv", "nested/b.csv", "c.csv.gz"}},
+		{"case insensitive", []string{".CSV"}, []string{"a.csv", "nested/b.csv"}},
+		{"no filter", nil, []string{"a.csv", "nested/b.csv", "c.csv.gz", "notes.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := ExpandJobs([]string{plain, archive}, tt.extensions)
+			if jobs[0].FilePath != plain || jobs[0].Entry != "" {
+				t.Errorf("got first job %+v, want the plain file", jobs[0])
+			}
+			var entries []string
+			for i, job := range jobs[1:] {
+				if job.FilePath != archive || job.FileNum != i+2 {
+					t.Errorf("got job %+v", job)
+				}
+				entries = append(entries, job.Entry)
+			}
+			if !slices.Equal(entries, tt.want) {
+				t.Errorf("got entries %q, want %q", entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessZipEntries(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "export.zip")
+	data := zipBytes(t,
+		[2]string{"a.csv", inputSample},
+		[2]string{"b.csv", "ID,Name\n4,Dewi\n"},
+		[2]string{"broken.csv", "ID,Name\n5,\"Eka\n"},
+		[2]string{"__MACOSX/._a.csv", "\x00\x05\x16\x07"},
+	)
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	hidden := filepath.Join(dir, "hidden.zip")
+	if err := os.WriteFile(hidden, zipBytes(t, [2]string{"__MACOSX/._a.csv", "x"}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := New(WithWorkers(3), WithExtensions([]string{".csv"}), WithLogOutput(io.Discard))
+	results := p.ProcessFiles([]string{archive, hidden})
+
+	type outcome struct {
+		rows, invalid int
+		failed        bool
+	}
+	want := map[string]outcome{
+		"export.zip:a.csv":      {3, 0, false},
+		"export.zip:b.csv":      {1, 0, false},
+		"export.zip:broken.csv": {1, 1, false},
+		"hidden.zip":            {0, 0, true},
+	}
+	got := make(map[string]outcome)
+	for _, r := range results {
+		got[r.FileName] = outcome{r.RowCount, r.InvalidRows, r.Error != nil}
+		if r.FileName == "hidden.zip" && (r.Error == nil || !strings.Contains(r.Error.Error(), "no entries")) {
+			t.Errorf("hidden.zip failed with %v", r.Error)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got results %v, want %v", got, want)
+	}
+	for name, w := range want {
+		if g, ok := got[name]; !ok || g != w {
+			t.Errorf("%s: got %+v, want %+v", name, g, w)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package csvproc
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"io"
+)
+
+// Limits bound the memory used by ProcessFiles independently of the number
+// of input files.
+type Limits struct {
+	// InFlightFiles caps the jobs that are queued, being processed or
+	// waiting to be collected. 0 means four per worker.
+	InFlightFiles int
+	// RowBuffer is how many records each worker reads ahead of the row
+	// currently being handled. 0 reads synchronously.
+	RowBuffer int
+	// ReadAhead is the size of the read buffer of each input. 0 keeps the
+	// encoding/csv default of 4KB.
+	ReadAhead int
+}
+
+// inFlight returns the effective cap on in-flight jobs for maxWorkers.
+func (l Limits) inFlight(maxWorkers int) int {
+	if l.InFlightFiles > 0 {
+		return l.InFlightFiles
+	}
+	return 4 * max(maxWorkers, 1)
+}
+
+func (l Limits) newReader(input io.Reader, comma rune) *csv.Reader {
+	if l.ReadAhead > 0 {
+		input = bufio.NewReaderSize(input, l.ReadAhead)
+	}
+	reader := csv.NewReader(input)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	return reader
+}
+
+// csvRecord is a record together with its position in the input.
+type csvRecord struct {
+	fields []string
+	line   int   // first line of the record
+	offset int64 // input offset right after the record
+	err    error
+}
+
+// recordSource reads records from a csv.Reader, optionally on a separate
+// goroutine that stays up to buffer records ahead of the caller. Offset
+// always refers to the last record returned by Next, not to what has been
+// read ahead, so it is safe to checkpoint.
+type recordSource struct {
+	reader *csv.Reader
+	ahead  chan csvRecord
+	stop   chan struct{}
+	done   chan struct{}
+	offset int64
+}
+
+func newRecordSource(reader *csv.Reader, buffer int) *recordSource {
+	src := &recordSource{reader: reader}
+	if buffer <= 0 {
+		return src
+	}
+
+	src.ahead = make(chan csvRecord, buffer)
+	src.stop = make(chan struct{})
+	src.done = make(chan struct{})
+	go func() {
+		defer close(src.done)
+		defer close(src.ahead)
+		for {
+			rec := src.read()
+			select {
+			case src.ahead <- rec:
+			case <-src.stop:
+				return
+			}
+			var parseErr *csv.ParseError
+			if rec.err != nil && !errors.As(rec.err, &parseErr) {
+				return
+			}
+		}
+	}()
+	return src
+}
+
+func (s *recordSource) read() csvRecord {
+	fields, err := s.reader.Read()
+	rec := csvRecord{fields: fields, offset: s.reader.InputOffset(), err: err}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		rec.line = parseErr.StartLine
+	} else if err == nil && len(fields) > 0 {
+		rec.line, _ = s.reader.FieldPos(0)
+	}
+	return rec
+}
+
+// Next returns the next record. At the end of the input its err is io.EOF.
+func (s *recordSource) Next() csvRecord {
+	var rec csvRecord
+	if s.ahead == nil {
+		rec = s.read()
+	} else if r, ok := <-s.ahead; ok {
+		rec = r
+	} else {
+		rec = csvRecord{offset: s.offset, err: io.EOF}
+	}
+	s.offset = rec.offset
+	return rec
+}
+
+// Offset returns the input offset after the last record returned by Next.
+func (s *recordSource) Offset() int64 {
+	return s.offset
+}
+
+// Close stops reading ahead. It must be called before the input is closed.
+func (s *recordSource) Close() {
+	if s.ahead == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
@@ -0,0 +1,108 @@
+package csvproc
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestRecordSourceOffsets(t *testing.T) {
+	// Quoted newlines, CRLF endings and a broken quote, each record
+	// followed by the offset right after it
+	records := []struct {
+		text string
+		line int
+		bad  bool
+	}{
+		{"ID,Note\n", 1, false},
+		{"1,plain\r\n", 2, false},
+		{"2,\"two\nlines\"\n", 3, false},
+		{"3,\"a \"\"quoted\"\" word\"\n", 5, false},
+		{"4,bro\"ken\n", 6, true},
+		{"5,last", 7, false},
+	}
+	var input strings.Builder
+	var offsets []int64
+	for _, r := range records {
+		input.WriteString(r.text)
+		offsets = append(offsets, int64(input.Len()))
+	}
+
+	for _, buffer := range []int{0, 1, 2, 100} {
+		src := newRecordSource(Limits{ReadAhead: 16}.newReader(strings.NewReader(input.String()), ','), buffer)
+		for i, r := range records {
+			rec := src.Next()
+			var parseErr *csv.ParseError
+			if bad := errors.As(rec.err, &parseErr); bad != r.bad || !bad && rec.err != nil {
+				t.Fatalf("buffer %d, record %d: got error %v", buffer, i, rec.err)
+			}
+			if rec.line != r.line {
+				t.Errorf("buffer %d, record %d: got line %d, want %d", buffer, i, rec.line, r.line)
+			}
+			// However far the reader got, the offset is that of the
+			// record just returned
+			if rec.offset != offsets[i] || src.Offset() != offsets[i] {
+				t.Errorf("buffer %d, record %d: got offset %d and Offset() %d, want %d", buffer, i, rec.offset, src.Offset(), offsets[i])
+			}
+		}
+		for range 2 {
+			if rec := src.Next(); rec.err != io.EOF || src.Offset() != int64(input.Len()) {
+				t.Errorf("buffer %d: got %v at offset %d after the last record", buffer, rec.err, src.Offset())
+			}
+		}
+		src.Close()
+	}
+}
+
+func TestRecordSourceReadsAhead(t *testing.T) {
+	input := "ID\n1\n2\n3\n4\n5\n6\n7\n8\n"
+	reader := csv.NewReader(strings.NewReader(input))
+	src := newRecordSource(reader, 3)
+	defer src.Close()
+
+	if rec := src.Next(); !slices.Equal(rec.fields, []string{"ID"}) || src.Offset() != 3 {
+		t.Fatalf("got %q at offset %d", rec.fields, src.Offset())
+	}
+	// The goroutine fills the buffer and blocks on the next record, so
+	// five records are read while one was returned
+	for range 100 {
+		if len(src.ahead) == cap(src.ahead) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := len(src.ahead); got != 3 {
+		t.Errorf("%d records buffered, want 3", got)
+	}
+	if src.Offset() != 3 {
+		t.Errorf("Offset() = %d after reading ahead, want 3", src.Offset())
+	}
+}
+
+func TestRecordSourceStopsAtReadError(t *testing.T) {
+	errRead := errors.New("disk gone")
+	failing := io.MultiReader(strings.NewReader("ID\n1\n"), iotest.ErrReader(errRead))
+	src := newRecordSource(csv.NewReader(failing), 4)
+	defer src.Close()
+
+	var got []string
+	var rec csvRecord
+	for rec = src.Next(); rec.err == nil; rec = src.Next() {
+		got = append(got, rec.fields[0])
+	}
+	if !slices.Equal(got, []string{"ID", "1"}) || !errors.Is(rec.err, errRead) {
+		t.Errorf("got %q then %v", got, rec.err)
+	}
+	if src.Offset() != 5 {
+		t.Errorf("Offset() = %d, want 5", src.Offset())
+	}
+	// Nothing is read after a failure of the input itself
+	if rec := src.Next(); rec.err != io.EOF || src.Offset() != 5 {
+		t.Errorf("got %v at offset %d after the failure", rec.err, src.Offset())
+	}
+}
@@ -0,0 +1,333 @@
+package csvproc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Masking policies.
+const (
+	MaskRedact  = "redact"  // replaced by RedactedValue
+	MaskPartial = "partial" // first letter of every word kept, h****@yahoo.com for emails
+	MaskHash    = "hash"    // hex SHA-256 of the salt and the value
+	MaskToken   = "token"   // reversible, keeps length, punctuation and letter/digit positions
+)
+
+// RedactedValue replaces values masked with MaskRedact.
+const RedactedValue = "REDACTED"
+
+// tokenRounds is the number of Feistel rounds of MaskToken.
+const tokenRounds = 10
+
+// MaskRule masks one column.
+type MaskRule struct {
+	Column string
+	Policy string
+}
+
+// ParseMaskRules parses a list such as "Name:partial,Email:token".
+func ParseMaskRules(s string) ([]MaskRule, error) {
+	var rules []MaskRule
+	for _, part := range SplitList(s) {
+		col, policy, ok := strings.Cut(part, ":")
+		rule := MaskRule{Column: strings.TrimSpace(col), Policy: strings.ToLower(strings.TrimSpace(policy))}
+		if !ok || rule.Column == "" {
+			return nil, fmt.Errorf("mask rule %q: expected Column:policy", part)
+		}
+		switch rule.Policy {
+		case MaskRedact, MaskPartial, MaskHash, MaskToken:
+		default:
+			return nil, fmt.Errorf("mask rule %q: policy must be redact, partial, hash or token", part)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// MaskKey holds the secrets of the hash and token policies. Whoever has it
+// can link hashed values and reverse tokens, so it stays with the data
+// owner.
+type MaskKey struct {
+	Salt     []byte `json:"salt"`
+	TokenKey []byte `json:"token_key"`
+}
+
+// LoadMaskKey reads the key file at path. A missing file is created with
+// fresh random secrets, readable by the owner only.
+func LoadMaskKey(path string) (*MaskKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		key := &MaskKey{Salt: make([]byte, 32), TokenKey: make([]byte, 32)}
+		if _, err := rand.Read(key.Salt); err != nil {
+			return nil, err
+		}
+		if _, err := rand.Read(key.TokenKey); err != nil {
+			return nil, err
+		}
+		data, err := json.MarshalIndent(key, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Write(append(data, '\n')); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return key, file.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var key MaskKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("parse mask key %s: %w", path, err)
+	}
+	if len(key.Salt) == 0 || len(key.TokenKey) == 0 {
+		return nil, fmt.Errorf("mask key %s: salt and token_key are required", path)
+	}
+	return &key, nil
+}
+
+// Masker masks the configured columns of rows before they are written to the
+// sink, the rejects file or the conflicts file.
+type Masker struct {
+	rules map[string]string // column to policy
+	key   *MaskKey
+}
+
+// NewMasker returns a masker for rules. The hash and token policies need
+// key.
+func NewMasker(rules []MaskRule, key *MaskKey) (*Masker, error) {
+	m := &Masker{rules: make(map[string]string, len(rules)), key: key}
+	for _, rule := range rules {
+		if (rule.Policy == MaskHash || rule.Policy == MaskToken) && key == nil {
+			return nil, fmt.Errorf("mask policy %s of %s needs a key file", rule.Policy, rule.Column)
+		}
+		m.rules[rule.Column] = rule.Policy
+	}
+	return m, nil
+}
+
+// Wrap returns a stage that hands a masked copy of every row to next, so
+// that earlier stages, aggregates and the user loader keep the real values.
+func (m *Masker) Wrap(next Stage) Stage {
+	return func(ctx context.Context, row *Row) error {
+		return next(ctx, m.MaskRow(row))
+	}
+}
+
+// MaskRow returns a copy of row with the configured columns masked.
+func (m *Masker) MaskRow(row *Row) *Row {
+	masked := *row
+	masked.Fields = m.MaskRecord(row.Header, row.Fields)
+	masked.User = nil
+	return &masked
+}
+
+// MaskRecord returns a copy of record with the values of the configured
+// columns of header masked.
+func (m *Masker) MaskRecord(header *Header, record []string) []string {
+	masked := slices.Clone(record)
+	for col, policy := range m.rules {
+		if i, ok := header.Index(col); ok && i < len(masked) && masked[i] != "" {
+			masked[i] = m.mask(col, policy, masked[i])
+		}
+	}
+	return masked
+}
+
+// maskReason masks the value of column quoted in a validation reason, such
+// as invalid email "x@". Only the quoted value is replaced, a short value
+// such as an age of 2 would otherwise also rewrite the rest of the reason.
+func (m *Masker) maskReason(column, value, reason string) string {
+	value = strings.TrimSpace(value)
+	if _, ok := m.rules[column]; !ok || value == "" {
+		return reason
+	}
+	masked := m.Mask(column, value)
+	return strings.ReplaceAll(reason, strconv.Quote(value), strconv.Quote(masked))
+}
+
+// Mask returns value of column masked according to its rule.
+func (m *Masker) Mask(column, value string) string {
+	policy, ok := m.rules[column]
+	if !ok || value == "" {
+		return value
+	}
+	return m.mask(column, policy, value)
+}
+
+// Unmask reverses a value of column masked with MaskToken.
+func (m *Masker) Unmask(column, value string) (string, error) {
+	if policy := m.rules[column]; policy != MaskToken {
+		return "", fmt.Errorf("column %s is not tokenised", column)
+	}
+	return m.tokenise(column, value, true), nil
+}
+
+func (m *Masker) mask(column, policy, value string) string {
+	switch policy {
+	case MaskRedact:
+		return RedactedValue
+	case MaskPartial:
+		return maskPartial(value)
+	case MaskHash:
+		h := sha256.New()
+		h.Write(m.key.Salt)
+		h.Write([]byte(value))
+		return hex.EncodeToString(h.Sum(nil))
+	case MaskToken:
+		return m.tokenise(column, value, false)
+	}
+	return value
+}
+
+// maskPartial keeps the first letter of every word, and the domain of an
+// email address.
+func maskPartial(value string) string {
+	if local, domain, ok := strings.Cut(value, "@"); ok && local != "" && !strings.ContainsAny(domain, "@ ") {
+		return maskWords(local, false) + "@" + domain
+	}
+	return maskWords(value, true)
+}
+
+func maskWords(s string, words bool) string {
+	var b strings.Builder
+	first := true
+	for _, r := range s {
+		switch {
+		case words && r == ' ':
+			b.WriteRune(r)
+			first = true
+		case first:
+			b.WriteRune(r)
+			first = false
+		default:
+			b.WriteByte('*')
+		}
+	}
+	return b.String()
+}
+
+// tokenise encrypts, or with reverse decrypts, the ASCII letters and digits
+// of value with a Feistel network keyed by the token key and column. Every
+// position keeps its class (digit, lower or upper case letter) and all other
+// characters are left in place, so tokens have the shape of the original.
+func (m *Masker) tokenise(column, value string, reverse bool) string {
+	runes := []rune(value)
+	var pos, radix []int
+	var digits []int64
+	for i, r := range runes {
+		switch {
+		case r >= '0' && r <= '9':
+			pos, radix, digits = append(pos, i), append(radix, 10), append(digits, int64(r-'0'))
+		case r >= 'a' && r <= 'z':
+			pos, radix, digits = append(pos, i), append(radix, 26), append(digits, int64(r-'a'))
+		case r >= 'A' && r <= 'Z':
+			pos, radix, digits = append(pos, i), append(radix, 26), append(digits, int64(r-'A'))
+		}
+	}
+	if len(pos) == 0 {
+		return value
+	}
+
+	// The two halves are mixed radix numbers, the rounds alternate between
+	// the moduli of the first and the second half like in FF1
+	u := len(pos) / 2
+	half := [2][]int{radix[:u], radix[u:]}
+	a, b := toNumber(digits[:u], half[0]), toNumber(digits[u:], half[1])
+	mod := [2]*big.Int{modulus(half[0]), modulus(half[1])}
+	if !reverse {
+		for i := range tokenRounds {
+			c := m.round(column, len(pos), i, b, mod[i%2])
+			c.Add(c, a).Mod(c, mod[i%2])
+			a, b = b, c
+		}
+	} else {
+		for i := tokenRounds - 1; i >= 0; i-- {
+			c := m.round(column, len(pos), i, a, mod[i%2])
+			c.Sub(b, c).Mod(c, mod[i%2])
+			a, b = c, a
+		}
+	}
+
+	out := append(fromNumber(a, half[0]), fromNumber(b, half[1])...)
+	for n, i := range pos {
+		switch {
+		case radix[n] == 10:
+			runes[i] = '0' + rune(out[n])
+		case runes[i] >= 'a':
+			runes[i] = 'a' + rune(out[n])
+		default:
+			runes[i] = 'A' + rune(out[n])
+		}
+	}
+	return string(runes)
+}
+
+// round is the round function: an HMAC-SHA256 stream over the number of
+// characters n, the round, the column and x, reduced modulo mod with 128
+// extra bits against bias.
+func (m *Masker) round(column string, n, i int, x, mod *big.Int) *big.Int {
+	need := (mod.BitLen()+7)/8 + 16
+	var stream []byte
+	for block := uint32(0); len(stream) < need; block++ {
+		h := hmac.New(sha256.New, m.key.TokenKey)
+		var hdr [12]byte
+		binary.BigEndian.PutUint32(hdr[:4], uint32(n))
+		binary.BigEndian.PutUint32(hdr[4:8], uint32(i))
+		binary.BigEndian.PutUint32(hdr[8:], block)
+		h.Write(hdr[:])
+		h.Write([]byte(column))
+		h.Write([]byte{0})
+		h.Write(x.Bytes())
+		stream = h.Sum(stream)
+	}
+	y := new(big.Int).SetBytes(stream[:need])
+	return y.Mod(y, mod)
+}
+
+func modulus(radix []int) *big.Int {
+	m := big.NewInt(1)
+	for _, r := range radix {
+		m.Mul(m, big.NewInt(int64(r)))
+	}
+	return m
+}
+
+func toNumber(digits []int64, radix []int) *big.Int {
+	n := new(big.Int)
+	for i, d := range digits {
+		n.Mul(n, big.NewInt(int64(radix[i])))
+		n.Add(n, big.NewInt(d))
+	}
+	return n
+}
+
+func fromNumber(n *big.Int, radix []int) []int64 {
+	digits := make([]int64, len(radix))
+	n = new(big.Int).Set(n)
+	r := new(big.Int)
+	for i := len(radix) - 1; i >= 0; i-- {
+		n.QuoRem(n, big.NewInt(int64(radix[i])), r)
+		digits[i] = r.Int64()
+	}
+	return digits
+}
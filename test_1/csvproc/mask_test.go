@@ -0,0 +1,206 @@
+package csvproc
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func testMasker(t *testing.T, rules string) *Masker {
+	t.Helper()
+	parsed, err := ParseMaskRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &MaskKey{Salt: []byte("salt"), TokenKey: []byte("0123456789abcdef0123456789abcdef")}
+	m, err := NewMasker(parsed, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// class is the token class of r: digits and ASCII letters keep theirs,
+// every other rune must stay as it is.
+func class(r rune) rune {
+	switch {
+	case r >= '0' && r <= '9':
+		return '0'
+	case r >= 'a' && r <= 'z':
+		return 'a'
+	case r >= 'A' && r <= 'Z':
+		return 'A'
+	}
+	return r
+}
+
+func TestMaskTokenRoundTrip(t *testing.T) {
+	m := testMasker(t, "Code:token")
+
+	tests := []string{
+		"7",
+		"x",
+		"Q",
+		"42",
+		"0000000000",
+		"081234567890",
+		"aB",
+		"JohnDoe",
+		"john.doe+1@Example.COM",
+		"Jl. Sudirman No. 5",
+		"Ünïcödé 名前 42",
+		"名前",
+		"-",
+		"a-b-c-d-e-f-g-h-i-j-k-l-m-n-o-p-q-r-s-t-u-v-w-x-y-z-0123456789",
+	}
+	for _, value := range tests {
+		t.Run(value, func(t *testing.T) {
+			token := m.Mask("Code", value)
+			if again := m.Mask("Code", value); again != token {
+				t.Errorf("masked to %q and %q", token, again)
+			}
+
+			got, want := []rune(token), []rune(value)
+			if len(got) != len(want) {
+				t.Fatalf("token %q has %d runes, want %d", token, len(got), len(want))
+			}
+			for i := range want {
+				if class(got[i]) != class(want[i]) {
+					t.Errorf("rune %d of token %q is %q, value has %q", i, token, got[i], want[i])
+				}
+			}
+
+			plain, err := m.Unmask("Code", token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plain != value {
+				t.Errorf("unmasked %q to %q, want %q", token, plain, value)
+			}
+		})
+	}
+}
+
+func TestMaskTokenIsPermutation(t *testing.T) {
+	m := testMasker(t, "Code:token")
+
+	// All three character numbers map to distinct tokens
+	seen := make(map[string]string)
+	for n := range 1000 {
+		value := strconv.Itoa(1000 + n)[1:]
+		token := m.Mask("Code", value)
+		if prev, ok := seen[token]; ok {
+			t.Fatalf("%s and %s both masked to %s", prev, value, token)
+		}
+		seen[token] = value
+	}
+
+	// The column is part of the key
+	other := testMasker(t, "Other:token")
+	if m.Mask("Code", "123456") == other.Mask("Other", "123456") {
+		t.Error("different columns masked to the same token")
+	}
+}
+
+func TestMaskPolicies(t *testing.T) {
+	m := testMasker(t, "Name:partial,Email:partial,City:redact,Phone:hash")
+
+	tests := []struct {
+		column, value, want string
+	}{
+		{"Name", "Budi Santoso", "B*** S******"},
+		{"Email", "hello@yahoo.com", "h****@yahoo.com"},
+		{"Email", "not an email", "n** a* e****"},
+		{"City", "Jakarta", RedactedValue},
+		{"Phone", "", ""},
+		{"Age", "31", "31"},
+	}
+	for _, tt := range tests {
+		if got := m.Mask(tt.column, tt.value); got != tt.want {
+			t.Errorf("Mask(%s, %q) = %q, want %q", tt.column, tt.value, got, tt.want)
+		}
+	}
+
+	hashed := m.Mask("Phone", "0812")
+	if len(hashed) != 64 || hashed == m.Mask("Phone", "0813") {
+		t.Errorf("got hash %q", hashed)
+	}
+	if _, err := m.Unmask("Phone", hashed); err == nil {
+		t.Error("unmasked a hashed column")
+	}
+}
+
+func TestMaskReason(t *testing.T) {
+	m := testMasker(t, "Age:redact,Email:redact")
+
+	tests := []struct {
+		column, value, reason, want string
+	}{
+		{"Age", "2", `"2" is below minimum 12`, `"REDACTED" is below minimum 12`},
+		{"Age", " 200 ", `"200" is above maximum 120`, `"REDACTED" is above maximum 120`},
+		{"Email", "x@", `invalid email "x@"`, `invalid email "REDACTED"`},
+		{"Name", "2", `"2" is below minimum 12`, `"2" is below minimum 12`},
+	}
+	for _, tt := range tests {
+		if got := m.maskReason(tt.column, tt.value, tt.reason); got != tt.want {
+			t.Errorf("maskReason(%s, %q, %q) = %q, want %q", tt.column, tt.value, tt.reason, got, tt.want)
+		}
+	}
+}
+
+func TestLoadMaskKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mask-key.json")
+
+	created, err := LoadMaskKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created.Salt) != 32 || len(created.TokenKey) != 32 {
+		t.Fatalf("got a salt of %d and a token key of %d bytes, want 32", len(created.Salt), len(created.TokenKey))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("key file has mode %v, want 0600", perm)
+	}
+
+	// Loading it again yields the same secrets, so tokens stay reversible
+	loaded, err := LoadMaskKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(loaded.Salt) != string(created.Salt) || string(loaded.TokenKey) != string(created.TokenKey) {
+		t.Error("reloaded key differs from the created one")
+	}
+	rules := []MaskRule{{Column: "Email", Policy: MaskToken}}
+	a, _ := NewMasker(rules, created)
+	b, _ := NewMasker(rules, loaded)
+	token := a.Mask("Email", "ana@example.com")
+	if plain, err := b.Unmask("Email", token); err != nil || plain != "ana@example.com" {
+		t.Errorf("unmasked %q with the reloaded key to %q, %v", token, plain, err)
+	}
+
+	other, err := LoadMaskKey(filepath.Join(t.TempDir(), "mask-key.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(other.TokenKey) == string(created.TokenKey) {
+		t.Error("two created keys are equal")
+	}
+
+	for name, data := range map[string]string{
+		"invalid.json": "{",
+		"empty.json":   `{"salt": ""}`,
+	} {
+		bad := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(bad, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadMaskKey(bad); err == nil {
+			t.Errorf("%s: loaded an invalid key file", name)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package csvproc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics exposes the progress of one or more ProcessFiles calls as
+// Prometheus metrics and as a JSON progress document. Throughput is left to
+// rate() over the row and byte counters. A nil *Metrics records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+	files    *prometheus.CounterVec
+	rows     prometheus.Counter
+	bytes    prometheus.Counter
+	busy     *prometheus.CounterVec
+	queue    prometheus.Gauge
+	workers  prometheus.Gauge
+
+	started   time.Time
+	rowsRead  atomic.Int64
+	bytesRead atomic.Int64
+
+	mu          sync.Mutex
+	filesTotal  int
+	filesDone   int
+	filesFailed int
+	sizeTotal   int64
+	sizeDone    int64
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		files: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "csvproc_files_completed_total",
+			Help: "Files processed, by status (ok or failed).",
+		}, []string{"status"}),
+		rows: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "csvproc_rows_total",
+			Help: "Rows read from all inputs.",
+		}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "csvproc_bytes_total",
+			Help: "CSV bytes read from all inputs, after decompression.",
+		}),
+		busy: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "csvproc_worker_busy_seconds_total",
+			Help: "Time spent processing jobs, by worker slot. A slot is reused once its worker retires.",
+		}, []string{"slot"}),
+		queue: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "csvproc_queue_depth",
+			Help: "Jobs waiting for a worker.",
+		}),
+		workers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "csvproc_workers",
+			Help: "Workers currently running.",
+		}),
+		started: time.Now(),
+	}
+	m.files.WithLabelValues("ok")
+	m.files.WithLabelValues("failed")
+
+	m.registry.MustRegister(
+		m.files, m.rows, m.bytes, m.busy, m.queue, m.workers,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "csvproc_files",
+			Help: "Files handed to the processor.",
+		}, func() float64 { return float64(m.Progress().FilesTotal) }),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+	)
+	return m
+}
+
+// addFiles registers the jobs of a ProcessFiles call; sizes are their
+// sizes on disk and weigh each file in the ETA.
+func (m *Metrics) addFiles(sizes map[int]int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.filesTotal += len(sizes)
+	for _, size := range sizes {
+		m.sizeTotal += size
+	}
+}
+
+func (m *Metrics) fileDone(result ProcessResult, size int64) {
+	if m == nil {
+		return
+	}
+	status := "ok"
+	m.mu.Lock()
+	m.filesDone++
+	m.sizeDone += size
+	if result.Error != nil {
+		status = "failed"
+		m.filesFailed++
+	}
+	m.mu.Unlock()
+	m.files.WithLabelValues(status).Inc()
+}
+
+func (m *Metrics) observeRow(bytes int64) {
+	if m == nil {
+		return
+	}
+	m.rowsRead.Add(1)
+	m.bytesRead.Add(bytes)
+	m.rows.Inc()
+	m.bytes.Add(float64(bytes))
+}
+
+func (m *Metrics) observeBusy(slot int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.busy.WithLabelValues(strconv.Itoa(slot)).Add(d.Seconds())
+}
+
+func (m *Metrics) addQueued(n int) {
+	if m != nil {
+		m.queue.Add(float64(n))
+	}
+}
+
+func (m *Metrics) addWorkers(n int) {
+	if m != nil {
+		m.workers.Add(float64(n))
+	}
+}
+
+// Progress is the document served on /progress.
+type Progress struct {
+	StartedAt   time.Time `json:"started_at"`
+	ElapsedSec  float64   `json:"elapsed_seconds"`
+	FilesTotal  int       `json:"files_total"`
+	FilesDone   int       `json:"files_done"`
+	FilesFailed int       `json:"files_failed"`
+	Rows        int64     `json:"rows"`
+	Bytes       int64     `json:"bytes"`
+	RowsPerSec  float64   `json:"rows_per_second"`  // average since start
+	BytesPerSec float64   `json:"bytes_per_second"` // average since start
+	Percent     float64   `json:"percent"`
+	ETASec      *float64  `json:"eta_seconds"` // null until the first file is done
+}
+
+// Progress returns a snapshot of the run. The ETA extrapolates the time
+// taken so far by the share of the input size, on disk, that is complete.
+func (m *Metrics) Progress() Progress {
+	m.mu.Lock()
+	p := Progress{
+		StartedAt:   m.started,
+		ElapsedSec:  time.Since(m.started).Seconds(),
+		FilesTotal:  m.filesTotal,
+		FilesDone:   m.filesDone,
+		FilesFailed: m.filesFailed,
+		Rows:        m.rowsRead.Load(),
+		Bytes:       m.bytesRead.Load(),
+	}
+	done := 0.0
+	switch {
+	case m.sizeTotal > 0:
+		done = float64(m.sizeDone) / float64(m.sizeTotal)
+	case m.filesTotal > 0:
+		done = float64(m.filesDone) / float64(m.filesTotal)
+	}
+	m.mu.Unlock()
+
+	if p.ElapsedSec > 0 {
+		p.RowsPerSec = float64(p.Rows) / p.ElapsedSec
+		p.BytesPerSec = float64(p.Bytes) / p.ElapsedSec
+	}
+	p.Percent = done * 100
+	if done > 0 {
+		eta := p.ElapsedSec * (1 - done) / done
+		p.ETASec = &eta
+	}
+	return p
+}
+
+// Serve serves /metrics and /progress on addr until ctx is cancelled. It
+// returns once the listener is bound.
+func (m *Metrics) Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/progress", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(m.Progress())
+	})
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics: %w", err)
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "metrics: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// jobSize is the size on disk of a job; entries of a zip archive share the
+// size of the archive.
+func jobSize(job FileJob, entries int) int64 {
+	if job.Length > 0 {
+		return job.Length
+	}
+	info, err := os.Stat(job.FilePath)
+	if err != nil {
+		return 0
+	}
+	return info.Size() / int64(max(entries, 1))
+}
@@ -0,0 +1,158 @@
+package csvproc
+
+import (
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	type step struct {
+		rows, bytes int64 // read during the step
+		done        []int // file numbers completed during the step
+		failed      bool  // whether those files failed
+	}
+	tests := []struct {
+		name        string
+		sizes       map[int]int64
+		steps       []step
+		wantPercent float64
+		wantETA     float64 // -1 when there is none yet
+	}{
+		{"nothing done", map[int]int64{1: 100, 2: 300}, []step{{rows: 5, bytes: 50}}, 0, -1},
+		{"weighed by size", map[int]int64{1: 100, 2: 300}, []step{{rows: 10, bytes: 120, done: []int{1}}}, 25, 30},
+		{"failed files count as done", map[int]int64{1: 100, 2: 100, 3: 200}, []step{
+			{rows: 10, bytes: 100, done: []int{1}},
+			{rows: 0, bytes: 0, done: []int{2}, failed: true},
+		}, 50, 10},
+		{"by file count without sizes", map[int]int64{1: 0, 2: 0, 3: 0, 4: 0}, []step{{rows: 20, bytes: 200, done: []int{1, 2, 3}}}, 75, 10.0 / 3},
+		{"complete", map[int]int64{1: 100}, []step{{rows: 30, bytes: 300, done: []int{1}}}, 100, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMetrics()
+			m.addFiles(tt.sizes)
+			var rows, bytes int64
+			var failed int
+			for _, s := range tt.steps {
+				for range s.rows {
+					m.observeRow(s.bytes / s.rows)
+				}
+				rows += s.rows
+				bytes += s.bytes
+				for _, n := range s.done {
+					result := ProcessResult{}
+					if s.failed {
+						result.Error = io.ErrUnexpectedEOF
+						failed++
+					}
+					m.fileDone(result, tt.sizes[n])
+				}
+			}
+			// Pretend the run started ten seconds ago
+			m.started = time.Now().Add(-10 * time.Second)
+			p := m.Progress()
+
+			if p.FilesTotal != len(tt.sizes) || p.FilesFailed != failed || p.Rows != rows || p.Bytes != bytes {
+				t.Errorf("got %+v", p)
+			}
+			near := func(got, want float64) bool { return math.Abs(got-want) < 0.01*max(want, 1) }
+			if !near(p.ElapsedSec, 10) {
+				t.Errorf("elapsed %.2fs, want 10s", p.ElapsedSec)
+			}
+			if !near(p.RowsPerSec, float64(rows)/p.ElapsedSec) || !near(p.BytesPerSec, float64(bytes)/p.ElapsedSec) {
+				t.Errorf("got %.2f rows/s and %.2f bytes/s", p.RowsPerSec, p.BytesPerSec)
+			}
+			if !near(p.Percent, tt.wantPercent) {
+				t.Errorf("got %.2f%%, want %.2f%%", p.Percent, tt.wantPercent)
+			}
+			switch {
+			case tt.wantETA < 0 && p.ETASec != nil:
+				t.Errorf("got ETA %.2fs before any file was done", *p.ETASec)
+			case tt.wantETA >= 0 && (p.ETASec == nil || !near(*p.ETASec, tt.wantETA)):
+				t.Errorf("got ETA %v, want %.2fs", p.ETASec, tt.wantETA)
+			}
+		})
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.addFiles(map[int]int64{1: 10})
+	m.fileDone(ProcessResult{}, 10)
+	m.observeRow(10)
+	m.observeBusy(0, time.Second)
+	m.addQueued(1)
+	m.addWorkers(1)
+}
+
+// busySlots returns the slot labels of the busy metric.
+func busySlots(t *testing.T, m *Metrics) []string {
+	t.Helper()
+	families, err := m.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var slots []string
+	for _, f := range families {
+		if f.GetName() != "csvproc_worker_busy_seconds_total" {
+			continue
+		}
+		for _, metric := range f.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				slots = append(slots, label.GetValue())
+			}
+		}
+	}
+	slices.Sort(slots)
+	return slots
+}
+
+func TestWorkerPoolReusesSlots(t *testing.T) {
+	cp := New(WithWorkers(4), WithLogOutput(io.Discard))
+	jobs := make(chan FileJob)
+	results := make(chan jobResult)
+	var wg sync.WaitGroup
+	pool := newWorkerPool(cp, jobs, results, &wg)
+
+	pool.grow(3)
+	pool.shrink(2)
+	// Wait for the retired workers to hand back their slots
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		pool.mu.Lock()
+		taken := 0
+		for _, s := range pool.slots {
+			if s {
+				taken++
+			}
+		}
+		pool.mu.Unlock()
+		if taken == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d slots still taken", taken)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	pool.grow(3)
+	if len(pool.slots) != 4 {
+		t.Errorf("got %d slots for 4 workers", len(pool.slots))
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func TestProcessBusyMetricBySlot(t *testing.T) {
+	m := NewMetrics()
+	paths := sampleFiles(t, 6)
+	for range 3 {
+		New(WithWorkers(2), WithMetrics(m), WithLogOutput(io.Discard)).ProcessFiles(paths)
+	}
+	if got := busySlots(t, m); !slices.Equal(got, []string{"0", "1"}) {
+		t.Errorf("got slots %q, want 0 and 1", got)
+	}
+}
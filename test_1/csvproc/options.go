@@ -0,0 +1,162 @@
+package csvproc
+
+import (
+	"context"
+	"io"
+	"iter"
+	"runtime"
+	"time"
+)
+
+// Option configures a ConcurrentProcessor created by New. Apart from
+// WithWorkers, every option has a builder method of the same name on
+// ConcurrentProcessor, which documents it.
+type Option func(*ConcurrentProcessor)
+
+// New returns a processor configured by opts. It starts with one worker
+// per CPU unless WithWorkers says otherwise.
+//
+//	p := csvproc.New(
+//		csvproc.WithWorkers(8),
+//		csvproc.WithHandler(csvproc.NewPipeline()),
+//	)
+//	for result := range p.Process(ctx, files) {
+//		...
+//	}
+func New(opts ...Option) *ConcurrentProcessor {
+	cp := NewProcessor(runtime.NumCPU())
+	for _, opt := range opts {
+		opt(cp)
+	}
+	if cp.adaptive != nil {
+		// Clamp the worker count regardless of the order of the options
+		cp.WithAdaptivePool(*cp.adaptive)
+	}
+	return cp
+}
+
+// WithWorkers sets the number of workers the pool starts with.
+func WithWorkers(n int) Option {
+	return func(cp *ConcurrentProcessor) {
+		cp.workerCount = max(n, 1)
+	}
+}
+
+func WithHandler(handler RowHandler) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithHandler(handler) }
+}
+
+func WithAdaptivePool(cfg AdaptiveConfig) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithAdaptivePool(cfg) }
+}
+
+func WithRetry(cfg RetryConfig) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithRetry(cfg) }
+}
+
+func WithCheckpoint(checkpoint *Checkpoint, resume bool) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithCheckpoint(checkpoint, resume) }
+}
+
+func WithCheckpointInterval(d time.Duration) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithCheckpointInterval(d) }
+}
+
+func WithSinkFlush(fn func() error) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithSinkFlush(fn) }
+}
+
+func WithChunkSize(size int64) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithChunkSize(size) }
+}
+
+func WithLimits(limits Limits) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithLimits(limits) }
+}
+
+func WithOrdered(lookAhead int) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithOrdered(lookAhead) }
+}
+
+func WithRateLimit(global, perFile RateLimit) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithRateLimit(global, perFile) }
+}
+
+func WithResultHandler(fn func(ProcessResult)) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithResultHandler(fn) }
+}
+
+func WithCSVOptions(opts CSVOptions) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithCSVOptions(opts) }
+}
+
+func WithMetrics(m *Metrics) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithMetrics(m) }
+}
+
+func WithAggregator(a *Aggregator) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithAggregator(a) }
+}
+
+func WithUserLoader(l *UserLoader) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithUserLoader(l) }
+}
+
+func WithFilter(f *Filter) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithFilter(f) }
+}
+
+func WithSchema(schema *Schema, rejects *RejectWriter) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithSchema(schema, rejects) }
+}
+
+func WithExtensions(exts []string) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithExtensions(exts) }
+}
+
+func WithRejects(rejects *RejectWriter) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithRejects(rejects) }
+}
+
+func WithLogOutput(w io.Writer) Option {
+	return func(cp *ConcurrentProcessor) { cp.WithLogOutput(w) }
+}
+
+// Process processes the files at paths and yields the result of every file
+// as soon as it is complete. Processing stops when ctx is cancelled or the
+// caller stops iterating. Like with WithResultHandler, the results are not
+// retained by the processor.
+func (cp *ConcurrentProcessor) Process(ctx context.Context, paths []string) iter.Seq[ProcessResult] {
+	return func(yield func(ProcessResult) bool) {
+		cp.WithContext(ctx)
+		procCtx := cp.ctx
+
+		handler := cp.onResult
+		defer func() { cp.onResult = handler }()
+
+		results := make(chan ProcessResult)
+		cp.onResult = func(r ProcessResult) {
+			if handler != nil {
+				handler(r)
+			}
+			select {
+			case results <- r:
+			case <-procCtx.Done():
+			}
+		}
+
+		go func() {
+			defer close(results)
+			cp.ProcessFiles(paths)
+		}()
+
+		for r := range results {
+			if !yield(r) {
+				cp.Cancel()
+				for range results {
+				}
+				return
+			}
+		}
+	}
+}
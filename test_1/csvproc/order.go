@@ -0,0 +1,143 @@
+package csvproc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// orderKey is the context key under which processFile passes the gate of
+// the current job to Ordered.
+type orderKey struct{}
+
+// Ordered wraps the sink stage of a pipeline so that, with WithOrdered, rows
+// reach next in input order: rows of the oldest unfinished job pass straight
+// through, rows of jobs further ahead are held back until every earlier job
+// has been emitted. Without WithOrdered it calls next directly.
+func Ordered(next Stage) Stage {
+	return func(ctx context.Context, row *Row) error {
+		g, _ := ctx.Value(orderKey{}).(*orderGate)
+		if g == nil {
+			return next(ctx, row)
+		}
+
+		g.mu.Lock()
+		if !g.head {
+			g.rows = append(g.rows, row)
+			g.ctx, g.next = ctx, next
+			g.mu.Unlock()
+			return nil
+		}
+		g.mu.Unlock()
+		return next(ctx, row)
+	}
+}
+
+// orderGate holds the rows of one job until it becomes the head, the oldest
+// job not emitted yet.
+type orderGate struct {
+	mu   sync.Mutex
+	head bool
+	rows []*Row
+	ctx  context.Context
+	next Stage
+	err  error // first error of next while flushing
+}
+
+// emitted reports whether every row passed to the gate so far has reached
+// the next stage. A nil gate holds no rows.
+func (g *orderGate) emitted() bool {
+	if g == nil {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.head
+}
+
+// flush writes the held rows and lets later rows pass straight through.
+// Rows added while flushing are written before the gate opens.
+func (g *orderGate) flush() {
+	for {
+		g.mu.Lock()
+		rows := g.rows
+		g.rows = nil
+		if len(rows) == 0 {
+			g.head = true
+			g.mu.Unlock()
+			return
+		}
+		ctx, next := g.ctx, g.next
+		g.mu.Unlock()
+
+		for _, row := range rows {
+			if g.err != nil {
+				break
+			}
+			g.err = next(ctx, row)
+		}
+	}
+}
+
+// reorderBuffer releases job results in the order the jobs were queued and
+// opens the gate of each job once the job before it is released.
+type reorderBuffer struct {
+	mu    sync.Mutex
+	next  int // seq of the next job to release
+	gates map[int]*orderGate
+	done  map[int]jobResult
+}
+
+func newReorderBuffer() *reorderBuffer {
+	return &reorderBuffer{
+		gates: make(map[int]*orderGate),
+		done:  make(map[int]jobResult),
+	}
+}
+
+// gate returns the gate of job seq, creating it on first use.
+func (rb *reorderBuffer) gate(seq int) *orderGate {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return rb.gateLocked(seq)
+}
+
+func (rb *reorderBuffer) gateLocked(seq int) *orderGate {
+	g, ok := rb.gates[seq]
+	if !ok {
+		g = &orderGate{head: seq == rb.next}
+		rb.gates[seq] = g
+	}
+	return g
+}
+
+// push adds a finished job and returns the results that can be released,
+// in order. It is only called from the collecting goroutine.
+func (rb *reorderBuffer) push(jr jobResult) []jobResult {
+	rb.mu.Lock()
+	rb.done[jr.job.seq] = jr
+	rb.mu.Unlock()
+
+	var ready []jobResult
+	for {
+		rb.mu.Lock()
+		jr, ok := rb.done[rb.next]
+		if !ok {
+			rb.mu.Unlock()
+			return ready
+		}
+		delete(rb.done, rb.next)
+		if g := rb.gates[rb.next]; g != nil {
+			delete(rb.gates, rb.next)
+			if g.err != nil && jr.result.Error == nil {
+				jr.result.Error = Classify(fmt.Errorf("ordered output: %w", g.err))
+			}
+		}
+		rb.next++
+		g := rb.gateLocked(rb.next)
+		rb.mu.Unlock()
+
+		g.flush()
+		ready = append(ready, jr)
+	}
+}
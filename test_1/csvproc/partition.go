@@ -0,0 +1,191 @@
+package csvproc
+
+import (
+	"container/list"
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// PartitionOptions configures a PartitionWriter.
+type PartitionOptions struct {
+	Dir string
+
+	// By names the columns rows are partitioned on. Without Buckets each
+	// distinct value gets its own file, named after the values like
+	// City=Jakarta.csv.
+	By []string
+
+	// Buckets spreads rows over that many files part-00000.csv and up by a
+	// hash of the By columns, or of the whole row without By.
+	Buckets int
+
+	// MaxOpen bounds the partition files kept open at once, 128 by default.
+	// Files closed to make room are reopened for appending.
+	MaxOpen int
+}
+
+// PartitionWriter writes rows to partition files, each starting with the
+// same header. It is not safe for concurrent use.
+type PartitionWriter struct {
+	opts    PartitionOptions
+	columns []string
+	byIdx   []int
+	open    map[string]*list.Element // partition file name to lru entry
+	lru     *list.List               // of *partitionFile, most recent first
+	created map[string]bool
+}
+
+type partitionFile struct {
+	name string
+	file *os.File
+	w    *csv.Writer
+}
+
+func NewPartitionWriter(columns []string, opts PartitionOptions) (*PartitionWriter, error) {
+	if len(opts.By) == 0 && opts.Buckets <= 0 {
+		return nil, fmt.Errorf("partitioning needs columns or a number of buckets")
+	}
+	if opts.MaxOpen <= 0 {
+		opts.MaxOpen = 128
+	}
+	p := &PartitionWriter{
+		opts:    opts,
+		columns: columns,
+		open:    make(map[string]*list.Element),
+		lru:     list.New(),
+		created: make(map[string]bool),
+	}
+	for _, col := range opts.By {
+		i := slices.Index(columns, col)
+		if i < 0 {
+			return nil, fmt.Errorf("partition column %q not found", col)
+		}
+		p.byIdx = append(p.byIdx, i)
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Write appends values, in the order of the columns the writer was created
+// with, to their partition.
+func (p *PartitionWriter) Write(values []string) error {
+	f, err := p.file(p.partition(values))
+	if err != nil {
+		return err
+	}
+	return f.w.Write(values)
+}
+
+// Partitions returns the names of the files written so far.
+func (p *PartitionWriter) Partitions() []string {
+	names := make([]string, 0, len(p.created))
+	for name := range p.created {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Close flushes and closes all partition files.
+func (p *PartitionWriter) Close() error {
+	var firstErr error
+	for p.lru.Len() > 0 {
+		if err := p.evict(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// partition returns the file name for values.
+func (p *PartitionWriter) partition(values []string) string {
+	if p.opts.Buckets > 0 {
+		h := fnv.New32a()
+		if len(p.byIdx) == 0 {
+			h.Write([]byte(strings.Join(values, keySep)))
+		}
+		for _, i := range p.byIdx {
+			h.Write([]byte(field(values, i)))
+			h.Write([]byte(keySep))
+		}
+		return fmt.Sprintf("part-%05d.csv", h.Sum32()%uint32(p.opts.Buckets))
+	}
+
+	parts := make([]string, len(p.byIdx))
+	for n, i := range p.byIdx {
+		parts[n] = p.opts.By[n] + "=" + partitionName(field(values, i))
+	}
+	return strings.Join(parts, ",") + ".csv"
+}
+
+// partitionName makes value safe to use in a file name.
+func partitionName(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "_empty_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == ':' || r == '*' || r == '?' || r == '"' || r == '<' || r == '>' || r == '|':
+			return '_'
+		case r < ' ':
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// file returns the open partition file name, opening it if needed and
+// closing the least recently used one when too many are open.
+func (p *PartitionWriter) file(name string) (*partitionFile, error) {
+	if e, ok := p.open[name]; ok {
+		p.lru.MoveToFront(e)
+		return e.Value.(*partitionFile), nil
+	}
+	if p.lru.Len() >= p.opts.MaxOpen {
+		if err := p.evict(); err != nil {
+			return nil, err
+		}
+	}
+
+	path := filepath.Join(p.opts.Dir, name)
+	created := p.created[name]
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if created {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f := &partitionFile{name: name, file: file, w: csv.NewWriter(file)}
+	if !created {
+		p.created[name] = true
+		if err := f.w.Write(p.columns); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	p.open[name] = p.lru.PushFront(f)
+	return f, nil
+}
+
+// evict closes the least recently used partition file.
+func (p *PartitionWriter) evict() error {
+	e := p.lru.Back()
+	f := p.lru.Remove(e).(*partitionFile)
+	delete(p.open, f.name)
+	f.w.Flush()
+	if err := f.w.Error(); err != nil {
+		f.file.Close()
+		return fmt.Errorf("write %s: %w", f.name, err)
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,195 @@
+package csvproc
+
+import (
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// readPartitions returns the records of every file in dir by file name.
+func readPartitions(t *testing.T, dir string) map[string][][]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][][]string)
+	for _, e := range entries {
+		file, err := os.Open(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(file).ReadAll()
+		file.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", e.Name(), err)
+		}
+		files[e.Name()] = records
+	}
+	return files
+}
+
+func TestPartitionWriterByColumns(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	columns := []string{"ID", "City", "Tier"}
+	p, err := NewPartitionWriter(columns, PartitionOptions{Dir: dir, By: []string{"City", "Tier"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]string{
+		{"1", "Jakarta", "A"},
+		{"2", "Bandung", "B"},
+		{"3", "Jakarta", "A"},
+		{"4", " ", "A"},
+		{"5", "a/b:c", "B"},
+		{"6", "Jakarta", "B"},
+	}
+	for _, row := range rows {
+		if err := p.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"City=Jakarta,Tier=A.csv": {"1", "3"},
+		"City=Bandung,Tier=B.csv": {"2"},
+		"City=_empty_,Tier=A.csv": {"4"},
+		"City=a_b_c,Tier=B.csv":   {"5"},
+		"City=Jakarta,Tier=B.csv": {"6"},
+	}
+	files := readPartitions(t, dir)
+	if len(files) != len(want) {
+		t.Errorf("got files %v", slices.Sorted(maps.Keys(files)))
+	}
+	for name, ids := range want {
+		records := files[name]
+		if len(records) == 0 || !slices.Equal(records[0], columns) {
+			t.Errorf("%s: got %q, want a header first", name, records)
+			continue
+		}
+		var got []string
+		for _, r := range records[1:] {
+			got = append(got, r[0])
+		}
+		if !slices.Equal(got, ids) {
+			t.Errorf("%s: got IDs %v, want %v", name, got, ids)
+		}
+	}
+	if got := p.Partitions(); !slices.Equal(got, slices.Sorted(maps.Keys(files))) {
+		t.Errorf("Partitions() = %v", got)
+	}
+}
+
+func TestPartitionWriterReopens(t *testing.T) {
+	dir := t.TempDir()
+	// A file left by an earlier run is truncated when first opened
+	if err := os.WriteFile(filepath.Join(dir, "Group=0.csv"), []byte("stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPartitionWriter([]string{"ID", "Group"}, PartitionOptions{Dir: dir, By: []string{"Group"}, MaxOpen: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Round robin over more groups than files may be open, so every write
+	// after the first round reopens a file closed to make room
+	const groups, rows = 5, 50
+	for i := range rows {
+		if err := p.Write([]string{fmt.Sprint(i), fmt.Sprint(i % groups)}); err != nil {
+			t.Fatal(err)
+		}
+		if p.lru.Len() > 2 {
+			t.Fatalf("%d files open, want at most 2", p.lru.Len())
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readPartitions(t, dir)
+	for g := range groups {
+		name := fmt.Sprintf("Group=%d.csv", g)
+		records := files[name]
+		var want [][]string
+		want = append(want, []string{"ID", "Group"})
+		for i := g; i < rows; i += groups {
+			want = append(want, []string{fmt.Sprint(i), fmt.Sprint(g)})
+		}
+		if !slices.EqualFunc(records, want, slices.Equal) {
+			t.Errorf("%s: got %q, want %q", name, records, want)
+		}
+	}
+}
+
+func TestPartitionWriterBuckets(t *testing.T) {
+	bucketOf := func(parts ...string) string {
+		h := fnv.New32a()
+		for _, part := range parts {
+			h.Write([]byte(part))
+		}
+		return fmt.Sprintf("part-%05d.csv", h.Sum32()%8)
+	}
+
+	tests := []struct {
+		name   string
+		by     []string
+		bucket func(row []string) string
+	}{
+		{"by column", []string{"Email"}, func(row []string) string { return bucketOf(row[1], keySep) }},
+		{"by columns", []string{"Email", "Name"}, func(row []string) string { return bucketOf(row[1], keySep, row[2], keySep) }},
+		{"whole row", nil, func(row []string) string { return bucketOf(row[0] + keySep + row[1] + keySep + row[2]) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			p, err := NewPartitionWriter([]string{"ID", "Email", "Name"}, PartitionOptions{Dir: dir, By: tt.by, Buckets: 8, MaxOpen: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make(map[string][][]string)
+			for i := range 200 {
+				// Emails repeat, so rows sharing one must share a bucket
+				row := []string{fmt.Sprint(i), fmt.Sprintf("user%d@example.com", i%40), fmt.Sprintf("User %d", i%3)}
+				if err := p.Write(row); err != nil {
+					t.Fatal(err)
+				}
+				name := tt.bucket(row)
+				want[name] = append(want[name], row)
+			}
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			files := readPartitions(t, dir)
+			if len(files) < 2 {
+				t.Errorf("rows spread over %d buckets", len(files))
+			}
+			for name, rows := range want {
+				records := files[name]
+				if len(records) == 0 || !slices.Equal(records[0], []string{"ID", "Email", "Name"}) || !slices.EqualFunc(records[1:], rows, slices.Equal) {
+					t.Errorf("%s: got %d records, want a header and %d rows", name, len(records), len(rows))
+				}
+			}
+			if len(files) != len(want) {
+				t.Errorf("got %d files, want %d", len(files), len(want))
+			}
+		})
+	}
+}
+
+func TestNewPartitionWriterErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewPartitionWriter([]string{"ID"}, PartitionOptions{Dir: dir}); err == nil {
+		t.Error("accepted neither columns nor buckets")
+	}
+	if _, err := NewPartitionWriter([]string{"ID"}, PartitionOptions{Dir: dir, By: []string{"City"}}); err == nil {
+		t.Error("accepted an unknown column")
+	}
+}
@@ -0,0 +1,104 @@
+package csvproc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrSkipRow can be returned by a stage to stop the pipeline for a row
+// without failing it, e.g. when the row is held back to be emitted later.
+var ErrSkipRow = errors.New("skip row")
+
+// ErrHoldRow is returned by a stage that keeps a row to emit it later
+// itself, as the Deduper does until all input was seen. Unlike ErrSkipRow it
+// is passed on to the processor, which counts the row as valid but leaves
+// aggregating and loading it to whoever emits it.
+var ErrHoldRow = errors.New("row held back")
+
+// RowHandler is invoked by the workers for every data record of a file.
+// Implementations must be safe for concurrent use.
+type RowHandler interface {
+	HandleRow(ctx context.Context, row *Row) error
+}
+
+// RowHandlerFunc adapts a plain function to the RowHandler interface.
+type RowHandlerFunc func(ctx context.Context, row *Row) error
+
+func (f RowHandlerFunc) HandleRow(ctx context.Context, row *Row) error {
+	return f(ctx, row)
+}
+
+// Stage is a single step of a Pipeline.
+type Stage func(ctx context.Context, row *Row) error
+
+// Pipeline runs a row through parse -> validate -> transform -> sink.
+// Nil stages are skipped.
+type Pipeline struct {
+	Parse     Stage
+	Validate  Stage
+	Transform Stage
+	Sink      Stage
+}
+
+// NewPipeline returns a pipeline without stages. Rows are only parsed into
+// UserRecords after WithUserParse, since that rejects every row whose ID or
+// Age is not an integer.
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// WithUserParse sets the parse stage to ParseUser.
+func (p *Pipeline) WithUserParse() *Pipeline {
+	p.Parse = ParseUser
+	return p
+}
+
+func (p *Pipeline) HandleRow(ctx context.Context, row *Row) error {
+	stages := []struct {
+		name  string
+		stage Stage
+	}{
+		{"parse", p.Parse},
+		{"validate", p.Validate},
+		{"transform", p.Transform},
+		{"sink", p.Sink},
+	}
+
+	for _, s := range stages {
+		if s.stage == nil {
+			continue
+		}
+		if err := s.stage(ctx, row); errors.Is(err, ErrSkipRow) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+// Chain combines several stages into one, run in order.
+func Chain(stages ...Stage) Stage {
+	return func(ctx context.Context, row *Row) error {
+		for _, stage := range stages {
+			if stage == nil {
+				continue
+			}
+			if err := stage(ctx, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ParseUser fills row.User from the header columns.
+func ParseUser(ctx context.Context, row *Row) error {
+	user, err := ParseUserRecord(row)
+	if err != nil {
+		return err
+	}
+	row.User = user
+	return nil
+}
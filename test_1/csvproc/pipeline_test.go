@@ -0,0 +1,84 @@
+package csvproc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPipelineUserParse(t *testing.T) {
+	users := NewHeader([]string{"ID", "Name", "Email", "Age", "City"})
+	other := NewHeader([]string{"sku", "price"})
+
+	tests := []struct {
+		name     string
+		pipeline *Pipeline
+		row      *Row
+		invalid  bool
+		user     bool
+	}{
+		{"no parse stage", NewPipeline(), &Row{Header: other, Fields: []string{"A-1", "9.99"}}, false, false},
+		{"user row", NewPipeline().WithUserParse(), &Row{Header: users, Fields: []string{"7", "Ann", "ann@example.com", "31", "Jakarta"}}, false, true},
+		{"bad age", NewPipeline().WithUserParse(), &Row{Header: users, Fields: []string{"7", "Ann", "ann@example.com", "x", "Jakarta"}}, true, false},
+		{"not a user file", NewPipeline().WithUserParse(), &Row{Header: other, Fields: []string{"A-1", "9.99"}}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.pipeline.HandleRow(context.Background(), tt.row)
+			var verr *ValidationError
+			if got := errors.As(err, &verr); got != tt.invalid {
+				t.Fatalf("got error %v, want invalid = %v", err, tt.invalid)
+			}
+			if !tt.invalid && err != nil {
+				t.Fatal(err)
+			}
+			if (tt.row.User != nil) != tt.user {
+				t.Errorf("got user %+v, want parsed = %v", tt.row.User, tt.user)
+			}
+			if tt.user && (tt.row.User.ID != 7 || tt.row.User.Age != 31 || tt.row.User.Email != "ann@example.com") {
+				t.Errorf("got user %+v", tt.row.User)
+			}
+		})
+	}
+}
+
+func TestPipelineStageOrder(t *testing.T) {
+	schema := &Schema{Columns: []ColumnRule{{Name: "Age", Type: TypeInt, Required: true}}}
+	if err := schema.compile(); err != nil {
+		t.Fatal(err)
+	}
+	header := NewHeader([]string{"ID", "Age"})
+
+	var ran []string
+	stage := func(name string) Stage {
+		return func(ctx context.Context, row *Row) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	p := &Pipeline{
+		Parse:     stage("parse"),
+		Validate:  Chain(stage("validate"), schema.Validate),
+		Transform: stage("transform"),
+		Sink:      stage("sink"),
+	}
+
+	if err := p.HandleRow(context.Background(), &Row{Header: header, Fields: []string{"1", "30"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ran, " "); got != "parse validate transform sink" {
+		t.Errorf("ran %s", got)
+	}
+
+	// A row failing validation is neither transformed nor written
+	ran = nil
+	err := p.HandleRow(context.Background(), &Row{Header: header, Fields: []string{"2", "x"}})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || !strings.HasPrefix(err.Error(), "validate: ") {
+		t.Errorf("got error %v, want a validation error of the validate stage", err)
+	}
+	if got := strings.Join(ran, " "); got != "parse validate" {
+		t.Errorf("ran %s", got)
+	}
+}
@@ -0,0 +1,234 @@
+package csvproc
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveConfig bounds the worker pool when it resizes itself at runtime.
+type AdaptiveConfig struct {
+	MinWorkers int
+	MaxWorkers int
+	Interval   time.Duration // how often throughput is sampled
+}
+
+// ResizeEvent records a decision of the adaptive pool.
+type ResizeEvent struct {
+	At         time.Time     `json:"at"`
+	From       int           `json:"from"`
+	To         int           `json:"to"`
+	Reason     string        `json:"reason"`
+	RowsPerSec float64       `json:"rows_per_sec"`
+	QueueDepth int           `json:"queue_depth"`
+	RowLatency time.Duration `json:"row_latency_ns"`
+}
+
+// Tuning of the AIMD controller.
+const (
+	// Throughput has to improve by this fraction for the pool to keep growing
+	growThreshold = 0.05
+	// A drop in throughput or a rise in latency beyond these fractions
+	// shrinks the pool by decreaseFactor
+	dropThreshold    = 0.15
+	latencyThreshold = 0.5
+	decreaseFactor   = 0.5
+)
+
+// poolStats is updated by the workers and sampled by the controller.
+type poolStats struct {
+	rows      atomic.Int64
+	rowNanos  atomic.Int64
+	live      atomic.Int64
+	peak      atomic.Int64
+	producing atomic.Bool
+}
+
+func (s *poolStats) observeRow(d time.Duration) {
+	s.rows.Add(1)
+	s.rowNanos.Add(int64(d))
+}
+
+// workerPool runs the workers of one ProcessFiles call and lets the
+// controller add or retire workers while jobs are flowing.
+type workerPool struct {
+	cp      *ConcurrentProcessor
+	jobs    <-chan FileJob
+	results chan<- jobResult
+	wg      *sync.WaitGroup
+	stop    chan struct{}
+
+	mu    sync.Mutex
+	slots []bool // slots taken by running workers
+}
+
+func newWorkerPool(cp *ConcurrentProcessor, jobs <-chan FileJob, results chan<- jobResult, wg *sync.WaitGroup) *workerPool {
+	return &workerPool{
+		cp:      cp,
+		jobs:    jobs,
+		results: results,
+		wg:      wg,
+		stop:    make(chan struct{}, cp.maxWorkers()),
+	}
+}
+
+// maxWorkers is the largest size the pool can reach.
+func (cp *ConcurrentProcessor) maxWorkers() int {
+	if cp.adaptive != nil && cp.adaptive.MaxWorkers > cp.workerCount {
+		return cp.adaptive.MaxWorkers
+	}
+	return cp.workerCount
+}
+
+func (p *workerPool) grow(n int) {
+	for i := 0; i < n; i++ {
+		p.wg.Add(1)
+		live := p.cp.stats.live.Add(1)
+		if live > p.cp.stats.peak.Load() {
+			p.cp.stats.peak.Store(live)
+		}
+		p.cp.metrics.addWorkers(1)
+		slot := p.takeSlot()
+		go func() {
+			defer p.releaseSlot(slot)
+			p.cp.worker(slot, p.jobs, p.results, p.stop, p.wg)
+		}()
+	}
+}
+
+// takeSlot returns the lowest slot not taken by a running worker. Workers
+// are labelled by slot in the metrics, so retired workers' labels are
+// reused instead of growing with every resize.
+func (p *workerPool) takeSlot() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, taken := range p.slots {
+		if !taken {
+			p.slots[i] = true
+			return i
+		}
+	}
+	p.slots = append(p.slots, true)
+	return len(p.slots) - 1
+}
+
+func (p *workerPool) releaseSlot(slot int) {
+	p.mu.Lock()
+	p.slots[slot] = false
+	p.mu.Unlock()
+}
+
+// shrink asks n workers to exit once they finish their current job.
+func (p *workerPool) shrink(n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case p.stop <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+// autoscale samples throughput every interval and resizes the pool as
+// decided by an aimd controller. It returns once all jobs have been handed
+// out or processing is cancelled.
+func (cp *ConcurrentProcessor) autoscale(pool *workerPool) {
+	cfg := cp.adaptive
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	ctl := &aimd{cfg: *cfg, target: cp.workerCount}
+	lastRows, lastNanos := int64(0), int64(0)
+
+	for {
+		select {
+		case <-cp.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		queued := len(pool.jobs)
+		if !cp.stats.producing.Load() && queued == 0 {
+			return
+		}
+
+		rows, nanos := cp.stats.rows.Load(), cp.stats.rowNanos.Load()
+		rate := float64(rows-lastRows) / cfg.Interval.Seconds()
+		latency := time.Duration(0)
+		if rows > lastRows {
+			latency = time.Duration((nanos - lastNanos) / (rows - lastRows))
+		}
+		lastRows, lastNanos = rows, nanos
+
+		target := ctl.target
+		next, reason := ctl.decide(rate, latency, queued)
+		if next == target {
+			continue
+		}
+
+		event := ResizeEvent{
+			At:         time.Now(),
+			From:       target,
+			To:         next,
+			Reason:     reason,
+			RowsPerSec: rate,
+			QueueDepth: queued,
+			RowLatency: latency,
+		}
+		cp.resizesMu.Lock()
+		cp.resizes = append(cp.resizes, event)
+		cp.resizesMu.Unlock()
+		fmt.Fprintf(cp.log, "[pool] %d -> %d workers: %s\n", event.From, event.To, event.Reason)
+
+		if next > target {
+			pool.grow(next - target)
+		} else {
+			pool.shrink(target - next)
+		}
+	}
+}
+
+// aimd sizes the pool using additive increase / multiplicative decrease:
+// one worker is added while throughput keeps improving and jobs are queued,
+// and the pool is cut by decreaseFactor when throughput drops or row
+// latency climbs, never beyond the bounds of cfg.
+type aimd struct {
+	cfg         AdaptiveConfig
+	target      int
+	lastRate    float64
+	baseLatency time.Duration // lowest latency seen since the last cut
+}
+
+// decide takes the throughput and mean row latency of the last interval and
+// the number of queued jobs, and returns the new pool size and the reason
+// for a change.
+func (a *aimd) decide(rate float64, latency time.Duration, queued int) (int, string) {
+	if a.baseLatency == 0 || (latency > 0 && latency < a.baseLatency) {
+		a.baseLatency = latency
+	}
+
+	next, reason := a.target, ""
+	switch {
+	case a.lastRate > 0 && rate < a.lastRate*(1-dropThreshold) && a.target > a.cfg.MinWorkers:
+		next = max(a.cfg.MinWorkers, int(float64(a.target)*decreaseFactor))
+		reason = fmt.Sprintf("throughput dropped %.0f%%", (1-rate/a.lastRate)*100)
+	case a.baseLatency > 0 && latency > time.Duration(float64(a.baseLatency)*(1+latencyThreshold)) && a.target > a.cfg.MinWorkers:
+		next = max(a.cfg.MinWorkers, int(float64(a.target)*decreaseFactor))
+		reason = fmt.Sprintf("row latency rose to %v from %v", latency, a.baseLatency)
+		a.baseLatency = latency
+	case queued > 0 && a.target < a.cfg.MaxWorkers && (a.lastRate == 0 || rate >= a.lastRate*(1+growThreshold)):
+		next = a.target + 1
+		reason = fmt.Sprintf("%d jobs queued, throughput %.0f rows/s", queued, rate)
+	}
+	a.lastRate = rate
+	a.target = next
+	return next, reason
+}
+
+// Resizes returns the decisions taken by the adaptive pool so far.
+func (cp *ConcurrentProcessor) Resizes() []ResizeEvent {
+	cp.resizesMu.Lock()
+	defer cp.resizesMu.Unlock()
+	return append([]ResizeEvent(nil), cp.resizes...)
+}
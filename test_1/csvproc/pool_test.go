@@ -0,0 +1,117 @@
+package csvproc
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestAIMDDecide(t *testing.T) {
+	type sample struct {
+		rate    float64
+		latency time.Duration
+		queued  int
+		want    int // pool size after the sample
+	}
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		min     int
+		max     int
+		start   int
+		samples []sample
+	}{
+		{"grows while improving", 1, 8, 2, []sample{
+			{100, ms, 5, 3}, // nothing to compare with yet
+			{110, ms, 5, 4},
+			{120, ms, 5, 5},
+		}},
+		{"stops growing on a small gain", 1, 8, 2, []sample{
+			{100, ms, 5, 3},
+			{104, ms, 5, 3},
+			{104, ms, 5, 3},
+			{110, ms, 5, 4},
+		}},
+		{"stops growing when idle", 1, 8, 2, []sample{
+			{100, ms, 0, 2},
+			{200, ms, 0, 2},
+			{300, ms, 1, 3},
+		}},
+		{"stops growing at the maximum", 1, 3, 2, []sample{
+			{100, ms, 5, 3},
+			{200, ms, 5, 3},
+			{400, ms, 5, 3},
+		}},
+		{"halves on a throughput drop", 1, 16, 8, []sample{
+			{100, ms, 5, 9},
+			{86, ms, 5, 9}, // 14% is within noise
+			{70, ms, 5, 4},
+			{50, ms, 5, 2},
+		}},
+		{"halves on a latency rise", 1, 16, 8, []sample{
+			{100, ms, 0, 8},
+			{100, 3 * ms / 2, 0, 8}, // 50% is within noise
+			{100, 2 * ms, 0, 4},
+			// The raised latency is the new baseline
+			{100, 2 * ms, 0, 4},
+			{100, 4 * ms, 0, 2},
+		}},
+		{"latency ignored at the minimum", 2, 8, 2, []sample{
+			{100, ms, 0, 2},
+			{100, 10 * ms, 0, 2},
+		}},
+		{"clamped to the minimum", 3, 8, 4, []sample{
+			{100, ms, 0, 4},
+			{10, ms, 0, 3},
+			{1, ms, 0, 3},
+			{1, 10 * ms, 0, 3},
+		}},
+		{"empty interval keeps the latency baseline", 1, 8, 4, []sample{
+			{100, 2 * ms, 0, 4},
+			{100, 0, 0, 4},
+			{100, 5 * ms, 0, 2},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &aimd{cfg: AdaptiveConfig{MinWorkers: tt.min, MaxWorkers: tt.max}, target: tt.start}
+			for i, s := range tt.samples {
+				prev := a.target
+				got, reason := a.decide(s.rate, s.latency, s.queued)
+				if got != s.want {
+					t.Fatalf("sample %d %+v: got %d workers, want %d", i, s, got, s.want)
+				}
+				if (got != prev) != (reason != "") {
+					t.Errorf("sample %d: resized %d -> %d with reason %q", i, prev, got, reason)
+				}
+			}
+		})
+	}
+}
+
+func TestWithAdaptivePoolClamps(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		cfg     AdaptiveConfig
+		want    AdaptiveConfig
+		start   int
+	}{
+		{"defaults", 4, AdaptiveConfig{}, AdaptiveConfig{MinWorkers: 1, MaxWorkers: 1, Interval: time.Second}, 1},
+		{"max below min", 4, AdaptiveConfig{MinWorkers: 3, MaxWorkers: 2, Interval: time.Minute}, AdaptiveConfig{MinWorkers: 3, MaxWorkers: 3, Interval: time.Minute}, 3},
+		{"workers below min", 1, AdaptiveConfig{MinWorkers: 2, MaxWorkers: 8}, AdaptiveConfig{MinWorkers: 2, MaxWorkers: 8, Interval: time.Second}, 2},
+		{"workers above max", 16, AdaptiveConfig{MinWorkers: 2, MaxWorkers: 8}, AdaptiveConfig{MinWorkers: 2, MaxWorkers: 8, Interval: time.Second}, 8},
+		{"workers within bounds", 4, AdaptiveConfig{MinWorkers: -1, MaxWorkers: 8, Interval: -time.Second}, AdaptiveConfig{MinWorkers: 1, MaxWorkers: 8, Interval: time.Second}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := New(WithWorkers(tt.workers), WithAdaptivePool(tt.cfg), WithLogOutput(io.Discard))
+			if *cp.adaptive != tt.want {
+				t.Errorf("got config %+v, want %+v", *cp.adaptive, tt.want)
+			}
+			if cp.workerCount != tt.start {
+				t.Errorf("starts with %d workers, want %d", cp.workerCount, tt.start)
+			}
+		})
+	}
+}
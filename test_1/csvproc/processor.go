@@ -0,0 +1,1261 @@
+// Package csvproc processes CSV files concurrently: files are split into
+// jobs, parsed by a pool of workers and every row is run through a
+// RowHandler such as a Pipeline.
+package csvproc
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+type ProcessResult struct {
+	FileName    string
+	RowCount    int
+	ValidRows   int
+	InvalidRows int
+	Duplicates  int   // rows dropped because their key was already seen
+	Conflicts   int   // duplicates whose values differ from the first row
+	ResumedRows int   // rows already committed by an earlier run
+	Inserted    int   // users inserted by the user loader
+	Updated     int   // users whose username the loader changed
+	Skipped     int   // valid rows the loader left alone
+	Filtered    int   // rows dropped by the filter
+	Passed      int   // rows that matched the filter
+	Bytes       int64 // CSV bytes read, after decompression
+	Attempts    int
+	ProcessTime time.Duration
+	Error       error // a *ProcessError carrying the error class
+
+	partial     *aggPartial  // rows aggregated by the attempt that produced the result
+	commit      func() error // checkpoint commit deferred until an ordered job is released
+	uncommitted int          // rows read by the attempt after its last checkpoint commit
+}
+
+type FileJob struct {
+	FilePath string
+	FileNum  int
+	Entry    string // file inside a zip archive, empty for plain files
+
+	// Set on chunks of a large file split by SplitJob
+	Chunk     int
+	Chunks    int
+	Offset    int64
+	Length    int64
+	FirstLine int // line number of the first record in the chunk
+	FirstRow  int // data rows before the chunk
+	Header    []string
+
+	dialect *Dialect // sniffed once for the input, shared by its chunks and retries
+	seq     int      // position in the queue, used by the ordered mode
+}
+
+// Name is the display name used in results.
+func (j FileJob) Name() string {
+	if j.Entry != "" {
+		return filepath.Base(j.FilePath) + ":" + j.Entry
+	}
+	return filepath.Base(j.FilePath)
+}
+
+type ProgressTracker struct {
+	mu        sync.Mutex
+	out       io.Writer
+	total     int
+	completed int
+	failed    int
+}
+
+func (pt *ProgressTracker) Update(fileName string, success bool) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	pt.completed++
+	if !success {
+		pt.failed++
+	}
+
+	status := "✓"
+	if !success {
+		status = "✗"
+	}
+
+	fmt.Fprintf(pt.out, "[%d/%d] %s %s\n", pt.completed, pt.total, status, fileName)
+}
+
+// jobResult is the outcome of a single job, which may be one chunk of a file.
+type jobResult struct {
+	job        FileJob
+	result     ProcessResult
+	start, end time.Time
+}
+
+// pendingFile merges the chunk results of a file until all have arrived.
+type pendingFile struct {
+	result     ProcessResult
+	remaining  int
+	errChunk   int
+	start, end time.Time
+}
+
+// runTotals are kept for every result, also when results are not retained.
+type runTotals struct {
+	ReportTotals
+	okRows int
+	okTime time.Duration
+}
+
+func (t *runTotals) add(r ProcessResult) {
+	t.ReportTotals.add(r)
+	if r.Error == nil {
+		t.okRows += r.RowCount
+		t.okTime += r.ProcessTime
+	}
+}
+
+// checkpointEvery is how many rows a worker processes between checkpoint
+// commits.
+const checkpointEvery = 100
+
+// defaultSaveInterval is how often the checkpoint file is written.
+const defaultSaveInterval = 5 * time.Second
+
+type ConcurrentProcessor struct {
+	workerCount int
+	chunkSize   int64
+	results     []ProcessResult
+	resultsMu   sync.Mutex
+	totals      runTotals
+	onResult    func(ProcessResult)
+	limits      Limits
+	ordered     bool
+	rate        *throttle
+	fileRate    *fileThrottles
+	lookAhead   int
+	order       *reorderBuffer
+	tracker     *ProgressTracker
+	handler     RowHandler
+	schema      *Schema
+	rejects     *RejectWriter
+	checkpoint  *Checkpoint
+	resume      bool
+	saveEvery   time.Duration
+	sinkFlush   func() error
+	retry       RetryConfig
+	adaptive    *AdaptiveConfig
+	aggregator  *Aggregator
+	loader      *UserLoader
+	filter      *Filter
+	metrics     *Metrics
+	csv         CSVOptions
+	extensions  []string
+	log         io.Writer
+	stats       poolStats
+	resizes     []ResizeEvent
+	resizesMu   sync.Mutex
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+func NewProcessor(workerCount int) *ConcurrentProcessor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ConcurrentProcessor{
+		workerCount: workerCount,
+		results:     make([]ProcessResult, 0),
+		saveEvery:   defaultSaveInterval,
+		log:         os.Stdout,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+func (cp *ConcurrentProcessor) ProcessFiles(filePaths []string) []ProcessResult {
+	fileJobs := ExpandJobs(filePaths, cp.extensions)
+	cp.tracker = &ProgressTracker{out: cp.log, total: len(fileJobs)}
+
+	if cp.checkpoint != nil {
+		// The checkpoint is saved periodically and once more after the
+		// workers have committed their final progress
+		saveCtx, stopSaving := context.WithCancel(context.Background())
+		saved := make(chan struct{})
+		go func() {
+			defer close(saved)
+			cp.checkpoint.Run(saveCtx, cp.saveEvery, cp.log)
+		}()
+		defer func() {
+			stopSaving()
+			<-saved
+		}()
+	}
+
+	var sizes map[int]int64
+	if cp.metrics != nil {
+		entries := make(map[string]int)
+		for _, job := range fileJobs {
+			entries[job.FilePath]++
+		}
+		sizes = make(map[int]int64, len(fileJobs))
+		for _, job := range fileJobs {
+			sizes[job.FileNum] = jobSize(job, entries[job.FilePath])
+		}
+		cp.metrics.addFiles(sizes)
+	}
+
+	// A slot is taken before a job is queued and released once its result
+	// has been collected, so at most inFlight jobs are held in memory
+	//
+	// In ordered mode slots are released in input order instead, which
+	// bounds how far workers may run ahead of the oldest unfinished job
+	inFlight := cp.limits.inFlight(cp.maxWorkers())
+	lookAhead := inFlight
+	cp.order = nil
+	if cp.ordered {
+		cp.order = newReorderBuffer()
+		if cp.lookAhead > 0 {
+			lookAhead = cp.lookAhead
+		}
+	}
+	slots := make(chan struct{}, lookAhead)
+	jobs := make(chan FileJob, inFlight)
+	results := make(chan jobResult, inFlight)
+
+	var wg sync.WaitGroup
+	pool := newWorkerPool(cp, jobs, results, &wg)
+	pool.grow(cp.workerCount)
+
+	cp.stats.producing.Store(true)
+	if cp.adaptive != nil {
+		// The controller holds a slot in wg so results stay open while it
+		// may still add workers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cp.autoscale(pool)
+		}()
+	}
+
+	// Send jobs to workers
+	go func() {
+		defer cp.stats.producing.Store(false)
+		defer close(jobs)
+		seq := 0
+		for _, job := range fileJobs {
+			chunks, err := cp.splitJob(job)
+			if err != nil {
+				// Let the worker report the failure for this file
+				chunks = []FileJob{job}
+			}
+			for _, chunk := range chunks {
+				chunk.seq = seq
+				seq++
+				select {
+				case slots <- struct{}{}:
+				case <-cp.ctx.Done():
+					return
+				}
+				select {
+				case jobs <- chunk:
+					cp.metrics.addQueued(1)
+				case <-cp.ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		// Jobs still queued when the workers stopped on cancellation are
+		// abandoned
+		for range jobs {
+			cp.metrics.addQueued(-1)
+		}
+		close(results)
+	}()
+
+	pending := make(map[int]*pendingFile)
+	for jr := range results {
+		select {
+		case <-cp.ctx.Done():
+			// If context was cancelled, stop processing results. Draining
+			// lets the workers exit and commit their progress first.
+			for range results {
+			}
+			return cp.results
+		default:
+			ready := []jobResult{jr}
+			if cp.order != nil {
+				ready = cp.order.push(jr)
+			}
+			for _, jr := range ready {
+				<-slots
+				cp.collect(pending, jr, sizes[jr.job.FileNum])
+			}
+		}
+	}
+
+	return cp.results
+}
+
+// collect records a job result, once all chunks of its file are in.
+func (cp *ConcurrentProcessor) collect(pending map[int]*pendingFile, jr jobResult, size int64) {
+	if jr.result.commit != nil {
+		// The rows the ordered gate held back have been written by now
+		if err := jr.result.commit(); err != nil && jr.result.Error == nil {
+			jr.result.Error = Classify(fmt.Errorf("checkpoint: %w", err))
+		}
+		jr.result.commit = nil
+	}
+
+	result, done := mergeChunk(pending, jr)
+	if !done {
+		return
+	}
+	cp.fileRate.done(jr.job.FileNum)
+
+	cp.resultsMu.Lock()
+	cp.totals.add(result)
+	if cp.onResult == nil {
+		cp.results = append(cp.results, result)
+	}
+	cp.resultsMu.Unlock()
+
+	cp.tracker.Update(result.FileName, result.Error == nil)
+	cp.metrics.fileDone(result, size)
+	if cp.onResult != nil {
+		cp.onResult(result)
+	}
+}
+
+// splitJob splits job into chunks when its dialect allows it. The chunks
+// carry the dialect sniffed for the whole input.
+func (cp *ConcurrentProcessor) splitJob(job FileJob) ([]FileJob, error) {
+	if cp.chunkSize <= 0 || job.Entry != "" || cp.csv.NoHeader {
+		return []FileJob{job}, nil
+	}
+	dialect, err := cp.csv.Dialect(job)
+	if err != nil {
+		return nil, err
+	}
+	job.dialect = &dialect
+	if !dialect.Splittable() {
+		return []FileJob{job}, nil
+	}
+	return SplitJob(job, cp.chunkSize, dialect)
+}
+
+// dialect returns the dialect of job, sniffing it unless that was done.
+func (cp *ConcurrentProcessor) dialect(job FileJob) (Dialect, error) {
+	if job.dialect != nil {
+		return *job.dialect, nil
+	}
+	return cp.csv.Dialect(job)
+}
+
+// addCounts adds the row outcomes of other, those that can still change
+// after the rows were read, to r.
+func (r *ProcessResult) addCounts(other ProcessResult) {
+	r.ValidRows += other.ValidRows
+	r.InvalidRows += other.InvalidRows
+	r.Duplicates += other.Duplicates
+	r.Conflicts += other.Conflicts
+	r.Inserted += other.Inserted
+	r.Updated += other.Updated
+	r.Skipped += other.Skipped
+}
+
+// mergeChunk folds a chunk result into the result of its file. It returns
+// the merged result once every chunk of the file has been processed.
+func mergeChunk(pending map[int]*pendingFile, jr jobResult) (ProcessResult, bool) {
+	if jr.job.Chunks <= 1 {
+		return jr.result, true
+	}
+
+	pf, ok := pending[jr.job.FileNum]
+	if !ok {
+		pf = &pendingFile{
+			result:    ProcessResult{FileName: jr.result.FileName},
+			remaining: jr.job.Chunks,
+			start:     jr.start,
+			end:       jr.end,
+		}
+		pending[jr.job.FileNum] = pf
+	}
+
+	r := &pf.result
+	r.addCounts(jr.result)
+	r.RowCount += jr.result.RowCount
+	r.ResumedRows += jr.result.ResumedRows
+	r.Filtered += jr.result.Filtered
+	r.Passed += jr.result.Passed
+	r.Bytes += jr.result.Bytes
+	r.Attempts = max(r.Attempts, jr.result.Attempts)
+	if jr.result.Error != nil && (r.Error == nil || jr.job.Chunk < pf.errChunk) {
+		r.Error = fmt.Errorf("chunk %d/%d: %w", jr.job.Chunk+1, jr.job.Chunks, jr.result.Error)
+		pf.errChunk = jr.job.Chunk
+	}
+	if jr.start.Before(pf.start) {
+		pf.start = jr.start
+	}
+	if jr.end.After(pf.end) {
+		pf.end = jr.end
+	}
+
+	pf.remaining--
+	if pf.remaining > 0 {
+		return ProcessResult{}, false
+	}
+	delete(pending, jr.job.FileNum)
+	r.ProcessTime = pf.end.Sub(pf.start)
+	return *r, true
+}
+
+func (cp *ConcurrentProcessor) worker(slot int, jobs <-chan FileJob, results chan<- jobResult, stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer cp.stats.live.Add(-1)
+	defer cp.metrics.addWorkers(-1)
+
+	// Aggregate into a worker local partial and merge it once on exit
+	partial := cp.aggregator.newPartial()
+	defer cp.aggregator.mergePartial(partial)
+
+	for {
+		var job FileJob
+		select {
+		case <-stop:
+			// Retired by the adaptive pool
+			return
+		case j, ok := <-jobs:
+			if !ok {
+				return
+			}
+			job = j
+			cp.metrics.addQueued(-1)
+		}
+
+		select {
+		case <-cp.ctx.Done():
+			// Context cancelled, exit worker
+			return
+		default:
+			// Process the job normally
+			start := time.Now()
+			result := cp.processWithRetry(job)
+			cp.metrics.observeBusy(slot, time.Since(start))
+			if result.Error == nil {
+				partial.merge(result.partial)
+			}
+			result.partial = nil
+
+			// Send result to channel
+			select {
+			case results <- jobResult{job: job, result: result, start: start, end: time.Now()}:
+			case <-cp.ctx.Done():
+				// Context cancelled, exit worker
+				return
+			}
+		}
+	}
+}
+
+// processWithRetry runs a job, retrying transient failures with exponential
+// backoff until the attempt budget is spent. Rows handed on by an attempt
+// cannot be taken back, so a failure is only retried if every row the
+// attempt read was committed to the checkpoint; the retry continues after
+// them. Without a checkpoint that means failures before the first row.
+func (cp *ConcurrentProcessor) processWithRetry(job FileJob) ProcessResult {
+	maxAttempts := max(cp.retry.MaxAttempts, 1)
+	// Retries read the same input, it is sniffed once for all attempts. A
+	// failure is left to processFile to report.
+	if dialect, err := cp.dialect(job); err == nil {
+		job.dialect = &dialect
+	}
+	var committed *aggPartial
+	for attempt := 1; ; attempt++ {
+		result := cp.processFile(job, attempt)
+		result.Attempts = attempt
+		// The committed rows of failed attempts are skipped by the retry,
+		// their aggregates are carried over
+		result.partial.merge(committed)
+		if result.Error == nil || !Retryable(result.Error) || result.uncommitted > 0 || attempt >= maxAttempts {
+			return result
+		}
+		committed = result.partial
+
+		delay := cp.retry.Delay(attempt + 1)
+		fmt.Fprintf(cp.log, "retry %s in %v (attempt %d/%d): %v\n", job.Name(), delay.Round(time.Millisecond), attempt+1, maxAttempts, result.Error)
+		select {
+		case <-time.After(delay):
+		case <-cp.ctx.Done():
+			result.Error = Classify(fmt.Errorf("processing cancelled: %w", cp.ctx.Err()))
+			return result
+		}
+	}
+}
+
+func (cp *ConcurrentProcessor) processFile(job FileJob, attempt int) (result ProcessResult) {
+	start := time.Now()
+	result = ProcessResult{FileName: job.Name(), partial: cp.aggregator.newPartial()}
+	checkpointJob := job
+	defer func() {
+		result.Error = Classify(result.Error)
+	}()
+
+	dialect, err := cp.dialect(job)
+	if err != nil {
+		result.Error = fmt.Errorf("open failed: %w", err)
+		return result
+	}
+
+	var resume *CheckpointEntry
+	if cp.checkpoint != nil && (cp.resume || attempt > 1) {
+		entry, err := cp.checkpoint.Lookup(job)
+		if err != nil {
+			result.Error = fmt.Errorf("checkpoint: %w", err)
+			return result
+		}
+		resume = entry
+	}
+
+	// Rows reach the Ordered stage with the gate of this job
+	rowCtx := cp.ctx
+	var gate *orderGate
+	if cp.order != nil {
+		gate = cp.order.gate(job.seq)
+		rowCtx = context.WithValue(cp.ctx, orderKey{}, gate)
+	}
+
+	fileRate := cp.fileRate.get(job.FileNum)
+	load := cp.loader.newBatch()
+	if load != nil {
+		defer func() {
+			result.Inserted, result.Updated, result.Skipped = load.counts.Inserted, load.counts.Updated, load.counts.Skipped
+		}()
+	}
+
+	rowCount, skipRows, committed := 0, 0, 0
+	defer func() {
+		result.uncommitted = rowCount - committed
+	}()
+	if resume != nil {
+		rowCount, committed = resume.Rows, resume.Rows
+		result.ValidRows, result.InvalidRows = resume.ValidRows, resume.InvalidRows
+		result.ResumedRows = resume.Rows
+
+		if resume.Status == StatusDone {
+			result.RowCount = rowCount
+			result.ProcessTime = time.Since(start)
+			return result
+		}
+
+		// Plain files and chunks are resumed by seeking past the committed
+		// rows, compressed inputs by reading and skipping them
+		if resumed, ok := seekJob(job, resume); !ok || !dialect.Seekable() {
+			skipRows = resume.Rows
+		} else if resumed.Length == 0 {
+			// Nothing left after the committed rows
+			result.RowCount = rowCount
+			result.ProcessTime = time.Since(start)
+			return result
+		} else {
+			job = resumed
+		}
+	}
+
+	input, err := openInput(job)
+	if err != nil {
+		result.Error = fmt.Errorf("open failed: %w", err)
+		return result
+	}
+	defer input.Close()
+
+	src := newRecordSource(cp.limits.newReader(dialect.decode(input), dialect.Delimiter), cp.limits.RowBuffer)
+	defer src.Close()
+	defer func() {
+		result.Bytes = src.Offset()
+	}()
+
+	// Chunks start past the header, their line and row numbers are
+	// shifted so that they stay global to the file
+	headerFields, lineBase, rowBase := job.Header, 0, checkpointJob.FirstRow
+	switch {
+	case job.Header != nil:
+		lineBase = job.FirstLine - 1
+	case cp.csv.NoHeader:
+		headerFields = cp.csv.Columns
+		if len(headerFields) == 0 {
+			headerFields = cp.schema.ColumnNames()
+		}
+	default:
+		rec := src.Next()
+		if rec.err == io.EOF {
+			result.ProcessTime = time.Since(start)
+			return result
+		}
+		if rec.err != nil {
+			result.Error = fmt.Errorf("read error at header: %w", rec.err)
+			return result
+		}
+		headerFields = dialect.fields(rec.fields)
+	}
+	header := NewHeader(cp.csv.Mapping.Apply(headerFields, cp.schema.ColumnNames()))
+	if cp.schema != nil {
+		if err := cp.schema.CheckHeader(header); err != nil {
+			result.Error = fmt.Errorf("header: %w", err)
+			return result
+		}
+	}
+	if cp.filter != nil {
+		if err := cp.filter.CheckHeader(header); err != nil {
+			result.Error = fmt.Errorf("header: %w", err)
+			return result
+		}
+	}
+
+	var baseOffset int64
+	if job.Length > 0 {
+		baseOffset = job.Offset
+	} else if dialect.BOM {
+		baseOffset = int64(len(bomUTF8))
+	}
+	next := lineBase + 1
+	if job.Header == nil && !cp.csv.NoHeader {
+		next = nextLine(1, headerFields)
+	}
+
+	progress := func(status string) CheckpointEntry {
+		return CheckpointEntry{
+			Status:      status,
+			Header:      headerFields,
+			Rows:        rowCount,
+			ValidRows:   result.ValidRows,
+			InvalidRows: result.InvalidRows,
+			Offset:      baseOffset + src.Offset(),
+			NextLine:    next,
+		}
+	}
+
+	for skipped := 0; skipped < skipRows; skipped++ {
+		rec := src.Next()
+		if rec.err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if rec.err != nil && !errors.As(rec.err, &parseErr) {
+			result.Error = fmt.Errorf("read error at row %d: %w", rowBase+skipped+1, rec.err)
+			return result
+		}
+		next = nextLine(lineBase+rec.line, rec.fields)
+	}
+
+	// mark is the progress before the current row, last the progress
+	// committed last. A failed job commits mark, so that the row that
+	// failed is processed again on resume.
+	mark := progress(StatusRunning)
+	last := mark
+	if cp.checkpoint != nil {
+		defer func() {
+			entry := progress(StatusDone)
+			if result.Error != nil {
+				status := StatusFailed
+				if errors.Is(result.Error, context.Canceled) {
+					status = StatusRunning
+				}
+				// Loaded users must be written before their rows are
+				// committed, the context may already be cancelled
+				entry = mark
+				if load.flush(context.WithoutCancel(rowCtx)) != nil {
+					entry = last
+				}
+				entry.Status = status
+			}
+			if !gate.emitted() {
+				// The ordered gate still holds rows of this job, they are
+				// written and committed when the job is released
+				result.commit = func() error {
+					return cp.commit(checkpointJob, entry)
+				}
+				return
+			}
+			if err := cp.commit(checkpointJob, entry); err != nil && result.Error == nil {
+				result.Error = fmt.Errorf("checkpoint: %w", err)
+			}
+		}()
+	}
+
+	for {
+		mark = progress(StatusRunning)
+		// Committing hashes the whole input, which a job should not wait for
+		// before it processed any rows
+		if cp.checkpoint != nil && rowCount > committed && rowCount%checkpointEvery == 0 && gate.emitted() {
+			// Loaded users must be written before their rows are committed
+			if err := load.flush(rowCtx); err != nil {
+				result.Error = fmt.Errorf("load users: %w", err)
+				return result
+			}
+			if err := cp.commit(checkpointJob, mark); err != nil {
+				result.Error = fmt.Errorf("checkpoint: %w", err)
+				return result
+			}
+			last, committed = mark, rowCount
+		}
+
+		// Check for context cancellation periodically
+		select {
+		case <-cp.ctx.Done():
+			result.Error = fmt.Errorf("processing cancelled: %w", cp.ctx.Err())
+			return result
+		default:
+			// Continue processing
+		}
+
+		prev := src.Offset()
+		rec := src.Next()
+		record, err := dialect.fields(rec.fields), rec.err
+		if err == io.EOF {
+			break
+		}
+		cp.metrics.observeRow(rec.offset - prev)
+		if err := cp.throttle(fileRate, rec.offset-prev); err != nil {
+			result.Error = fmt.Errorf("processing cancelled: %w", err)
+			return result
+		}
+
+		// Malformed records are rejected, the rest of the file keeps flowing
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rowCount++
+			next = lineBase + parseErr.Line + 1
+			if err := cp.reject(&result, lineBase+parseErr.StartLine, header, record, NewValidationError("", parseErr.Err.Error())); err != nil {
+				result.Error = fmt.Errorf("write reject: %w", err)
+				return result
+			}
+			continue
+		}
+		if err != nil {
+			result.Error = fmt.Errorf("read error at row %d: %w", rowBase+rowCount+1, err)
+			return result
+		}
+
+		if len(record) == 0 {
+			result.Error = fmt.Errorf("empty record at row %d", rowBase+rowCount+1)
+			return result
+		}
+
+		line := lineBase + rec.line
+		next = nextLine(line, record)
+		row := &Row{File: result.FileName, FileNum: job.FileNum, Line: line, Header: header, Fields: record}
+		rowCount++
+
+		if cp.filter != nil {
+			if !cp.filter.Match(row) {
+				result.Filtered++
+				continue
+			}
+			result.Passed++
+		}
+
+		var (
+			verr *ValidationError
+			dup  *DuplicateError
+		)
+		rowStart := time.Now()
+		err = cp.handleRow(rowCtx, row)
+		cp.stats.observeRow(time.Since(rowStart))
+		if errors.Is(err, ErrHoldRow) {
+			// Aggregated and loaded by FlushHeld once it is emitted
+			result.ValidRows++
+			continue
+		} else if errors.As(err, &verr) {
+			if err := cp.reject(&result, line, header, record, verr); err != nil {
+				result.Error = fmt.Errorf("write reject: %w", err)
+				return result
+			}
+			continue
+		} else if errors.As(err, &dup) && !dup.Fatal {
+			result.Duplicates++
+			if dup.Conflict {
+				result.Conflicts++
+			}
+			continue
+		} else if err != nil {
+			result.Error = fmt.Errorf("row %d (line %d): %w", rowBase+rowCount, line, err)
+			return result
+		}
+
+		if err := load.add(rowCtx, row); errors.As(err, &verr) {
+			if err := cp.reject(&result, line, header, record, verr); err != nil {
+				result.Error = fmt.Errorf("write reject: %w", err)
+				return result
+			}
+			continue
+		} else if err != nil {
+			result.Error = fmt.Errorf("load users: %w", err)
+			return result
+		}
+		result.ValidRows++
+		result.partial.add(row)
+	}
+
+	if err := load.flush(rowCtx); err != nil {
+		result.Error = fmt.Errorf("load users: %w", err)
+		return result
+	}
+	result.RowCount = rowCount
+	result.ProcessTime = time.Since(start)
+	return result
+}
+
+// seekJob returns a copy of job that starts right after the rows committed in
+// entry. Only uncompressed inputs can be resumed this way.
+func seekJob(job FileJob, entry *CheckpointEntry) (FileJob, bool) {
+	if job.Entry != "" || entry.Header == nil || entry.Offset <= 0 {
+		return job, false
+	}
+
+	end := job.Offset + job.Length
+	if job.Length == 0 {
+		file, err := os.Open(job.FilePath)
+		if err != nil {
+			return job, false
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil || isCompressed(file) {
+			return job, false
+		}
+		end = info.Size()
+	}
+	if entry.Offset > end {
+		return job, false
+	}
+
+	job.Offset, job.Length = entry.Offset, end-entry.Offset
+	job.Header, job.FirstLine = entry.Header, entry.NextLine
+	return job, true
+}
+
+// commit records entry in the checkpoint once the rows it covers have been
+// written by the sink and the rejects file.
+func (cp *ConcurrentProcessor) commit(job FileJob, entry CheckpointEntry) error {
+	if cp.sinkFlush != nil {
+		if err := cp.sinkFlush(); err != nil {
+			return fmt.Errorf("flush sink: %w", err)
+		}
+	}
+	if cp.rejects != nil {
+		if err := cp.rejects.Flush(); err != nil {
+			return fmt.Errorf("flush rejects: %w", err)
+		}
+	}
+	entry.ChunkSize = max(cp.chunkSize, 0)
+	return cp.checkpoint.Commit(job, entry)
+}
+
+func (cp *ConcurrentProcessor) handleRow(ctx context.Context, row *Row) error {
+	if cp.handler == nil {
+		return nil
+	}
+	return cp.handler.HandleRow(ctx, row)
+}
+
+// throttle waits until the global and the per-file rate limits allow
+// another row of size bytes.
+func (cp *ConcurrentProcessor) throttle(fileRate *throttle, bytes int64) error {
+	if err := cp.rate.wait(cp.ctx, bytes); err != nil {
+		return err
+	}
+	return fileRate.wait(cp.ctx, bytes)
+}
+
+func (cp *ConcurrentProcessor) reject(result *ProcessResult, line int, header *Header, record []string, verr *ValidationError) error {
+	result.InvalidRows++
+	if cp.rejects == nil {
+		return nil
+	}
+	return cp.rejects.Reject(result.FileName, line, header, record, verr)
+}
+
+// FlushHeld emits the rows d held back, once all files are processed, in
+// input order: like the rows the workers pass on they are loaded,
+// aggregated and handed to sink. Their load counts and the duplicates d
+// dropped are added to the results of their files and to the totals.
+func (cp *ConcurrentProcessor) FlushHeld(ctx context.Context, d *Deduper, sink Stage) error {
+	late := d.droppedResults()
+	resultOf := func(file string) *ProcessResult {
+		r, ok := late[file]
+		if !ok {
+			r = &ProcessResult{FileName: file}
+			late[file] = r
+		}
+		return r
+	}
+	defer cp.addLate(late)
+
+	partial := cp.aggregator.newPartial()
+	defer cp.aggregator.mergePartial(partial)
+
+	loads := make(map[string]*userBatch)
+	err := d.Flush(ctx, func(ctx context.Context, row *Row) error {
+		load, ok := loads[row.File]
+		if !ok {
+			load = cp.loader.newBatch()
+			loads[row.File] = load
+		}
+		var verr *ValidationError
+		if err := load.add(ctx, row); errors.As(err, &verr) {
+			r := resultOf(row.File)
+			r.ValidRows--
+			if err := cp.reject(r, row.Line, row.Header, row.Fields, verr); err != nil {
+				return fmt.Errorf("write reject: %w", err)
+			}
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("load users: %w", err)
+		}
+		partial.add(row)
+		if sink == nil {
+			return nil
+		}
+		return sink(ctx, row)
+	})
+
+	for file, load := range loads {
+		if load == nil {
+			continue
+		}
+		if ferr := load.flush(ctx); ferr != nil && err == nil {
+			err = fmt.Errorf("load users: %w", ferr)
+		}
+		r := resultOf(file)
+		r.Inserted, r.Updated, r.Skipped = load.counts.Inserted, load.counts.Updated, load.counts.Skipped
+	}
+	return err
+}
+
+// addLate adds counts that became known after the files were collected to
+// their results, if retained, and to the totals.
+func (cp *ConcurrentProcessor) addLate(late map[string]*ProcessResult) {
+	cp.resultsMu.Lock()
+	defer cp.resultsMu.Unlock()
+	for i := range cp.results {
+		if r, ok := late[cp.results[i].FileName]; ok {
+			cp.results[i].addCounts(*r)
+		}
+	}
+	t := &cp.totals.ReportTotals
+	for _, r := range late {
+		t.ValidRows += r.ValidRows
+		t.InvalidRows += r.InvalidRows
+		t.Duplicates += r.Duplicates
+		t.Conflicts += r.Conflicts
+		t.Inserted += r.Inserted
+		t.Updated += r.Updated
+		t.Skipped += r.Skipped
+	}
+}
+
+// Cancel stops all processing
+func (cp *ConcurrentProcessor) Cancel() {
+	cp.cancel()
+}
+
+// Workers returns the number of workers the pool starts with.
+func (cp *ConcurrentProcessor) Workers() int {
+	return cp.workerCount
+}
+
+// WithHandler sets the RowHandler invoked for every data record
+func (cp *ConcurrentProcessor) WithHandler(handler RowHandler) *ConcurrentProcessor {
+	cp.handler = handler
+	return cp
+}
+
+// WithAdaptivePool lets the pool grow and shrink between cfg.MinWorkers and
+// cfg.MaxWorkers based on observed throughput, starting from the worker
+// count given to NewProcessor.
+func (cp *ConcurrentProcessor) WithAdaptivePool(cfg AdaptiveConfig) *ConcurrentProcessor {
+	if cfg.MinWorkers < 1 {
+		cfg.MinWorkers = 1
+	}
+	if cfg.MaxWorkers < cfg.MinWorkers {
+		cfg.MaxWorkers = cfg.MinWorkers
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	cp.workerCount = min(max(cp.workerCount, cfg.MinWorkers), cfg.MaxWorkers)
+	cp.adaptive = &cfg
+	return cp
+}
+
+// WithRetry retries jobs failing with transient errors.
+func (cp *ConcurrentProcessor) WithRetry(cfg RetryConfig) *ConcurrentProcessor {
+	cp.retry = cfg
+	return cp
+}
+
+// WithCheckpoint records progress in checkpoint. When resume is set, jobs
+// completed by an earlier run are skipped and partial ones continue from
+// their last committed row. Split files are only found again with the
+// chunk size of the earlier run, see Checkpoint.CheckChunkSize.
+func (cp *ConcurrentProcessor) WithCheckpoint(checkpoint *Checkpoint, resume bool) *ConcurrentProcessor {
+	cp.checkpoint = checkpoint
+	cp.resume = resume
+	return cp
+}
+
+// WithCheckpointInterval sets how often the checkpoint is saved while files
+// are processed. It is saved once more when ProcessFiles returns.
+func (cp *ConcurrentProcessor) WithCheckpointInterval(d time.Duration) *ConcurrentProcessor {
+	if d <= 0 {
+		d = defaultSaveInterval
+	}
+	cp.saveEvery = d
+	return cp
+}
+
+// WithSinkFlush has fn called before progress is committed to the
+// checkpoint. fn must return once every row handled so far has been written,
+// e.g. AsyncSink.Flush, so that committed rows are never lost in a buffer.
+func (cp *ConcurrentProcessor) WithSinkFlush(fn func() error) *ConcurrentProcessor {
+	cp.sinkFlush = fn
+	return cp
+}
+
+// WithChunkSize splits plain files larger than twice size into byte ranges
+// that are processed in parallel. Zero disables splitting.
+func (cp *ConcurrentProcessor) WithChunkSize(size int64) *ConcurrentProcessor {
+	cp.chunkSize = size
+	return cp
+}
+
+// WithLimits bounds the memory used while processing.
+func (cp *ConcurrentProcessor) WithLimits(limits Limits) *ConcurrentProcessor {
+	cp.limits = limits
+	return cp
+}
+
+// WithOrdered emits results, and rows written through an Ordered sink
+// stage, in input order while files and chunks are still processed in
+// parallel. Workers run at most lookAhead jobs ahead of the oldest
+// unfinished one; rows of those jobs are held in memory until it is done.
+// A lookAhead of 0 uses the in-flight limit.
+func (cp *ConcurrentProcessor) WithOrdered(lookAhead int) *ConcurrentProcessor {
+	cp.ordered = true
+	cp.lookAhead = lookAhead
+	return cp
+}
+
+// WithRateLimit caps the rows and bytes processed per second, over all
+// workers by global and for every input file by perFile.
+func (cp *ConcurrentProcessor) WithRateLimit(global, perFile RateLimit) *ConcurrentProcessor {
+	cp.rate = newThrottle(global)
+	cp.fileRate = nil
+	if perFile.enabled() {
+		cp.fileRate = &fileThrottles{limit: perFile, files: make(map[int]*throttle)}
+	}
+	return cp
+}
+
+// WithResultHandler hands every file result to fn as soon as it is complete,
+// from the goroutine that called ProcessFiles. Results are then no longer
+// retained: ProcessFiles returns none and Report and PrintSummary only
+// cover totals.
+func (cp *ConcurrentProcessor) WithResultHandler(fn func(ProcessResult)) *ConcurrentProcessor {
+	cp.onResult = fn
+	return cp
+}
+
+// WithCSVOptions sets how inputs are decoded and how their columns are
+// named.
+func (cp *ConcurrentProcessor) WithCSVOptions(opts CSVOptions) *ConcurrentProcessor {
+	cp.csv = opts
+	return cp
+}
+
+// WithMetrics reports progress to m.
+func (cp *ConcurrentProcessor) WithMetrics(m *Metrics) *ConcurrentProcessor {
+	cp.metrics = m
+	return cp
+}
+
+// WithAggregator computes the aggregates of a over all valid rows.
+func (cp *ConcurrentProcessor) WithAggregator(a *Aggregator) *ConcurrentProcessor {
+	cp.aggregator = a
+	return cp
+}
+
+// WithUserLoader upserts every valid row into the users table through l.
+// The counts end up in the Inserted, Updated and Skipped fields of the
+// results.
+func (cp *ConcurrentProcessor) WithUserLoader(l *UserLoader) *ConcurrentProcessor {
+	cp.loader = l
+	return cp
+}
+
+// WithFilter drops rows not matching f before they are validated and
+// handled. The counts end up in the Filtered and Passed fields of the
+// results.
+func (cp *ConcurrentProcessor) WithFilter(f *Filter) *ConcurrentProcessor {
+	cp.filter = f
+	return cp
+}
+
+// WithSchema checks the header of every file against schema and names the
+// columns of headerless input after it. Rows are validated by the handler,
+// e.g. a Pipeline whose Validate stage is schema.Validate. Invalid rows are
+// counted and written to the rejects file, if any.
+func (cp *ConcurrentProcessor) WithSchema(schema *Schema, rejects *RejectWriter) *ConcurrentProcessor {
+	cp.schema = schema
+	cp.rejects = rejects
+	return cp
+}
+
+// WithRejects writes invalid rows to rejects: records that cannot be
+// parsed and rows failing validation in the schema, the handler or the
+// user loader.
+func (cp *ConcurrentProcessor) WithRejects(rejects *RejectWriter) *ConcurrentProcessor {
+	cp.rejects = rejects
+	return cp
+}
+
+// WithExtensions limits the entries processed from zip archives to names
+// ending in one of exts, as DiscoverOptions does for files in directories.
+func (cp *ConcurrentProcessor) WithExtensions(exts []string) *ConcurrentProcessor {
+	cp.extensions = exts
+	return cp
+}
+
+// WithLogOutput sets where progress, retries and pool resizes are logged.
+// PrintSummary always writes to stdout.
+func (cp *ConcurrentProcessor) WithLogOutput(w io.Writer) *ConcurrentProcessor {
+	cp.log = w
+	return cp
+}
+
+// WithContext sets a custom context for the processor
+func (cp *ConcurrentProcessor) WithContext(ctx context.Context) *ConcurrentProcessor {
+	cp.cancel() // Cancel the current context
+	cp.ctx, cp.cancel = context.WithCancel(ctx)
+	return cp
+}
+
+// Report returns a machine readable summary of the results so far.
+func (cp *ConcurrentProcessor) Report(startedAt, finishedAt time.Time) *RunReport {
+	cp.resultsMu.Lock()
+	defer cp.resultsMu.Unlock()
+	report := NewRunReport(cp.results, cp.workerCount, startedAt, finishedAt)
+	report.Totals = cp.totals.ReportTotals
+	report.PeakWorkers = int(cp.stats.peak.Load())
+	report.Resizes = cp.Resizes()
+	return report
+}
+
+func (cp *ConcurrentProcessor) PrintSummary() {
+	fmt.Println("\n" + "==========================================================")
+	fmt.Println("Processing Summary")
+	fmt.Println("==========================================================")
+
+	for _, r := range cp.results {
+		if r.Error == nil {
+			fmt.Printf("✓ %s: %d rows in %v\n", r.FileName, r.RowCount, r.ProcessTime)
+			if r.Attempts > 1 {
+				fmt.Printf("    succeeded after %d attempts\n", r.Attempts)
+			}
+			if r.ResumedRows > 0 {
+				fmt.Printf("    %d rows resumed from checkpoint\n", r.ResumedRows)
+			}
+			if r.Duplicates > 0 {
+				fmt.Printf("    %d duplicates dropped, %d conflicting\n", r.Duplicates, r.Conflicts)
+			}
+			if r.InvalidRows > 0 {
+				fmt.Printf("    %d valid, %d rejected\n", r.ValidRows, r.InvalidRows)
+			}
+			if r.Filtered+r.Passed > 0 {
+				fmt.Printf("    filter: %d passed, %d filtered\n", r.Passed, r.Filtered)
+			}
+			if r.Inserted+r.Updated+r.Skipped > 0 {
+				fmt.Printf("    users: %d inserted, %d updated, %d skipped\n", r.Inserted, r.Updated, r.Skipped)
+			}
+		} else {
+			fmt.Printf("✗ %s: [%s] %v\n", r.FileName, ErrorClass(r.Error), r.Error)
+		}
+	}
+
+	fmt.Println("==========================================================")
+	cp.resultsMu.Lock()
+	t := cp.totals
+	cp.resultsMu.Unlock()
+	fmt.Printf("Files: %d | Success: %d | Failed: %d\n", t.Files, t.Succeeded, t.Failed)
+	avgTime := time.Duration(0)
+	if t.Succeeded > 0 {
+		avgTime = t.okTime / time.Duration(t.Succeeded)
+	}
+	fmt.Printf("Total Rows: %d | Avg Time: %v\n", t.okRows, avgTime)
+	fmt.Println("==========================================================")
+}
+
+func CreateSampleFiles(dir string, count int) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, count)
+
+	for i := 0; i < count; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("sample_%d.csv", i+1))
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+
+		w := csv.NewWriter(file)
+		w.Write([]string{"ID", "Name", "Email", "Age", "City"})
+
+		rows := 100 + (i * 50)
+		for j := 0; j < rows; j++ {
+			w.Write([]string{
+				fmt.Sprintf("%d", j+1),
+				fmt.Sprintf("User_%d_%d", i+1, j+1),
+				fmt.Sprintf("user%d_%d@example.com", i+1, j+1),
+				fmt.Sprintf("%d", 20+(j%50)),
+				fmt.Sprintf("City_%d", (j%10)+1),
+			})
+		}
+
+		w.Flush()
+		file.Close()
+		paths[i] = path
+	}
+
+	return paths, nil
+}
+
+func CalculateOptimalWorkers(fileCount int) int {
+	// Strategi 1: Berdasarkan CPU cores
+	cpuCount := runtime.NumCPU()
+
+	// Strategi 2: Berdasarkan jumlah file
+	// Tidak perlu worker lebih banyak dari file
+	if fileCount < cpuCount {
+		return fileCount
+	}
+
+	// Strategi 3: Max workers = 2x CPU cores (good for I/O bound tasks)
+	maxWorkers := cpuCount * 2
+
+	// Pilih yang paling efisien
+	if fileCount < maxWorkers {
+		return fileCount
+	}
+
+	return maxWorkers
+}
@@ -0,0 +1,341 @@
+package csvproc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// sampleRows is the number of data rows CreateSampleFiles writes to the
+// first count files.
+func sampleRows(count int) int {
+	rows := 0
+	for i := range count {
+		rows += 100 + i*50
+	}
+	return rows
+}
+
+func sampleFiles(t *testing.T, count int) []string {
+	t.Helper()
+	paths, err := CreateSampleFiles(t.TempDir(), count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+// recorder counts how often every row reached the handler.
+type recorder struct {
+	mu   sync.Mutex
+	seen map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{seen: make(map[string]int)}
+}
+
+func (r *recorder) HandleRow(ctx context.Context, row *Row) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen[fmt.Sprintf("%s:%d", row.File, row.Line)]++
+	return nil
+}
+
+func (r *recorder) rows() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.seen)
+}
+
+// duplicates returns the rows handled more than once.
+func (r *recorder) duplicates() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var dups []string
+	for key, n := range r.seen {
+		if n > 1 {
+			dups = append(dups, key)
+		}
+	}
+	return dups
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		opts    []Option
+	}{
+		{"one worker", 1, nil},
+		{"many workers", 8, nil},
+		{"chunked", 4, []Option{WithChunkSize(1024)}},
+		{"ordered", 4, []Option{WithOrdered(0)}},
+		{"ordered chunks", 4, []Option{WithOrdered(2), WithChunkSize(1024)}},
+		{"adaptive", 2, []Option{WithAdaptivePool(AdaptiveConfig{MinWorkers: 1, MaxWorkers: 4, Interval: time.Millisecond})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := sampleFiles(t, 6)
+			rec := newRecorder()
+			opts := append([]Option{WithWorkers(tt.workers), WithHandler(rec), WithLogOutput(io.Discard)}, tt.opts...)
+			p := New(opts...)
+
+			var names []string
+			rows := 0
+			for result := range p.Process(context.Background(), paths) {
+				if result.Error != nil {
+					t.Errorf("%s: %v", result.FileName, result.Error)
+				}
+				names = append(names, result.FileName)
+				rows += result.RowCount
+			}
+
+			if len(names) != len(paths) {
+				t.Fatalf("got %d results, want %d", len(names), len(paths))
+			}
+			if want := sampleRows(len(paths)); rows != want || rec.rows() != want {
+				t.Errorf("got %d rows, %d handled, want %d", rows, rec.rows(), want)
+			}
+			if dups := rec.duplicates(); len(dups) > 0 {
+				t.Errorf("rows handled twice: %v", dups)
+			}
+			if p.ordered {
+				for i, name := range names {
+					if want := filepath.Base(paths[i]); name != want {
+						t.Errorf("result %d is %s, want %s", i, name, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestProcessStopsEarly(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(cancel context.CancelFunc) bool // returns whether to keep iterating
+	}{
+		{"break", func(context.CancelFunc) bool { return false }},
+		{"cancel", func(cancel context.CancelFunc) bool { cancel(); return true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := sampleFiles(t, 8)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Rows of every file but the first block until processing stops
+			var handled sync.Map
+			handler := RowHandlerFunc(func(ctx context.Context, row *Row) error {
+				handled.Store(row.FileNum, true)
+				if row.FileNum == 1 {
+					return nil
+				}
+				<-ctx.Done()
+				return ctx.Err()
+			})
+			p := New(WithWorkers(3), WithHandler(handler), WithLogOutput(io.Discard))
+
+			results := 0
+			for result := range p.Process(ctx, paths) {
+				results++
+				if result.Error != nil {
+					t.Errorf("%s: %v", result.FileName, result.Error)
+				}
+				if !tt.stop(cancel) {
+					break
+				}
+			}
+			if results != 1 {
+				t.Errorf("got %d results, want 1", results)
+			}
+
+			// Process only returns once the workers have exited
+			files := 0
+			handled.Range(func(any, any) bool {
+				files++
+				return true
+			})
+			if files >= len(paths) {
+				t.Errorf("all %d files were started", files)
+			}
+		})
+	}
+}
+
+func TestProcessFiles(t *testing.T) {
+	paths := sampleFiles(t, 3)
+	missing := filepath.Join(t.TempDir(), "missing.csv")
+	empty := filepath.Join(t.TempDir(), "empty.csv")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var handled int
+	var mu sync.Mutex
+	p := New(WithWorkers(2), WithLogOutput(io.Discard), WithHandler(RowHandlerFunc(func(ctx context.Context, row *Row) error {
+		mu.Lock()
+		handled++
+		mu.Unlock()
+		return nil
+	})))
+	results := p.ProcessFiles(append(paths, missing, empty))
+
+	byName := make(map[string]ProcessResult)
+	for _, r := range results {
+		byName[r.FileName] = r
+	}
+	if len(byName) != len(paths)+2 {
+		t.Fatalf("got %d results, want %d", len(byName), len(paths)+2)
+	}
+
+	tests := []struct {
+		file  string
+		rows  int
+		class string // error class, empty for success
+	}{
+		{filepath.Base(paths[0]), 100, ""},
+		{filepath.Base(paths[1]), 150, ""},
+		{filepath.Base(paths[2]), 200, ""},
+		{"missing.csv", 0, ClassIO},
+		{"empty.csv", 0, ""},
+	}
+	for _, tt := range tests {
+		r := byName[tt.file]
+		if r.RowCount != tt.rows || r.ValidRows != tt.rows {
+			t.Errorf("%s: got %d rows, %d valid, want %d", tt.file, r.RowCount, r.ValidRows, tt.rows)
+		}
+		switch {
+		case tt.class == "" && r.Error != nil:
+			t.Errorf("%s: %v", tt.file, r.Error)
+		case tt.class != "" && ErrorClass(r.Error) != tt.class:
+			t.Errorf("%s: error %v has class %s, want %s", tt.file, r.Error, ErrorClass(r.Error), tt.class)
+		}
+	}
+	if handled != sampleRows(len(paths)) {
+		t.Errorf("handled %d rows, want %d", handled, sampleRows(len(paths)))
+	}
+}
+
+// truncateGzip compresses the file at path and keeps the first half of the
+// stream, which fails to read with io.ErrUnexpectedEOF.
+func truncateGzip(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	if err := os.WriteFile(path, buf.Bytes()[:buf.Len()/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// retryLog runs fn on the first retry logged by the processor.
+type retryLog struct {
+	once sync.Once
+	fn   func()
+}
+
+func (l *retryLog) Write(p []byte) (int, error) {
+	if l.fn != nil && strings.HasPrefix(string(p), "retry ") {
+		l.once.Do(l.fn)
+	}
+	return len(p), nil
+}
+
+func TestProcessRetry(t *testing.T) {
+	retry := RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	eio := &os.PathError{Op: "read", Path: "sample_1.csv", Err: syscall.EIO}
+
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, path string) func() // returns the fix applied on the first retry
+		failRow  int                                    // the handler fails this row with EIO, 0 for none
+		attempts int
+		rows     int
+		handled  int
+		class    string
+	}{
+		{
+			name:     "transient error before the first row",
+			setup:    func(t *testing.T, path string) func() { truncateGzip(t, path); return nil },
+			attempts: 3,
+			class:    ClassTransient,
+		},
+		{
+			name: "recovers on retry",
+			setup: func(t *testing.T, path string) func() {
+				whole := truncateGzip(t, path)
+				return func() { os.WriteFile(path, whole, 0644) }
+			},
+			attempts: 2,
+			rows:     100,
+			handled:  100,
+		},
+		{
+			name:     "transient error after rows were handled",
+			failRow:  5,
+			attempts: 1,
+			handled:  5,
+			class:    ClassTransient,
+		},
+		{
+			name:     "permanent error",
+			setup:    func(t *testing.T, path string) func() { os.Remove(path); return nil },
+			attempts: 1,
+			class:    ClassIO,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := sampleFiles(t, 1)[0]
+			log := &retryLog{}
+			if tt.setup != nil {
+				log.fn = tt.setup(t, path)
+			}
+
+			handled := 0
+			handler := RowHandlerFunc(func(ctx context.Context, row *Row) error {
+				handled++
+				if handled == tt.failRow {
+					return eio
+				}
+				return nil
+			})
+			p := New(WithWorkers(1), WithHandler(handler), WithRetry(retry), WithLogOutput(log))
+			results := p.ProcessFiles([]string{path})
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+
+			r := results[0]
+			if r.Attempts != tt.attempts {
+				t.Errorf("got %d attempts, want %d", r.Attempts, tt.attempts)
+			}
+			if r.RowCount != tt.rows || handled != tt.handled {
+				t.Errorf("got %d rows, %d handled, want %d, %d", r.RowCount, handled, tt.rows, tt.handled)
+			}
+			switch {
+			case tt.class == "" && r.Error != nil:
+				t.Errorf("unexpected error: %v", r.Error)
+			case tt.class != "" && ErrorClass(r.Error) != tt.class:
+				t.Errorf("error %v has class %s, want %s", r.Error, ErrorClass(r.Error), tt.class)
+			}
+		})
+	}
+}
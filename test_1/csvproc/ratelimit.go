@@ -0,0 +1,96 @@
+package csvproc
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit caps throughput in rows and bytes per second. Zero leaves a
+// dimension unlimited.
+type RateLimit struct {
+	RowsPerSec  float64
+	BytesPerSec int64
+}
+
+func (l RateLimit) enabled() bool {
+	return l.RowsPerSec > 0 || l.BytesPerSec > 0
+}
+
+// throttle enforces a RateLimit with a token bucket per dimension. The
+// buckets hold a tenth of a second's worth of tokens, so bursts stay short
+// even when many workers share a throttle. A nil throttle never waits.
+type throttle struct {
+	rows  *rate.Limiter
+	bytes *rate.Limiter
+}
+
+func newThrottle(l RateLimit) *throttle {
+	if !l.enabled() {
+		return nil
+	}
+	t := &throttle{}
+	if l.RowsPerSec > 0 {
+		t.rows = rate.NewLimiter(rate.Limit(l.RowsPerSec), max(int(l.RowsPerSec/10), 1))
+	}
+	if l.BytesPerSec > 0 {
+		t.bytes = rate.NewLimiter(rate.Limit(l.BytesPerSec), int(max(l.BytesPerSec/10, 1)))
+	}
+	return t
+}
+
+// wait blocks until one row of size bytes may be processed.
+func (t *throttle) wait(ctx context.Context, bytes int64) error {
+	if t == nil {
+		return nil
+	}
+	if t.rows != nil {
+		if err := t.rows.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if t.bytes != nil {
+		// Rows larger than the bucket are paid for in several steps
+		for n := int(bytes); n > 0; {
+			step := min(n, t.bytes.Burst())
+			if err := t.bytes.WaitN(ctx, step); err != nil {
+				return err
+			}
+			n -= step
+		}
+	}
+	return nil
+}
+
+// fileThrottles hands out one throttle per input file, shared by its chunks
+// and retries.
+type fileThrottles struct {
+	limit RateLimit
+	mu    sync.Mutex
+	files map[int]*throttle
+}
+
+func (ft *fileThrottles) get(fileNum int) *throttle {
+	if ft == nil {
+		return nil
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	t, ok := ft.files[fileNum]
+	if !ok {
+		t = newThrottle(ft.limit)
+		ft.files[fileNum] = t
+	}
+	return t
+}
+
+// done forgets the throttle of a file once all of its chunks are in.
+func (ft *fileThrottles) done(fileNum int) {
+	if ft == nil {
+		return
+	}
+	ft.mu.Lock()
+	delete(ft.files, fileNum)
+	ft.mu.Unlock()
+}
@@ -0,0 +1,107 @@
+package csvproc
+
+import (
+	"context"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestThrottleSplit(t *testing.T) {
+	// Rates are low enough that the buckets barely refill during the test;
+	// the buckets hold a tenth of a second's worth
+	global := RateLimit{RowsPerSec: 100, BytesPerSec: 1000}
+	perFile := RateLimit{RowsPerSec: 50, BytesPerSec: 500}
+	cp := New(WithRateLimit(global, perFile), WithLogOutput(io.Discard))
+	first, second := cp.fileRate.get(1), cp.fileRate.get(2)
+	if first == second || cp.fileRate.get(1) != first {
+		t.Fatal("want one throttle per file, shared by its chunks")
+	}
+
+	// Every row is charged to the global and to its file's buckets
+	rows := []struct {
+		file  *throttle
+		bytes int64
+	}{
+		{first, 30},
+		{second, 40},
+		{first, 10},
+	}
+	for _, r := range rows {
+		if err := cp.throttle(r.file, r.bytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		limiter *rate.Limiter
+		want    float64
+	}{
+		{"global rows", cp.rate.rows, 10 - 3},
+		{"global bytes", cp.rate.bytes, 100 - 80},
+		{"first file rows", first.rows, 5 - 2},
+		{"first file bytes", first.bytes, 50 - 40},
+		{"second file rows", second.rows, 5 - 1},
+		{"second file bytes", second.bytes, 50 - 40},
+	}
+	for _, tt := range tests {
+		if got := tt.limiter.Tokens(); math.Abs(got-tt.want) > 1 {
+			t.Errorf("%s: %.2f tokens left, want %.0f", tt.name, got, tt.want)
+		}
+	}
+
+	// A finished file starts over with a full bucket if seen again
+	cp.fileRate.done(1)
+	if again := cp.fileRate.get(1); again == first || again.bytes.Tokens() < 49 {
+		t.Error("throttle of a finished file was kept")
+	}
+}
+
+func TestThrottleOnlyConfiguredDimensions(t *testing.T) {
+	cp := New(WithRateLimit(RateLimit{BytesPerSec: 1000}, RateLimit{}), WithLogOutput(io.Discard))
+	if cp.rate.rows != nil || cp.rate.bytes == nil {
+		t.Errorf("got global throttle %+v", cp.rate)
+	}
+	if cp.fileRate != nil || cp.fileRate.get(1) != nil {
+		t.Error("got a per-file throttle without a per-file limit")
+	}
+	if err := cp.throttle(cp.fileRate.get(1), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	cp = New(WithRateLimit(RateLimit{}, RateLimit{RowsPerSec: 10}), WithLogOutput(io.Discard))
+	if cp.rate != nil {
+		t.Errorf("got global throttle %+v without a global limit", cp.rate)
+	}
+	if file := cp.fileRate.get(1); file.rows == nil || file.bytes != nil {
+		t.Errorf("got per-file throttle %+v", file)
+	}
+}
+
+func TestThrottleRowLargerThanBucket(t *testing.T) {
+	// The bucket holds 10000 bytes, so the other 15000 of the row are
+	// waited for in steps no larger than the bucket
+	th := newThrottle(RateLimit{BytesPerSec: 100_000})
+	start := time.Now()
+	if err := th.wait(context.Background(), 25_000); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("a row of 25000 bytes took %v at 100000 bytes/s with 10000 in the bucket", elapsed)
+	}
+}
+
+func TestThrottleCancelled(t *testing.T) {
+	th := newThrottle(RateLimit{RowsPerSec: 1})
+	if err := th.wait(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := th.wait(ctx, 0); err == nil {
+		t.Error("waited for an empty bucket after cancellation")
+	}
+}
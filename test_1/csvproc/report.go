@@ -0,0 +1,291 @@
+package csvproc
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Report formats accepted by WriteReport.
+const (
+	ReportJSON  = "json"
+	ReportCSV   = "csv"
+	ReportJUnit = "junit"
+)
+
+// FileReport is the serialisable outcome of one file.
+type FileReport struct {
+	File        string  `json:"file"`
+	Status      string  `json:"status"`
+	Rows        int     `json:"rows"`
+	ValidRows   int     `json:"valid_rows"`
+	InvalidRows int     `json:"invalid_rows"`
+	Duplicates  int     `json:"duplicates"`
+	Conflicts   int     `json:"conflicts"`
+	ResumedRows int     `json:"resumed_rows"`
+	Inserted    int     `json:"inserted"`
+	Updated     int     `json:"updated"`
+	Skipped     int     `json:"skipped"`
+	Filtered    int     `json:"filtered"`
+	Passed      int     `json:"passed"`
+	Attempts    int     `json:"attempts"`
+	Bytes       int64   `json:"bytes"`
+	DurationSec float64 `json:"duration_seconds"`
+	ErrorClass  string  `json:"error_class,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// ReportTotals sums up a run.
+type ReportTotals struct {
+	Files       int   `json:"files"`
+	Succeeded   int   `json:"succeeded"`
+	Failed      int   `json:"failed"`
+	Rows        int   `json:"rows"`
+	ValidRows   int   `json:"valid_rows"`
+	InvalidRows int   `json:"invalid_rows"`
+	Duplicates  int   `json:"duplicates"`
+	Conflicts   int   `json:"conflicts"`
+	Inserted    int   `json:"inserted"`
+	Updated     int   `json:"updated"`
+	Skipped     int   `json:"skipped"`
+	Filtered    int   `json:"filtered"`
+	Passed      int   `json:"passed"`
+	Bytes       int64 `json:"bytes"`
+}
+
+// RunReport is a machine readable summary of a processing run.
+type RunReport struct {
+	StartedAt   time.Time     `json:"started_at"`
+	FinishedAt  time.Time     `json:"finished_at"`
+	DurationSec float64       `json:"duration_seconds"`
+	Workers     int           `json:"workers"`
+	PeakWorkers int           `json:"peak_workers"`
+	Resizes     []ResizeEvent `json:"pool_resizes,omitempty"`
+	Files       []FileReport  `json:"files"`
+	Totals      ReportTotals  `json:"totals"`
+}
+
+func NewRunReport(results []ProcessResult, workers int, startedAt, finishedAt time.Time) *RunReport {
+	report := &RunReport{
+		StartedAt:   startedAt,
+		FinishedAt:  finishedAt,
+		DurationSec: finishedAt.Sub(startedAt).Seconds(),
+		Workers:     workers,
+		Files:       make([]FileReport, 0, len(results)),
+	}
+
+	for _, r := range results {
+		report.Files = append(report.Files, NewFileReport(r))
+		report.Totals.add(r)
+	}
+	return report
+}
+
+// NewFileReport converts the result of one file.
+func NewFileReport(r ProcessResult) FileReport {
+	fr := FileReport{
+		File:        r.FileName,
+		Status:      "ok",
+		Rows:        r.RowCount,
+		ValidRows:   r.ValidRows,
+		InvalidRows: r.InvalidRows,
+		Duplicates:  r.Duplicates,
+		Conflicts:   r.Conflicts,
+		ResumedRows: r.ResumedRows,
+		Inserted:    r.Inserted,
+		Updated:     r.Updated,
+		Skipped:     r.Skipped,
+		Filtered:    r.Filtered,
+		Passed:      r.Passed,
+		Attempts:    r.Attempts,
+		Bytes:       r.Bytes,
+		DurationSec: r.ProcessTime.Seconds(),
+	}
+	if r.Error != nil {
+		fr.Status = "failed"
+		fr.ErrorClass = ErrorClass(r.Error)
+		fr.Error = r.Error.Error()
+	}
+	return fr
+}
+
+func (t *ReportTotals) add(r ProcessResult) {
+	if r.Error != nil {
+		t.Failed++
+	} else {
+		t.Succeeded++
+	}
+	t.Files++
+	t.Rows += r.RowCount
+	t.ValidRows += r.ValidRows
+	t.InvalidRows += r.InvalidRows
+	t.Duplicates += r.Duplicates
+	t.Conflicts += r.Conflicts
+	t.Inserted += r.Inserted
+	t.Updated += r.Updated
+	t.Skipped += r.Skipped
+	t.Filtered += r.Filtered
+	t.Passed += r.Passed
+	t.Bytes += r.Bytes
+}
+
+// ReportFormat picks a format from the file extension of path.
+func ReportFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ReportCSV
+	case ".xml":
+		return ReportJUnit
+	default:
+		return ReportJSON
+	}
+}
+
+// WriteReport writes the report to path ("-" for stdout) in format.
+func WriteReport(report *RunReport, path, format string) error {
+	if format == "" {
+		format = ReportFormat(path)
+	}
+	var write func(io.Writer) error
+	switch format {
+	case ReportJSON:
+		write = report.WriteJSON
+	case ReportCSV:
+		write = report.WriteCSV
+	case ReportJUnit:
+		write = report.WriteJUnit
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (r *RunReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one line per file.
+func (r *RunReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"file", "status", "rows", "valid_rows", "invalid_rows", "duplicates", "conflicts", "resumed_rows", "inserted", "updated", "skipped", "filtered", "passed", "attempts", "bytes", "duration_seconds", "error_class", "error"})
+	for _, f := range r.Files {
+		cw.Write([]string{
+			f.File,
+			f.Status,
+			strconv.Itoa(f.Rows),
+			strconv.Itoa(f.ValidRows),
+			strconv.Itoa(f.InvalidRows),
+			strconv.Itoa(f.Duplicates),
+			strconv.Itoa(f.Conflicts),
+			strconv.Itoa(f.ResumedRows),
+			strconv.Itoa(f.Inserted),
+			strconv.Itoa(f.Updated),
+			strconv.Itoa(f.Skipped),
+			strconv.Itoa(f.Filtered),
+			strconv.Itoa(f.Passed),
+			strconv.Itoa(f.Attempts),
+			strconv.FormatInt(f.Bytes, 10),
+			strconv.FormatFloat(f.DurationSec, 'f', 6, 64),
+			f.ErrorClass,
+			f.Error,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Type    string `xml:"type,attr"`
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the report as a JUnit XML test suite with one test
+// case per file, so CI systems can show failed files as failed tests.
+func (r *RunReport) WriteJUnit(w io.Writer) error {
+	suite := junitSuite{
+		Name:      "csvproc",
+		Tests:     r.Totals.Files,
+		Failures:  r.Totals.Failed,
+		Time:      strconv.FormatFloat(r.DurationSec, 'f', 3, 64),
+		Timestamp: r.StartedAt.Format(time.RFC3339),
+		Properties: []junitProperty{
+			{Name: "workers", Value: strconv.Itoa(r.Workers)},
+			{Name: "peak_workers", Value: strconv.Itoa(r.PeakWorkers)},
+			{Name: "rows", Value: strconv.Itoa(r.Totals.Rows)},
+			{Name: "bytes", Value: strconv.FormatInt(r.Totals.Bytes, 10)},
+		},
+	}
+
+	for _, f := range r.Files {
+		tc := junitCase{
+			Name:      f.File,
+			Classname: "csvproc",
+			Time:      strconv.FormatFloat(f.DurationSec, 'f', 3, 64),
+			SystemOut: fmt.Sprintf("rows=%d valid=%d invalid=%d duplicates=%d conflicts=%d bytes=%d", f.Rows, f.ValidRows, f.InvalidRows, f.Duplicates, f.Conflicts, f.Bytes),
+		}
+		if f.Status != "ok" {
+			tc.Failure = &junitFailure{Type: f.ErrorClass, Message: f.Error}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,76 @@
+package csvproc
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func goldenReport() *RunReport {
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	results := []ProcessResult{
+		{
+			FileName: "a.csv", RowCount: 100, ValidRows: 95, InvalidRows: 5, Duplicates: 2, Conflicts: 1,
+			Attempts: 1, Bytes: 4096, ProcessTime: 1250 * time.Millisecond,
+		},
+		{
+			FileName: "b.csv.gz", RowCount: 40, ValidRows: 40, ResumedRows: 10, Inserted: 30, Updated: 5, Skipped: 5,
+			Filtered: 3, Passed: 40, Attempts: 3, Bytes: 2048, ProcessTime: 800 * time.Millisecond,
+			Error: &ProcessError{Class: ClassParse, Err: errors.New(`row 41 (line 42): bare " in non-quoted field, <x> & "y"`)},
+		},
+	}
+	report := NewRunReport(results, 2, start, start.Add(2500*time.Millisecond))
+	report.PeakWorkers = 4
+	report.Resizes = []ResizeEvent{{At: start.Add(time.Second), From: 2, To: 4, Reason: "throughput rising", RowsPerSec: 1500, QueueDepth: 3, RowLatency: 2 * time.Millisecond}}
+	return report
+}
+
+func TestWriteReportGolden(t *testing.T) {
+	for _, ext := range []string{".json", ".csv", ".xml"} {
+		t.Run(ext, func(t *testing.T) {
+			// The format follows from the extension
+			path := filepath.Join(t.TempDir(), "report"+ext)
+			if err := WriteReport(goldenReport(), path, ""); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "report"+ext)
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("report differs from %s, rerun with -update if intended:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestWriteReportErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.txt")
+	if err := WriteReport(goldenReport(), path, "yaml"); err == nil {
+		t.Error("accepted an unknown format")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("created the report of an unknown format: %v", err)
+	}
+	if err := WriteReport(goldenReport(), filepath.Join(dir, "missing", "report.json"), ""); err == nil {
+		t.Error("accepted a report in a missing directory")
+	}
+}
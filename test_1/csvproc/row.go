@@ -0,0 +1,109 @@
+package csvproc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Logical columns of the user export files.
+const (
+	ColumnID    = "ID"
+	ColumnName  = "Name"
+	ColumnEmail = "Email"
+	ColumnAge   = "Age"
+	ColumnCity  = "City"
+)
+
+// Header maps column names to their position in a record.
+type Header struct {
+	Columns []string
+	index   map[string]int
+}
+
+func NewHeader(columns []string) *Header {
+	h := &Header{
+		Columns: make([]string, len(columns)),
+		index:   make(map[string]int, len(columns)),
+	}
+	for i, col := range columns {
+		col = strings.TrimSpace(col)
+		h.Columns[i] = col
+		h.index[col] = i
+	}
+	return h
+}
+
+// Index returns the position of col in the record, or false if the column
+// is not present.
+func (h *Header) Index(col string) (int, bool) {
+	i, ok := h.index[col]
+	return i, ok
+}
+
+// Row is a single data record handed to a RowHandler.
+type Row struct {
+	File    string
+	FileNum int // position of the file in the input list
+	Line    int
+	Header  *Header
+	Fields  []string
+
+	// User is filled by the parse stage of a Pipeline.
+	User *UserRecord
+}
+
+// Get returns the value of col, or "" if the column is missing.
+func (r *Row) Get(col string) string {
+	i, ok := r.Header.Index(col)
+	if !ok || i >= len(r.Fields) {
+		return ""
+	}
+	return r.Fields[i]
+}
+
+// Set replaces the value of col. Unknown columns are ignored.
+func (r *Row) Set(col, value string) {
+	i, ok := r.Header.Index(col)
+	if !ok || i >= len(r.Fields) {
+		return
+	}
+	r.Fields[i] = value
+}
+
+// Int returns the value of col parsed as an integer.
+func (r *Row) Int(col string) (int, error) {
+	v := strings.TrimSpace(r.Get(col))
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, NewValidationError(col, fmt.Sprintf("invalid integer %q", v))
+	}
+	return n, nil
+}
+
+// UserRecord is the typed form of a row from the user export files.
+type UserRecord struct {
+	ID    int
+	Name  string
+	Email string
+	Age   int
+	City  string
+}
+
+func ParseUserRecord(r *Row) (*UserRecord, error) {
+	id, err := r.Int(ColumnID)
+	if err != nil {
+		return nil, err
+	}
+	age, err := r.Int(ColumnAge)
+	if err != nil {
+		return nil, err
+	}
+	return &UserRecord{
+		ID:    id,
+		Name:  strings.TrimSpace(r.Get(ColumnName)),
+		Email: strings.TrimSpace(r.Get(ColumnEmail)),
+		Age:   age,
+		City:  strings.TrimSpace(r.Get(ColumnCity)),
+	}, nil
+}
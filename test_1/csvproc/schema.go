@@ -0,0 +1,194 @@
+package csvproc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Column types supported by a Schema.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+)
+
+// ColumnRule declares the constraints of a single column.
+type ColumnRule struct {
+	Name     string   `json:"name" yaml:"name"`
+	Type     string   `json:"type" yaml:"type"`
+	Required bool     `json:"required" yaml:"required"`
+	Format   string   `json:"format,omitempty" yaml:"format,omitempty"`
+	Pattern  string   `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Min      *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Schema is a declarative description of the expected CSV columns.
+type Schema struct {
+	Columns []ColumnRule `json:"columns" yaml:"columns"`
+}
+
+// ColumnNames returns the names of the columns in the schema.
+func (s *Schema) ColumnNames() []string {
+	if s == nil {
+		return nil
+	}
+	names := make([]string, len(s.Columns))
+	for i, c := range s.Columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// LoadSchema reads a schema from a .json, .yaml or .yml file.
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema Schema
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &schema)
+	default:
+		err = json.Unmarshal(data, &schema)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse schema %s: %w", path, err)
+	}
+
+	if err := schema.compile(); err != nil {
+		return nil, fmt.Errorf("schema %s: %w", path, err)
+	}
+	return &schema, nil
+}
+
+func (s *Schema) compile() error {
+	for i := range s.Columns {
+		col := &s.Columns[i]
+		if col.Name == "" {
+			return fmt.Errorf("column %d has no name", i+1)
+		}
+		if col.Type == "" {
+			col.Type = TypeString
+		}
+
+		switch col.Type {
+		case TypeString, TypeInt, TypeFloat, TypeBool:
+		default:
+			return fmt.Errorf("column %s: unknown type %q", col.Name, col.Type)
+		}
+
+		switch col.Format {
+		case "", "email":
+		default:
+			return fmt.Errorf("column %s: unknown format %q", col.Name, col.Format)
+		}
+
+		if col.Pattern != "" {
+			re, err := regexp.Compile(col.Pattern)
+			if err != nil {
+				return fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			col.pattern = re
+		}
+	}
+	return nil
+}
+
+// CheckHeader verifies that every required column is present in the header.
+func (s *Schema) CheckHeader(header *Header) error {
+	verr := &ValidationError{}
+	for _, col := range s.Columns {
+		if _, ok := header.Index(col.Name); !ok && col.Required {
+			verr.Add(col.Name, "required column missing from header")
+		}
+	}
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// Validate checks a row against every column rule. It can be used directly
+// as the Validate stage of a Pipeline.
+func (s *Schema) Validate(ctx context.Context, row *Row) error {
+	if len(row.Fields) != len(row.Header.Columns) {
+		return NewValidationError("", fmt.Sprintf("expected %d columns, got %d", len(row.Header.Columns), len(row.Fields)))
+	}
+
+	verr := &ValidationError{}
+	for i := range s.Columns {
+		col := &s.Columns[i]
+		if reason := col.check(strings.TrimSpace(row.Get(col.Name))); reason != "" {
+			verr.Add(col.Name, reason)
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func (col *ColumnRule) check(value string) string {
+	if value == "" {
+		if col.Required {
+			return "required value is empty"
+		}
+		return ""
+	}
+
+	switch col.Type {
+	case TypeInt, TypeFloat:
+		var n float64
+		if col.Type == TypeInt {
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Sprintf("invalid integer %q", value)
+			}
+			n = float64(v)
+		} else {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Sprintf("invalid number %q", value)
+			}
+			n = v
+		}
+		if col.Min != nil && n < *col.Min {
+			return fmt.Sprintf("%q is below minimum %v", value, *col.Min)
+		}
+		if col.Max != nil && n > *col.Max {
+			return fmt.Sprintf("%q is above maximum %v", value, *col.Max)
+		}
+	case TypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Sprintf("invalid boolean %q", value)
+		}
+	}
+
+	if col.Format == "email" {
+		addr, err := mail.ParseAddress(value)
+		if err != nil || addr.Address != value {
+			return fmt.Sprintf("invalid email %q", value)
+		}
+	}
+
+	if col.pattern != nil && !col.pattern.MatchString(value) {
+		return fmt.Sprintf("%q does not match pattern %s", value, col.Pattern)
+	}
+	return ""
+}
@@ -0,0 +1,478 @@
+package csvproc
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Sink receives processed rows. Implementations are not required to be safe
+// for concurrent use; the workers write through an AsyncSink which
+// serialises the calls.
+type Sink interface {
+	Write(row *Row) error
+	Close() error
+}
+
+// Flusher is implemented by sinks that buffer rows. Flush writes the
+// buffered rows out.
+type Flusher interface {
+	Flush() error
+}
+
+// Sink kinds accepted by NewSink.
+const (
+	SinkCSV   = "csv"
+	SinkTSV   = "tsv"
+	SinkJSONL = "jsonl"
+	SinkSQL   = "sql"
+)
+
+// SinkConfig describes where processed rows are written.
+type SinkConfig struct {
+	Kind      string
+	Output    string // file path, or DSN for the sql sink
+	Driver    string // database/sql driver for the sql sink
+	Table     string
+	BatchSize int
+
+	// Append continues an existing output file instead of truncating it,
+	// as a resumed run must: the rows committed by the interrupted run are
+	// skipped and not written again. A CSV or TSV file that is not empty
+	// keeps its header and column order.
+	Append bool
+}
+
+func NewSink(cfg SinkConfig) (Sink, error) {
+	switch cfg.Kind {
+	case SinkCSV, SinkTSV, SinkJSONL:
+		comma := ','
+		if cfg.Kind == SinkTSV {
+			comma = '\t'
+		}
+		var header []string
+		if cfg.Append && cfg.Kind != SinkJSONL {
+			var err error
+			if header, err = readCSVHeader(cfg.Output, comma); err != nil {
+				return nil, err
+			}
+		}
+		file, err := openOutput(cfg.Output, cfg.Append)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Kind == SinkJSONL {
+			return NewJSONLSink(file), nil
+		}
+		sink := NewCSVSink(file, comma)
+		if header != nil {
+			sink.WithColumns(header)
+		}
+		return sink, nil
+	case SinkSQL:
+		db, err := sql.Open(cfg.Driver, cfg.Output)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		return NewSQLSink(db, cfg.Driver, cfg.Table, cfg.BatchSize, true), nil
+	default:
+		return nil, fmt.Errorf("unknown sink %q", cfg.Kind)
+	}
+}
+
+// openOutput creates the file at path, or opens it for appending.
+func openOutput(path string, append bool) (*os.File, error) {
+	if !append {
+		return os.Create(path)
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// readCSVHeader returns the first record of the file at path, or nil if the
+// file is missing or empty.
+func readCSVHeader(path string, comma rune) ([]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.Comma = comma
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read header of %s: %w", path, err)
+	}
+	return header, nil
+}
+
+// columnMapper reorders the fields of rows coming from files with different
+// headers into the column order of the first row seen.
+type columnMapper struct {
+	columns []string
+}
+
+func (m *columnMapper) fields(row *Row) []string {
+	if m.columns == nil {
+		m.columns = append([]string(nil), row.Header.Columns...)
+	}
+	out := make([]string, len(m.columns))
+	for i, col := range m.columns {
+		out[i] = row.Get(col)
+	}
+	return out
+}
+
+// CSVSink merges rows into a single delimited file with one header line.
+type CSVSink struct {
+	out    io.WriteCloser
+	w      *csv.Writer
+	mapper columnMapper
+}
+
+func NewCSVSink(out io.WriteCloser, comma rune) *CSVSink {
+	w := csv.NewWriter(out)
+	w.Comma = comma
+	return &CSVSink{out: out, w: w}
+}
+
+// WithColumns continues a file that already starts with a header of
+// columns: rows are written in that order and no header is written.
+func (s *CSVSink) WithColumns(columns []string) *CSVSink {
+	s.mapper.columns = columns
+	return s
+}
+
+func (s *CSVSink) Write(row *Row) error {
+	first := s.mapper.columns == nil
+	fields := s.mapper.fields(row)
+	if first {
+		if err := s.w.Write(s.mapper.columns); err != nil {
+			return err
+		}
+	}
+	return s.w.Write(fields)
+}
+
+func (s *CSVSink) Flush() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *CSVSink) Close() error {
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		s.out.Close()
+		return err
+	}
+	return s.out.Close()
+}
+
+// JSONLSink writes one JSON object per row, keys in header order.
+type JSONLSink struct {
+	out    io.WriteCloser
+	w      *bufio.Writer
+	mapper columnMapper
+}
+
+func NewJSONLSink(out io.WriteCloser) *JSONLSink {
+	return &JSONLSink{out: out, w: bufio.NewWriter(out)}
+}
+
+func (s *JSONLSink) Write(row *Row) error {
+	fields := s.mapper.fields(row)
+
+	s.w.WriteByte('{')
+	for i, col := range s.mapper.columns {
+		if i > 0 {
+			s.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(col)
+		value, _ := json.Marshal(fields[i])
+		s.w.Write(key)
+		s.w.WriteByte(':')
+		s.w.Write(value)
+	}
+	s.w.WriteByte('}')
+	return s.w.WriteByte('\n')
+}
+
+func (s *JSONLSink) Flush() error {
+	return s.w.Flush()
+}
+
+func (s *JSONLSink) Close() error {
+	if err := s.w.Flush(); err != nil {
+		s.out.Close()
+		return err
+	}
+	return s.out.Close()
+}
+
+// SQLSink inserts rows in batches, one multi-row INSERT per transaction,
+// similar to a PostgreSQL COPY. Column names are the lower-cased header
+// names. It works with any database/sql driver, e.g. pgx for PostgreSQL or
+// an SQLite driver in tests.
+type SQLSink struct {
+	db        *sql.DB
+	table     string
+	batchSize int
+	dollar    bool // $1 placeholders instead of ?
+	ownsDB    bool
+	mapper    columnMapper
+	batch     [][]string
+}
+
+func NewSQLSink(db *sql.DB, driver, table string, batchSize int, ownsDB bool) *SQLSink {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	return &SQLSink{
+		db:        db,
+		table:     table,
+		batchSize: batchSize,
+		dollar:    driver == "pgx" || driver == "postgres",
+		ownsDB:    ownsDB,
+	}
+}
+
+func (s *SQLSink) Write(row *Row) error {
+	s.batch = append(s.batch, s.mapper.fields(row))
+	// Flush early when another row would not fit the bind parameters
+	if len(s.batch) >= s.batchSize || (len(s.batch)+1)*len(s.mapper.columns) > s.maxParams() {
+		return s.flush()
+	}
+	return nil
+}
+
+// maxParams is the number of bind parameters a statement may have.
+func (s *SQLSink) maxParams() int {
+	if s.dollar {
+		return maxBindParams
+	}
+	return maxSQLiteParams
+}
+
+func (s *SQLSink) flush() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+
+	cols := make([]string, len(s.mapper.columns))
+	for i, col := range s.mapper.columns {
+		cols[i] = quoteIdent(strings.ToLower(col))
+	}
+
+	var query strings.Builder
+	args := make([]any, 0, len(s.batch)*len(cols))
+	fmt.Fprintf(&query, "INSERT INTO %s (%s) VALUES ", quoteIdent(s.table), strings.Join(cols, ", "))
+	for i, fields := range s.batch {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteByte('(')
+		for j, v := range fields {
+			if j > 0 {
+				query.WriteString(", ")
+			}
+			args = append(args, v)
+			if s.dollar {
+				fmt.Fprintf(&query, "$%d", len(args))
+			} else {
+				query.WriteByte('?')
+			}
+		}
+		query.WriteByte(')')
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(query.String(), args...); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert batch of %d rows: %w", len(s.batch), err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.batch = s.batch[:0]
+	return nil
+}
+
+// Flush inserts the rows of the current batch.
+func (s *SQLSink) Flush() error {
+	return s.flush()
+}
+
+func (s *SQLSink) Close() error {
+	err := s.flush()
+	if s.ownsDB {
+		if cerr := s.db.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// maxBindParams is the number of bind parameters PostgreSQL allows in one
+// statement, maxSQLiteParams the default of SQLite for ? placeholders.
+const (
+	maxBindParams   = 65535
+	maxSQLiteParams = 32766
+)
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// ErrSinkClosed is returned when writing to an AsyncSink after Close.
+var ErrSinkClosed = errors.New("sink closed")
+
+// AsyncSink decouples the workers from a Sink through a bounded buffer
+// drained by a single goroutine. When the sink falls behind the buffer
+// fills up and Write blocks, which throttles the worker pool instead of
+// letting rows pile up in memory.
+type AsyncSink struct {
+	sink Sink
+	rows chan asyncItem
+	done chan struct{}
+	mu   sync.Mutex
+	err  error
+
+	// Senders hold sendMu for reading while they send on rows, Close takes
+	// it for writing before it closes rows
+	sendMu sync.RWMutex
+	closed bool
+}
+
+func NewAsyncSink(sink Sink, buffer int) *AsyncSink {
+	if buffer <= 0 {
+		buffer = 1
+	}
+	as := &AsyncSink{
+		sink: sink,
+		rows: make(chan asyncItem, buffer),
+		done: make(chan struct{}),
+	}
+	go as.run()
+	return as
+}
+
+// asyncItem is a queued row, or a flush request when flushed is set.
+type asyncItem struct {
+	row     *Row
+	flushed chan error
+}
+
+func (as *AsyncSink) run() {
+	defer close(as.done)
+	for item := range as.rows {
+		if item.flushed != nil {
+			if f, ok := as.sink.(Flusher); ok && as.Err() == nil {
+				as.fail(f.Flush())
+			}
+			item.flushed <- as.Err()
+			continue
+		}
+		if as.Err() != nil {
+			continue // drain so writers are not blocked
+		}
+		as.fail(as.sink.Write(item.row))
+	}
+}
+
+func (as *AsyncSink) fail(err error) {
+	if err == nil {
+		return
+	}
+	as.mu.Lock()
+	if as.err == nil {
+		as.err = err
+	}
+	as.mu.Unlock()
+}
+
+// Err returns the first error reported by the underlying sink.
+func (as *AsyncSink) Err() error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	return as.err
+}
+
+// WriteRow queues row, blocking while the buffer is full. It can be used
+// directly as the Sink stage of a Pipeline.
+func (as *AsyncSink) WriteRow(ctx context.Context, row *Row) error {
+	if err := as.Err(); err != nil {
+		return err
+	}
+
+	as.sendMu.RLock()
+	defer as.sendMu.RUnlock()
+	if as.closed {
+		return ErrSinkClosed
+	}
+
+	select {
+	case as.rows <- asyncItem{row: row}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush waits until the rows queued so far have been written and flushed
+// by the underlying sink.
+func (as *AsyncSink) Flush() error {
+	as.sendMu.RLock()
+	if as.closed {
+		as.sendMu.RUnlock()
+		return ErrSinkClosed
+	}
+	flushed := make(chan error, 1)
+	as.rows <- asyncItem{flushed: flushed}
+	as.sendMu.RUnlock()
+
+	// The writer answers every request it has taken off rows, also after
+	// the sink failed
+	return <-flushed
+}
+
+// Close waits for the queued rows to be written and closes the sink. Writes
+// still in progress complete first, later ones fail with ErrSinkClosed.
+func (as *AsyncSink) Close() error {
+	as.sendMu.Lock()
+	if as.closed {
+		as.sendMu.Unlock()
+		return nil
+	}
+	as.closed = true
+	as.sendMu.Unlock()
+
+	close(as.rows)
+	<-as.done
+
+	err := as.Err()
+	if cerr := as.sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
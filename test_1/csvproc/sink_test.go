@@ -0,0 +1,190 @@
+package csvproc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openSQLite(t *testing.T, columns []string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection would get its own in-memory database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	cols := make([]string, len(columns))
+	for i, col := range columns {
+		cols[i] = quoteIdent(strings.ToLower(col)) + " TEXT"
+	}
+	if _, err := db.Exec(fmt.Sprintf("CREATE TABLE users (%s)", strings.Join(cols, ", "))); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSQLSink(t *testing.T) {
+	wide := make([]string, 100)
+	for i := range wide {
+		wide[i] = fmt.Sprintf("C%d", i)
+	}
+
+	tests := []struct {
+		name      string
+		columns   []string
+		batchSize int
+		rows      int
+	}{
+		{"single batch", []string{"ID", "Name", "Email"}, 500, 10},
+		{"several batches", []string{"ID", "Name", "Email"}, 3, 10},
+		{"batch size exact", []string{"ID", "Name", "Email"}, 5, 10},
+		// 700 rows of 100 columns need more bind parameters than SQLite
+		// allows in one statement
+		{"capped by bind parameters", wide, 1000, 700},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openSQLite(t, tt.columns)
+			sink := NewSQLSink(db, "sqlite3", "users", tt.batchSize, false)
+			header := NewHeader(tt.columns)
+			for i := range tt.rows {
+				fields := make([]string, len(tt.columns))
+				for j := range fields {
+					fields[j] = fmt.Sprintf("%d-%d", i, j)
+				}
+				if err := sink.Write(&Row{Header: header, Fields: fields}); err != nil {
+					t.Fatalf("write row %d: %v", i, err)
+				}
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var count int
+			if err := db.QueryRow("SELECT count(*) FROM users").Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.rows {
+				t.Errorf("got %d rows, want %d", count, tt.rows)
+			}
+			last := quoteIdent(strings.ToLower(tt.columns[len(tt.columns)-1]))
+			var v string
+			query := fmt.Sprintf("SELECT %s FROM users WHERE %s = ?", last, quoteIdent(strings.ToLower(tt.columns[0])))
+			if err := db.QueryRow(query, fmt.Sprintf("%d-0", tt.rows-1)).Scan(&v); err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("%d-%d", tt.rows-1, len(tt.columns)-1); v != want {
+				t.Errorf("last column of last row = %q, want %q", v, want)
+			}
+		})
+	}
+}
+
+func TestSQLSinkMapsColumns(t *testing.T) {
+	db := openSQLite(t, []string{"ID", "Name", "Email"})
+	sink := NewSQLSink(db, "sqlite3", "users", 2, false)
+
+	rows := []*Row{
+		{Header: NewHeader([]string{"ID", "Name", "Email"}), Fields: []string{"1", "Ana", "ana@example.com"}},
+		{Header: NewHeader([]string{"Email", "ID", "Name"}), Fields: []string{"budi@example.com", "2", "Budi"}},
+		{Header: NewHeader([]string{"Name", "ID"}), Fields: []string{"Citra", "3"}},
+	}
+	for _, row := range rows {
+		if err := sink.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][2]string{
+		"1": {"Ana", "ana@example.com"},
+		"2": {"Budi", "budi@example.com"},
+		"3": {"Citra", ""},
+	}
+	for id, w := range want {
+		var name, email string
+		if err := db.QueryRow(`SELECT "name", "email" FROM users WHERE "id" = ?`, id).Scan(&name, &email); err != nil {
+			t.Fatalf("id %s: %v", id, err)
+		}
+		if name != w[0] || email != w[1] {
+			t.Errorf("id %s = %q, %q, want %q, %q", id, name, email, w[0], w[1])
+		}
+	}
+}
+
+// countSink counts the rows written to it.
+type countSink struct {
+	rows   atomic.Int64
+	closed atomic.Bool
+}
+
+func (s *countSink) Write(row *Row) error {
+	if s.closed.Load() {
+		return errors.New("write after close")
+	}
+	s.rows.Add(1)
+	return nil
+}
+
+func (s *countSink) Flush() error { return nil }
+
+func (s *countSink) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+// TestAsyncSinkCloseWhileWriting closes the sink while writers and flushes
+// are still running; it must neither panic nor lose an accepted row.
+func TestAsyncSinkCloseWhileWriting(t *testing.T) {
+	for range 20 {
+		sink := &countSink{}
+		as := NewAsyncSink(sink, 4)
+		row := &Row{Header: NewHeader([]string{"ID"}), Fields: []string{"1"}}
+
+		var accepted atomic.Int64
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					var err error
+					if i%10 == 9 {
+						err = as.Flush()
+					} else if err = as.WriteRow(context.Background(), row); err == nil {
+						accepted.Add(1)
+					}
+					if errors.Is(err, ErrSinkClosed) {
+						return
+					} else if err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		for accepted.Load() < 50 {
+			runtime.Gosched()
+		}
+		if err := as.Close(); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+		if got, want := sink.rows.Load(), accepted.Load(); got != want {
+			t.Fatalf("wrote %d rows, accepted %d", got, want)
+		}
+	}
+}
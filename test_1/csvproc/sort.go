@@ -0,0 +1,397 @@
+package csvproc
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SortKey is a column rows are ordered by. Numbers come first, compared
+// numerically, then all other values compared as strings.
+type SortKey struct {
+	Column string
+	Desc   bool
+}
+
+// ParseSortKeys parses a list such as "City,Age:desc".
+func ParseSortKeys(s string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, part := range SplitList(s) {
+		col, order, _ := strings.Cut(part, ":")
+		key := SortKey{Column: strings.TrimSpace(col)}
+		switch strings.ToLower(strings.TrimSpace(order)) {
+		case "", "asc":
+		case "desc":
+			key.Desc = true
+		default:
+			return nil, fmt.Errorf("sort key %q: order must be asc or desc", part)
+		}
+		if key.Column == "" {
+			return nil, fmt.Errorf("sort key %q has no column", part)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SortOptions configures a Sorter.
+type SortOptions struct {
+	Keys []SortKey // rows with equal keys keep their input order
+
+	// MemoryBudget bounds the rows held in memory. Half of it is filled by
+	// the workers while the other half may be sorted and spilled to a run
+	// file. 64MB by default.
+	MemoryBudget int64
+	TempDir      string // where runs are spilled, os.TempDir() by default
+}
+
+// SortStats describes a sort.
+type SortStats struct {
+	Rows        int
+	Runs        int // sorted runs spilled to disk
+	MergePasses int // intermediate passes needed to stay below maxFanIn
+}
+
+// maxFanIn is the number of runs merged at once.
+const maxFanIn = 64
+
+// Sorter is a RowHandler that sorts all rows it receives with an external
+// merge sort. Workers append to a shared buffer; the worker that fills it
+// sorts it and spills it as a run while the others continue with a fresh
+// buffer. Merge then combines the runs and the rows still in memory.
+type Sorter struct {
+	keys   []SortKey
+	limit  int64
+	dir    string
+	stats  SortStats
+	keyIdx []int // position of each key in columns
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	columns  []string
+	buf      []sortRow
+	bufSize  int64
+	spilling bool
+	runs     []string
+	err      error
+}
+
+type sortRow struct {
+	fileNum, line int
+	values        []string
+}
+
+func NewSorter(opts SortOptions) (*Sorter, error) {
+	if opts.MemoryBudget <= 0 {
+		opts.MemoryBudget = 64 << 20
+	}
+	dir, err := os.MkdirTemp(opts.TempDir, "csvproc-sort-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Sorter{keys: opts.Keys, limit: max(opts.MemoryBudget/2, 1), dir: dir}
+	s.cond = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+// Close removes the spilled runs.
+func (s *Sorter) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// Columns returns the columns of the sorted rows, in the order they were
+// first seen across all input files.
+func (s *Sorter) Columns() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.columns
+}
+
+func (s *Sorter) HandleRow(ctx context.Context, row *Row) error {
+	for _, key := range s.keys {
+		if _, ok := row.Header.Index(key.Column); !ok {
+			return fmt.Errorf("sort column %q missing from header", key.Column)
+		}
+	}
+
+	s.mu.Lock()
+	for _, col := range row.Header.Columns {
+		if !slices.Contains(s.columns, col) {
+			s.columns = append(s.columns, col)
+		}
+	}
+	values := make([]string, len(s.columns))
+	size := int64(64 + 16*len(values))
+	for i, col := range s.columns {
+		values[i] = row.Get(col)
+		size += int64(len(values[i]))
+	}
+	s.buf = append(s.buf, sortRow{fileNum: row.FileNum, line: row.Line, values: values})
+	s.bufSize += size
+	s.stats.Rows++
+	if s.bufSize < s.limit {
+		s.mu.Unlock()
+		return nil
+	}
+
+	// Wait for the previous spill so at most two buffers are held
+	for s.spilling {
+		s.cond.Wait()
+	}
+	if s.bufSize < s.limit {
+		s.mu.Unlock()
+		return nil
+	}
+	rows := s.buf
+	s.buf, s.bufSize, s.spilling = nil, 0, true
+	s.resolveKeys()
+	path := filepath.Join(s.dir, fmt.Sprintf("run-%05d.csv", len(s.runs)))
+	s.runs = append(s.runs, path)
+	s.stats.Runs++
+	s.mu.Unlock()
+
+	s.sortRows(rows)
+	err := writeRun(path, slices.Values(rows))
+
+	s.mu.Lock()
+	s.spilling = false
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return err
+}
+
+// resolveKeys looks up the key positions. Columns are only ever appended,
+// so positions stay valid. It is called with mu held.
+func (s *Sorter) resolveKeys() {
+	if len(s.keyIdx) == len(s.keys) {
+		return
+	}
+	s.keyIdx = make([]int, len(s.keys))
+	for i, key := range s.keys {
+		s.keyIdx[i] = slices.Index(s.columns, key.Column)
+	}
+}
+
+func (s *Sorter) sortRows(rows []sortRow) {
+	slices.SortFunc(rows, s.compare)
+}
+
+func (s *Sorter) compare(a, b sortRow) int {
+	for i, key := range s.keys {
+		c := compareValues(field(a.values, s.keyIdx[i]), field(b.values, s.keyIdx[i]))
+		if key.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	if c := cmp.Compare(a.fileNum, b.fileNum); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.line, b.line)
+}
+
+// compareValues orders numbers before non-numbers, which keeps the order
+// transitive for columns mixing both.
+func compareValues(a, b string) int {
+	fa, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	fb, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Compare(fa, fb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// Merge writes all rows in order to w, the values in the order of Columns.
+// It must be called once all rows were handled.
+func (s *Sorter) Merge(w func(values []string) error) (SortStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.stats, s.err
+	}
+	s.resolveKeys()
+
+	// Merge groups of runs until the rest can be merged in one pass
+	for len(s.runs) > maxFanIn {
+		path := filepath.Join(s.dir, fmt.Sprintf("merge-%05d.csv", s.stats.MergePasses))
+		if err := s.mergeRuns(s.runs[:maxFanIn], nil, func(rows func(yield func(sortRow) bool)) error {
+			return writeRun(path, rows)
+		}); err != nil {
+			return s.stats, err
+		}
+		for _, run := range s.runs[:maxFanIn] {
+			os.Remove(run)
+		}
+		s.runs = append(s.runs[maxFanIn:], path)
+		s.stats.MergePasses++
+	}
+
+	s.sortRows(s.buf)
+	n := len(s.columns)
+	err := s.mergeRuns(s.runs, s.buf, func(rows func(yield func(sortRow) bool)) error {
+		for row := range rows {
+			if err := w(pad(row.values, n)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return s.stats, err
+}
+
+// mergeRuns merges the run files and the sorted in-memory rows and hands the
+// merged sequence to consume.
+func (s *Sorter) mergeRuns(runs []string, mem []sortRow, consume func(func(yield func(sortRow) bool)) error) error {
+	h := &runHeap{compare: s.compare}
+	for _, path := range runs {
+		r, err := openRun(path)
+		if err != nil {
+			h.close()
+			return err
+		}
+		h.readers = append(h.readers, r)
+	}
+	defer h.close()
+	if len(mem) > 0 {
+		h.readers = append(h.readers, &runReader{mem: mem})
+	}
+	for _, r := range h.readers {
+		if err := r.advance(); err != nil {
+			return err
+		}
+		if r.ok {
+			h.items = append(h.items, r)
+		}
+	}
+	heap.Init(h)
+
+	var readErr error
+	err := consume(func(yield func(sortRow) bool) {
+		for h.Len() > 0 {
+			r := h.items[0]
+			if !yield(r.row) {
+				return
+			}
+			if readErr = r.advance(); readErr != nil {
+				return
+			}
+			if r.ok {
+				heap.Fix(h, 0)
+			} else {
+				heap.Pop(h)
+			}
+		}
+	})
+	return errors.Join(err, readErr)
+}
+
+// runReader reads a run file, or a slice of rows for the in-memory run.
+type runReader struct {
+	file *os.File
+	r    *csv.Reader
+	mem  []sortRow
+	row  sortRow
+	ok   bool
+}
+
+func openRun(path string) (*runReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := csv.NewReader(bufio.NewReader(file))
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = false
+	return &runReader{file: file, r: r}, nil
+}
+
+func (r *runReader) advance() error {
+	if r.file == nil {
+		r.ok = len(r.mem) > 0
+		if r.ok {
+			r.row, r.mem = r.mem[0], r.mem[1:]
+		}
+		return nil
+	}
+
+	record, err := r.r.Read()
+	if err == io.EOF {
+		r.ok = false
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read run %s: %w", r.file.Name(), err)
+	}
+	r.row.fileNum, _ = strconv.Atoi(record[0])
+	r.row.line, _ = strconv.Atoi(record[1])
+	r.row.values = record[2:]
+	r.ok = true
+	return nil
+}
+
+func writeRun(path string, rows func(yield func(sortRow) bool)) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	for row := range rows {
+		record := append([]string{strconv.Itoa(row.fileNum), strconv.Itoa(row.line)}, row.values...)
+		if err := w.Write(record); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// runHeap orders the run readers by their current row.
+type runHeap struct {
+	readers []*runReader
+	items   []*runReader
+	compare func(a, b sortRow) int
+}
+
+func (h *runHeap) Len() int           { return len(h.items) }
+func (h *runHeap) Less(i, j int) bool { return h.compare(h.items[i].row, h.items[j].row) < 0 }
+func (h *runHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *runHeap) Push(x any)         { h.items = append(h.items, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+func (h *runHeap) close() {
+	for _, r := range h.readers {
+		if r.file != nil {
+			r.file.Close()
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package csvproc
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompareValues(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"9", "10", -1},
+		{"-1.5", "2", -1},
+		{" 7 ", "7", 0},
+		{"1e3", "999", 1},
+		{"10", "abc", -1}, // numbers come first
+		{"abc", "-5", 1},
+		{"abc", "b", -1},
+		{"", "a", -1},
+		{"B", "a", -1},
+	}
+	for _, tt := range tests {
+		if got := compareValues(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareValues(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareValues(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareValues(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+// runSort sorts the files with three workers and returns the merged rows
+// by column name, as the column order depends on which file came first.
+func runSort(t *testing.T, opts SortOptions, paths []string) ([]map[string]string, SortStats) {
+	t.Helper()
+	opts.TempDir = t.TempDir()
+	s, err := NewSorter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	p := New(WithWorkers(3), WithHandler(s), WithLogOutput(io.Discard))
+	for _, r := range p.ProcessFiles(paths) {
+		if r.Error != nil {
+			t.Fatalf("%s: %v", r.FileName, r.Error)
+		}
+	}
+	var rows []map[string]string
+	columns := s.Columns()
+	stats, err := s.Merge(func(values []string) error {
+		row := make(map[string]string)
+		for i, col := range columns {
+			row[col] = values[i]
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows, stats
+}
+
+func TestSorter(t *testing.T) {
+	// Seq numbers the rows in input order to check that the sort is stable
+	files := []string{
+		"Seq,City,Age\n1,Jakarta,30\n2,Bandung,9\n3,Jakarta,10\n4,-,n/a\n5,Bandung,9\n",
+		"Seq,City,Age\n6,Medan,-2.5\n7,Jakarta,30\n8,Bandung,abc\n9,Medan,100\n",
+	}
+	tests := []struct {
+		keys string
+		want string // Seq of the sorted rows
+	}{
+		{"Age", "6 2 5 3 1 7 9 8 4"},
+		{"Age:desc", "4 8 9 1 7 3 2 5 6"},
+		{"City", "4 2 5 8 1 3 7 6 9"},
+		{"City:desc,Age", "6 9 3 1 7 2 5 8 4"},
+		{"City,Age:desc", "4 8 2 5 1 7 3 9 6"},
+		{"Seq:desc", "9 8 7 6 5 4 3 2 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keys, func(t *testing.T) {
+			keys, err := ParseSortKeys(tt.keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths := writeSnapshot(t, files...)
+			for _, budget := range []int64{1 << 20, 256, 1} {
+				rows, stats := runSort(t, SortOptions{Keys: keys, MemoryBudget: budget}, paths)
+				var got []string
+				for _, row := range rows {
+					got = append(got, row["Seq"])
+				}
+				if s := strings.Join(got, " "); s != tt.want {
+					t.Errorf("budget %d: got %s, want %s", budget, s, tt.want)
+				}
+				if spilled := stats.Runs > 0; spilled != (budget < 1<<20) {
+					t.Errorf("budget %d: spilled %d runs", budget, stats.Runs)
+				}
+				if stats.Rows != 9 || stats.MergePasses != 0 {
+					t.Errorf("budget %d: got %+v", budget, stats)
+				}
+			}
+		})
+	}
+}
+
+func TestSorterMultiPassMerge(t *testing.T) {
+	// Every row is spilled as a run of its own, far more than one merge
+	// pass takes. Keys repeat so stability is checked across runs.
+	const rows = 3 * maxFanIn
+	var files []string
+	for f := range 2 {
+		var b strings.Builder
+		b.WriteString("Seq,Group\n")
+		for i := range rows / 2 {
+			seq := f*rows/2 + i
+			fmt.Fprintf(&b, "%d,%d\n", seq, (seq*7)%5)
+		}
+		files = append(files, b.String())
+	}
+	keys, err := ParseSortKeys("Group:desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, stats := runSort(t, SortOptions{Keys: keys, MemoryBudget: 1}, writeSnapshot(t, files...))
+
+	if stats.Runs < 2*maxFanIn || stats.MergePasses < 2 {
+		t.Errorf("got %d runs in %d merge passes, want several passes", stats.Runs, stats.MergePasses)
+	}
+	if len(got) != rows {
+		t.Fatalf("got %d rows, want %d", len(got), rows)
+	}
+	for i := 1; i < len(got); i++ {
+		prevSeq, _ := strconv.Atoi(got[i-1]["Seq"])
+		seq, _ := strconv.Atoi(got[i]["Seq"])
+		prevGroup, group := got[i-1]["Group"], got[i]["Group"]
+		if prevGroup < group || prevGroup == group && prevSeq > seq {
+			t.Fatalf("row %d %v follows %v", i, got[i], got[i-1])
+		}
+	}
+}
+
+func TestSorterColumnsAcrossFiles(t *testing.T) {
+	// Files with reordered or extra columns line up by name
+	paths := writeSnapshot(t, "ID,Name\n2,Budi\n", "Name,ID,Email\nAna,1,ana@example.com\n")
+	keys, _ := ParseSortKeys("ID")
+	for _, budget := range []int64{1 << 20, 1} {
+		rows, _ := runSort(t, SortOptions{Keys: keys, MemoryBudget: budget}, paths)
+		want := []map[string]string{
+			{"ID": "1", "Name": "Ana", "Email": "ana@example.com"},
+			{"ID": "2", "Name": "Budi", "Email": ""},
+		}
+		if !slices.EqualFunc(rows, want, maps.Equal) {
+			t.Errorf("budget %d: got %q, want %q", budget, rows, want)
+		}
+	}
+}
+
+func TestParseSortKeys(t *testing.T) {
+	keys, err := ParseSortKeys("City, Age:DESC ,Name:asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SortKey{{"City", false}, {"Age", true}, {"Name", false}}
+	if !slices.Equal(keys, want) {
+		t.Errorf("got %+v, want %+v", keys, want)
+	}
+	for _, bad := range []string{"Age:down", ":desc"} {
+		if _, err := ParseSortKeys(bad); err == nil {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package csvproc
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Optional columns picked up by the user loader.
+const (
+	ColumnUsername = "Username"
+	ColumnPassword = "Password"
+)
+
+// LockedPassword is stored for users loaded without a password. It is not a
+// bcrypt hash, so logging in fails until the password is reset.
+const LockedPassword = "!locked"
+
+// User mirrors the columns of models.User in the blog API (test_2) that a
+// load fills in. The users table is described in its DATABASE_SCHEMA.md.
+type User struct {
+	Username string
+	Email    string
+	Password string // bcrypt hash or LockedPassword, set when the user is written
+
+	password string // from the Password column, hashed for new users only
+	derived  bool   // Username was derived, it must not rename an existing user
+}
+
+// UserLoadConfig configures a UserLoader.
+type UserLoadConfig struct {
+	Table      string // "users" by default
+	BatchSize  int    // users per transaction, 500 by default
+	BcryptCost int    // cost for the Password column, bcrypt.DefaultCost by default
+}
+
+// UserLoader upserts rows into the users table of the blog API, keyed by
+// email. Emails are matched as entered, only trimmed, since the blog API
+// stores them that way. Each batch is written in its own transaction:
+//
+//   - unknown emails are inserted,
+//   - known emails get their username updated if it changed and the row
+//     has a Username,
+//   - other known, unchanged and soft-deleted users and rows without a
+//     valid email are skipped.
+//
+// Usernames come from the Username column or, for new users only, are
+// derived from Name and Email. Passwords from the Password column are only
+// used for new users, so they are hashed with bcrypt when a batch is written
+// and only for emails not in the table yet; without one a user gets
+// LockedPassword. A username taken by another email fails the whole batch.
+type UserLoader struct {
+	db  *sql.DB
+	cfg UserLoadConfig
+}
+
+// OpenUserLoader connects to the PostgreSQL database at dsn.
+func OpenUserLoader(dsn string, cfg UserLoadConfig) (*UserLoader, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return NewUserLoader(db, cfg), nil
+}
+
+func NewUserLoader(db *sql.DB, cfg UserLoadConfig) *UserLoader {
+	if cfg.Table == "" {
+		cfg.Table = "users"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	// Every user takes three of the 65535 bind parameters of a statement
+	cfg.BatchSize = min(cfg.BatchSize, maxBindParams/3)
+	if cfg.BcryptCost == 0 {
+		cfg.BcryptCost = bcrypt.DefaultCost
+	}
+	return &UserLoader{db: db, cfg: cfg}
+}
+
+// CheckBcryptCost returns an error if cost is outside the range bcrypt
+// accepts. Every new user with a password is hashed at this cost, each step
+// doubles the time a hash takes.
+func CheckBcryptCost(cost int) error {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt cost %d is outside %d to %d", cost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
+func (l *UserLoader) Close() error {
+	return l.db.Close()
+}
+
+// MapUser maps a row onto a User. It returns false when the row has no
+// valid email and a ValidationError when its password is too long for
+// bcrypt. The password is hashed later, only if the user is new.
+func (l *UserLoader) MapUser(row *Row) (User, bool, error) {
+	email := strings.TrimSpace(row.Get(ColumnEmail))
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return User{}, false, nil
+	}
+
+	user := User{
+		Username: strings.TrimSpace(row.Get(ColumnUsername)),
+		Email:    email,
+		Password: LockedPassword,
+	}
+	if user.Username == "" {
+		user.Username = deriveUsername(row.Get(ColumnName), email)
+		user.derived = true
+	}
+	if password := row.Get(ColumnPassword); password != "" {
+		if len(password) > 72 {
+			return User{}, false, NewValidationError(ColumnPassword, bcrypt.ErrPasswordTooLong.Error())
+		}
+		user.password = password
+	}
+	return user, true, nil
+}
+
+// hashPasswords sets the Password of users. Only users whose email is not
+// in the table yet are hashed, the others keep their stored password.
+func (l *UserLoader) hashPasswords(ctx context.Context, users []User) error {
+	var emails []string
+	for _, u := range users {
+		if u.password != "" {
+			emails = append(emails, u.Email)
+		}
+	}
+	known, err := l.knownEmails(ctx, emails)
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		u := &users[i]
+		if u.password == "" || known[u.Email] {
+			continue
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(u.password), l.cfg.BcryptCost)
+		if err != nil {
+			return fmt.Errorf("hash password of %s: %w", u.Email, err)
+		}
+		u.Password = string(hash)
+	}
+	return nil
+}
+
+// knownEmails returns which of emails are in the table, deleted users
+// included.
+func (l *UserLoader) knownEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	if len(emails) == 0 {
+		return known, nil
+	}
+	var q strings.Builder
+	args := make([]any, len(emails))
+	fmt.Fprintf(&q, "SELECT email FROM %s WHERE email IN (", quoteIdent(l.cfg.Table))
+	for i, email := range emails {
+		if i > 0 {
+			q.WriteString(", ")
+		}
+		q.WriteString("$" + strconv.Itoa(i+1))
+		args[i] = email
+	}
+	q.WriteString(")")
+
+	rows, err := l.db.QueryContext(ctx, q.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("look up users: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		known[email] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("look up users: %w", err)
+	}
+	return known, nil
+}
+
+// deriveUsername builds a readable username from name, falling back to the
+// local part of email. A short hash of email keeps it unique.
+func deriveUsername(name, email string) string {
+	var b strings.Builder
+	dot := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dot && b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteRune(r)
+			dot = false
+		default:
+			dot = true
+		}
+		if b.Len() >= 64 {
+			break
+		}
+	}
+	base := b.String()
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	sum := sha256.Sum256([]byte(email))
+	return base + "-" + hex.EncodeToString(sum[:4])
+}
+
+// LoadCounts tell what a load did with the valid rows of a file.
+type LoadCounts struct {
+	Inserted int
+	Updated  int
+	Skipped  int
+}
+
+// newBatch returns the batch a job loads its users through, or nil without
+// a loader.
+func (l *UserLoader) newBatch() *userBatch {
+	if l == nil {
+		return nil
+	}
+	return &userBatch{loader: l}
+}
+
+// userBatch buffers the users of one job between transactions.
+type userBatch struct {
+	loader *UserLoader
+	users  []User
+	counts LoadCounts
+}
+
+func (b *userBatch) add(ctx context.Context, row *Row) error {
+	if b == nil {
+		return nil
+	}
+	user, ok, err := b.loader.MapUser(row)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		b.counts.Skipped++
+		return nil
+	}
+	b.users = append(b.users, user)
+	if len(b.users) >= b.loader.cfg.BatchSize {
+		return b.flush(ctx)
+	}
+	return nil
+}
+
+// flush upserts the buffered users in one transaction.
+func (b *userBatch) flush(ctx context.Context) error {
+	if b == nil || len(b.users) == 0 {
+		return nil
+	}
+
+	// A statement may not touch the same row twice, so only the last
+	// occurrence of an email in the batch is kept
+	last := make(map[string]int, len(b.users))
+	for i, u := range b.users {
+		last[u.Email] = i
+	}
+	users := b.users[:0]
+	for i, u := range b.users {
+		if last[u.Email] == i {
+			users = append(users, u)
+		}
+	}
+	b.counts.Skipped += len(b.users) - len(users)
+	b.users = b.users[:0]
+
+	// Hashing is slow, so it happens before the transaction takes its locks
+	if err := b.loader.hashPasswords(ctx, users); err != nil {
+		return err
+	}
+
+	// Statements lock their rows in email order, so that concurrent
+	// batches do not deadlock on each other
+	slices.SortFunc(users, func(a, b User) int { return strings.Compare(a.Email, b.Email) })
+	var named, derived []User
+	for _, u := range users {
+		if u.derived {
+			derived = append(derived, u)
+		} else {
+			named = append(named, u)
+		}
+	}
+
+	tx, err := b.loader.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var counts LoadCounts
+	for _, group := range [][]User{named, derived} {
+		if err := b.loader.upsert(ctx, tx, group, &counts); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit users: %w", err)
+	}
+
+	b.counts.Inserted += counts.Inserted
+	b.counts.Updated += counts.Updated
+	b.counts.Skipped += len(users) - counts.Inserted - counts.Updated
+	return nil
+}
+
+// upsert writes users in one statement. Known emails get the username of
+// users with a Username column, users with a derived username leave them
+// alone.
+func (l *UserLoader) upsert(ctx context.Context, tx *sql.Tx, users []User, counts *LoadCounts) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	q, args := l.upsertQuery(users)
+	rows, err := tx.QueryContext(ctx, q, args...)
+	if err != nil {
+		return fmt.Errorf("upsert users: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var inserted bool
+		if err := rows.Scan(&inserted); err != nil {
+			return err
+		}
+		if inserted {
+			counts.Inserted++
+		} else {
+			counts.Updated++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("upsert users: %w", err)
+	}
+	return nil
+}
+
+// upsertQuery builds the statement upserting users, which all have a
+// derived username or all have none. Its rows tell whether a user was
+// inserted or updated.
+func (l *UserLoader) upsertQuery(users []User) (string, []any) {
+	var q strings.Builder
+	args := make([]any, 0, 3*len(users))
+	fmt.Fprintf(&q, "INSERT INTO %s (username, email, password, created_at, updated_at) VALUES ", quoteIdent(l.cfg.Table))
+	for i, u := range users {
+		if i > 0 {
+			q.WriteString(", ")
+		}
+		n := 3 * i
+		q.WriteString("($" + strconv.Itoa(n+1) + ", $" + strconv.Itoa(n+2) + ", $" + strconv.Itoa(n+3) + ", now(), now())")
+		args = append(args, u.Username, u.Email, u.Password)
+	}
+	if users[0].derived {
+		q.WriteString(" ON CONFLICT (email) DO NOTHING RETURNING true AS inserted")
+	} else {
+		fmt.Fprintf(&q, ` ON CONFLICT (email) DO UPDATE SET username = EXCLUDED.username, updated_at = EXCLUDED.updated_at
+WHERE %[1]s.deleted_at IS NULL AND %[1]s.username <> EXCLUDED.username
+RETURNING (xmax = 0) AS inserted`, quoteIdent(l.cfg.Table))
+	}
+	return q.String(), args
+}
@@ -0,0 +1,152 @@
+package csvproc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMapUser(t *testing.T) {
+	loader := NewUserLoader(nil, UserLoadConfig{})
+	header := NewHeader([]string{"Name", "Email", "Username", "Password"})
+
+	tests := []struct {
+		name     string
+		fields   []string
+		ok       bool
+		invalid  bool
+		username string // "" when derived
+		email    string
+		password string
+	}{
+		{"as entered", []string{"Ana", " Ana.Putri@Example.COM ", "ana", "secret"}, true, false, "ana", "Ana.Putri@Example.COM", "secret"},
+		{"derived username", []string{"Budi Santoso", "budi@example.com", "", ""}, true, false, "", "budi@example.com", ""},
+		{"no email", []string{"Citra", "", "citra", ""}, false, false, "", "", ""},
+		{"invalid email", []string{"Citra", "Citra <citra@example.com>", "citra", ""}, false, false, "", "", ""},
+		{"password too long", []string{"Dewi", "dewi@example.com", "dewi", strings.Repeat("x", 73)}, false, true, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, ok, err := loader.MapUser(&Row{Header: header, Fields: tt.fields})
+			var verr *ValidationError
+			if got := errors.As(err, &verr); got != tt.invalid {
+				t.Fatalf("got error %v, want invalid = %v", err, tt.invalid)
+			}
+			if ok != tt.ok {
+				t.Fatalf("got ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if user.Email != tt.email || user.password != tt.password || user.Password != LockedPassword {
+				t.Errorf("got %+v", user)
+			}
+			if tt.username == "" {
+				if !user.derived || !strings.HasPrefix(user.Username, "budi.santoso-") {
+					t.Errorf("got username %q, derived = %v", user.Username, user.derived)
+				}
+			} else if user.derived || user.Username != tt.username {
+				t.Errorf("got username %q, derived = %v", user.Username, user.derived)
+			}
+		})
+	}
+}
+
+func TestUserPasswordsHashedForNewUsersOnly(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(`CREATE TABLE users (username TEXT, email TEXT UNIQUE, password TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO users VALUES ('ana', 'Ana@Example.com', 'hash')`); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := NewUserLoader(db, UserLoadConfig{BcryptCost: bcrypt.MinCost})
+	users := []User{
+		{Email: "Ana@Example.com", Password: LockedPassword, password: "known"},
+		{Email: "ana@example.com", Password: LockedPassword, password: "new"},
+		{Email: "budi@example.com", Password: LockedPassword},
+	}
+	if err := loader.hashPasswords(context.Background(), users); err != nil {
+		t.Fatal(err)
+	}
+
+	if users[0].Password != LockedPassword {
+		t.Errorf("hashed the password of a known user")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(users[1].Password), []byte("new")); err != nil {
+		t.Errorf("password of a new user: %v", err)
+	}
+	if cost, _ := bcrypt.Cost([]byte(users[1].Password)); cost != bcrypt.MinCost {
+		t.Errorf("hashed at cost %d, want %d", cost, bcrypt.MinCost)
+	}
+	if users[2].Password != LockedPassword {
+		t.Errorf("user without a password got %q", users[2].Password)
+	}
+}
+
+func TestUserUpsertQuery(t *testing.T) {
+	loader := NewUserLoader(nil, UserLoadConfig{Table: "blog users"})
+
+	tests := []struct {
+		name  string
+		users []User
+		want  []string // parts of the statement, in order
+	}{
+		{
+			"named",
+			[]User{{Username: "ana", Email: "ana@example.com", Password: "h1"}, {Username: "budi", Email: "Budi@example.com", Password: LockedPassword}},
+			[]string{
+				`INSERT INTO "blog users" (username, email, password, created_at, updated_at) VALUES ($1, $2, $3, now(), now()), ($4, $5, $6, now(), now())`,
+				`ON CONFLICT (email) DO UPDATE SET username = EXCLUDED.username`,
+				`WHERE "blog users".deleted_at IS NULL AND "blog users".username <> EXCLUDED.username`,
+				`RETURNING (xmax = 0) AS inserted`,
+			},
+		},
+		{
+			"derived",
+			[]User{{Username: "citra-1a2b3c4d", Email: "citra@example.com", Password: LockedPassword, derived: true}},
+			[]string{
+				`VALUES ($1, $2, $3, now(), now())`,
+				`ON CONFLICT (email) DO NOTHING RETURNING true AS inserted`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, args := loader.upsertQuery(tt.users)
+			rest := q
+			for _, part := range tt.want {
+				i := strings.Index(rest, part)
+				if i < 0 {
+					t.Fatalf("statement %q lacks %q after the previous part", q, part)
+				}
+				rest = rest[i+len(part):]
+			}
+			var want []any
+			for _, u := range tt.users {
+				want = append(want, u.Username, u.Email, u.Password)
+			}
+			if !slices.Equal(args, want) {
+				t.Errorf("got args %v, want %v", args, want)
+			}
+		})
+	}
+}
+
+func TestCheckBcryptCost(t *testing.T) {
+	for cost, ok := range map[int]bool{bcrypt.MinCost - 1: false, bcrypt.MinCost: true, bcrypt.DefaultCost: true, bcrypt.MaxCost + 1: false} {
+		if err := CheckBcryptCost(cost); (err == nil) != ok {
+			t.Errorf("cost %d: got %v", cost, err)
+		}
+	}
+}
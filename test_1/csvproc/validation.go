@@ -0,0 +1,161 @@
+package csvproc
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FieldError describes why a single column of a row was rejected.
+type FieldError struct {
+	Column string
+	Reason string
+}
+
+// ValidationError marks a row as invalid. The processor sends rows failing
+// with a ValidationError to the rejects file instead of aborting the file.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func NewValidationError(column, reason string) *ValidationError {
+	return &ValidationError{Fields: []FieldError{{Column: column, Reason: reason}}}
+}
+
+func (e *ValidationError) Add(column, reason string) {
+	e.Fields = append(e.Fields, FieldError{Column: column, Reason: reason})
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		if f.Column == "" {
+			parts[i] = f.Reason
+			continue
+		}
+		parts[i] = f.Column + ": " + f.Reason
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+// RejectWriter collects invalid rows into a CSV file with one line per
+// failing column. It is safe for concurrent use by the workers.
+type RejectWriter struct {
+	mu     sync.Mutex
+	file   *os.File
+	w      *csv.Writer
+	masker *Masker
+	count  int
+}
+
+func NewRejectWriter(path string) (*RejectWriter, error) {
+	return openRejectWriter(path, false)
+}
+
+// AppendRejectWriter continues the rejects file at path, as a resumed run
+// must. The header is only written if the file is new or empty.
+func AppendRejectWriter(path string) (*RejectWriter, error) {
+	return openRejectWriter(path, true)
+}
+
+func openRejectWriter(path string, append bool) (*RejectWriter, error) {
+	file, err := openOutput(path, append)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w := csv.NewWriter(file)
+	if info.Size() == 0 {
+		if err := w.Write([]string{"file", "line", "column", "reason", "record"}); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &RejectWriter{file: file, w: w}, nil
+}
+
+// WithMasker masks the rejected records and the values quoted in the
+// reasons with m, which may be nil.
+func (rw *RejectWriter) WithMasker(m *Masker) *RejectWriter {
+	rw.masker = m
+	return rw
+}
+
+// Reject writes record, whose columns are named by header, once for every
+// failing column of verr.
+func (rw *RejectWriter) Reject(fileName string, line int, header *Header, record []string, verr *ValidationError) error {
+	masked := record
+	if rw.masker != nil {
+		masked = rw.masker.MaskRecord(header, record)
+	}
+	raw, err := encodeRecord(masked)
+	if err != nil {
+		return err
+	}
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	for _, f := range verr.Fields {
+		reason := f.Reason
+		if rw.masker != nil && f.Column != "" {
+			if i, ok := header.Index(f.Column); ok && i < len(record) {
+				reason = rw.masker.maskReason(f.Column, record[i], reason)
+			}
+		}
+		if err := rw.w.Write([]string{fileName, strconv.Itoa(line), f.Column, reason, raw}); err != nil {
+			return err
+		}
+	}
+	rw.count++
+	return nil
+}
+
+// Count returns the number of rejected rows written so far.
+func (rw *RejectWriter) Count() int {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.count
+}
+
+// Flush writes the buffered rejects to the file.
+func (rw *RejectWriter) Flush() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.w.Flush()
+	return rw.w.Error()
+}
+
+func (rw *RejectWriter) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.w.Flush()
+	if err := rw.w.Error(); err != nil {
+		rw.file.Close()
+		return err
+	}
+	return rw.file.Close()
+}
+
+// encodeRecord formats record as a single CSV line, quoting fields that
+// contain commas, quotes or line breaks.
+func encodeRecord(record []string) (string, error) {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	if err := w.Write(record); err != nil {
+		return "", err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
@@ -0,0 +1,93 @@
+package csvproc
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRejectsWithoutSchema(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.csv")
+	data := "ID,Name,Email,Age,City\n" +
+		"1,Ana,ana@example.com,31,Jakarta\n" +
+		"2,Bu\"di\",budi@example.com,40,Bandung\n" +
+		"3,Citra,citra@example.com,x,Medan\n" +
+		"4,Dewi,dewi@example.com,25,Bogor\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rejectsPath := filepath.Join(dir, "rejects.csv")
+	rejects, err := NewRejectWriter(rejectsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(WithWorkers(1), WithHandler(NewPipeline().WithUserParse()), WithRejects(rejects), WithLogOutput(io.Discard))
+	result := p.ProcessFiles([]string{path})[0]
+	if err := rejects.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if result.ValidRows != 2 || result.InvalidRows != 2 {
+		t.Errorf("got %d valid, %d invalid rows, want 2, 2", result.ValidRows, result.InvalidRows)
+	}
+
+	file, err := os.Open(rejectsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, rec := range records[1:] {
+		lines = append(lines, rec[1])
+	}
+	// The malformed quote and the bad age
+	if want := []string{"3", "4"}; !slices.Equal(lines, want) {
+		t.Errorf("rejected lines %v, want %v:\n%q", lines, want, records)
+	}
+}
+
+func TestAppendRejectWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejects.csv")
+	header := NewHeader([]string{"ID", "Age"})
+	for i, open := range []func(string) (*RejectWriter, error){AppendRejectWriter, AppendRejectWriter, NewRejectWriter, AppendRejectWriter} {
+		rw, err := open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rw.Reject("users.csv", i+2, header, []string{"1", "x"}, NewValidationError("Age", "invalid integer")); err != nil {
+			t.Fatal(err)
+		}
+		if err := rw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// NewRejectWriter started the file over, the appends kept its header
+	var lines []string
+	for _, rec := range records {
+		lines = append(lines, rec[1])
+	}
+	if want := []string{"line", "4", "5"}; !slices.Equal(lines, want) {
+		t.Errorf("got lines %v, want %v", lines, want)
+	}
+}
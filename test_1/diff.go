@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"rootwritter/majoo_test_1_csv/csvproc"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+func diffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: csvproc diff [flags] <old> <new>")
+		fmt.Fprintln(fs.Output(), "Compares two snapshots by key. Each snapshot is a file, directory or glob.")
+		fs.PrintDefaults()
+	}
+
+	key := fs.String("key", "ID", "comma separated key columns, e.g. ID or Email")
+	output := fs.String("output", "-", "where the differences are written (- for stdout)")
+	format := fs.String("format", csvproc.DiffJSONL, "output format: jsonl or csv")
+	workers := fs.Int("workers", runtime.NumCPU(), "workers per snapshot")
+	chunkSize := fs.String("chunk-size", "", "split plain files larger than twice this size into parallel chunks (e.g. 64MB)")
+	memory := fs.String("memory", "256MB", "memory budget; snapshots are spilled to enough hash partitions to diff one partition at a time within it")
+	partitions := fs.Int("partitions", 0, "hash partitions per snapshot, at most 250 (0 = derived from -memory and the input size)")
+	tempDir := fs.String("temp-dir", "", "directory for the spilled partitions (default the system temp dir)")
+	recursive := fs.Bool("recursive", false, "walk directories recursively")
+	extensions := fs.String("ext", ".csv,.csv.gz,.csv.zst,.zip", "comma separated file extensions to pick up from directories, globs and zip archives")
+	delimiter := fs.String("delimiter", "", "field delimiter: a character, comma, semicolon, tab or pipe (default sniffed)")
+	encoding := fs.String("encoding", "auto", "input encoding: auto, utf-8, utf-16le, utf-16be or latin-1")
+
+	inputs, err := parseFlags(fs, args)
+	if err != nil {
+		if isHelp(err) {
+			return nil
+		}
+		return err
+	}
+	if len(inputs) != 2 {
+		fs.Usage()
+		return fmt.Errorf("diff needs an old and a new snapshot")
+	}
+
+	discover := csvproc.DiscoverOptions{
+		Recursive:  *recursive,
+		Extensions: csvproc.SplitList(*extensions),
+	}
+	var snapshots [2][]string
+	var inputBytes int64
+	for i, input := range inputs {
+		if snapshots[i], err = csvproc.DiscoverFiles([]string{input}, discover); err != nil {
+			return err
+		}
+		if len(snapshots[i]) == 0 {
+			return fmt.Errorf("no input files found in %s", input)
+		}
+		for _, path := range snapshots[i] {
+			if info, err := os.Stat(path); err == nil {
+				inputBytes += info.Size()
+			}
+		}
+	}
+
+	var csvOpts csvproc.CSVOptions
+	if csvOpts.Delimiter, err = csvproc.ParseDelimiter(*delimiter); err != nil {
+		return err
+	}
+	if csvOpts.Encoding, err = csvproc.ParseEncoding(*encoding); err != nil {
+		return err
+	}
+	chunkBytes, err := csvproc.ParseSize(*chunkSize)
+	if err != nil {
+		return err
+	}
+	budget, err := csvproc.ParseSize(*memory)
+	if err != nil {
+		return err
+	}
+
+	// Parsed rows take roughly three times their size on disk. Compressed
+	// inputs make this estimate too low, partitions that outgrow the budget
+	// are split again by Compare
+	n := *partitions
+	if n <= 0 {
+		n = 1
+		if budget > 0 {
+			n = int(min(3*inputBytes/budget+1, csvproc.MaxDiffPartitions))
+		}
+	}
+
+	differ, err := csvproc.NewDiffer(csvproc.DiffOptions{
+		Key:          csvproc.SplitList(*key),
+		Partitions:   n,
+		MemoryBudget: budget,
+		TempDir:      *tempDir,
+	})
+	if err != nil {
+		return err
+	}
+	defer differ.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	go func() {
+		select {
+		case sig := <-sigChan:
+			fmt.Fprintf(os.Stderr, "\nReceived signal %v, cancelling diff...\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// Both snapshots are processed at the same time
+	handlers := [2]csvproc.RowHandler{differ.Old(), differ.New()}
+	failed := make([][]csvproc.ProcessResult, 2)
+	var wg sync.WaitGroup
+	for i := range snapshots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processor := csvproc.New(
+				csvproc.WithWorkers(*workers),
+				csvproc.WithHandler(handlers[i]),
+				csvproc.WithExtensions(discover.Extensions),
+				csvproc.WithChunkSize(chunkBytes),
+				csvproc.WithCSVOptions(csvOpts),
+				csvproc.WithLogOutput(io.Discard),
+			)
+			for result := range processor.Process(ctx, snapshots[i]) {
+				if result.Error != nil {
+					failed[i] = append(failed[i], result)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for i, results := range failed {
+		for _, r := range results {
+			fmt.Fprintf(os.Stderr, "✗ %s: %v\n", r.FileName, r.Error)
+		}
+		if len(results) > 0 {
+			return fmt.Errorf("%d files of %s could not be read", len(results), inputs[i])
+		}
+	}
+
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		out = file
+	}
+	w, err := csvproc.NewDiffWriter(out, *format)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	stats, err := differ.Compare(w)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "added %d, removed %d, modified %d, unchanged %d\n", stats.Added, stats.Removed, stats.Modified, stats.Unchanged)
+	if stats.Duplicates > 0 || stats.MissingKey > 0 {
+		fmt.Fprintf(os.Stderr, "ignored %d rows with a duplicate key and %d without a key\n", stats.Duplicates, stats.MissingKey)
+	}
+	if len(stats.OnlyOld) > 0 || len(stats.OnlyNew) > 0 {
+		fmt.Fprintf(os.Stderr, "not compared: columns only in old %v, only in new %v\n", stats.OnlyOld, stats.OnlyNew)
+	}
+	return nil
+}
+
+// nopCloser keeps stdout open when the diff writer is closed.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"rootwritter/majoo_test_1_csv/csvproc"
+	"sort"
+	"strings"
+)
+
+func generateCommand(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: csvproc generate [flags] <dir>")
+		fmt.Fprintln(fs.Output(), "Writes deterministic synthetic CSV files into dir. The same seed, schema and")
+		fmt.Fprintln(fs.Output(), "size flags always produce the same files.")
+		fs.PrintDefaults()
+	}
+
+	seed := fs.Uint64("seed", 1, "random seed")
+	schemaPath := fs.String("schema", "", "JSON or YAML schema describing the columns (default: ID, Name, Email, Age, City)")
+	files := fs.Int("files", 1, "number of files to generate")
+	rows := fs.Int64("rows", 0, "rows per file (default 1000 unless -size is set)")
+	size := fs.String("size", "", "target size per file (e.g. 64MB); stops at whichever of -rows and -size is reached first")
+	errorRate := fs.Float64("error-rate", 0, "fraction of rows (0-1) with an injected malformed quote, missing column or bad email")
+	prefix := fs.String("prefix", "sample", "file name prefix")
+	manifest := fs.String("manifest", "", "write a JSON summary of the generated files to this file (- for stdout)")
+
+	inputs, err := parseFlags(fs, args)
+	if err != nil {
+		if isHelp(err) {
+			return nil
+		}
+		return err
+	}
+	if len(inputs) != 1 {
+		fs.Usage()
+		return fmt.Errorf("generate needs exactly one output directory")
+	}
+
+	opts := csvproc.GenerateOptions{
+		Seed:      *seed,
+		Files:     *files,
+		Prefix:    *prefix,
+		Rows:      *rows,
+		ErrorRate: *errorRate,
+	}
+	if opts.Bytes, err = csvproc.ParseSize(*size); err != nil {
+		return err
+	}
+	if *schemaPath != "" {
+		if opts.Schema, err = csvproc.LoadSchema(*schemaPath); err != nil {
+			return err
+		}
+	}
+
+	generated, err := csvproc.GenerateFiles(inputs[0], opts)
+	if err != nil {
+		return err
+	}
+
+	if *manifest != "" {
+		data, err := json.MarshalIndent(generated, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		if *manifest == "-" {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		if err := os.WriteFile(*manifest, data, 0o644); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range generated {
+		fmt.Printf("%s: %d rows, %d bytes%s\n", f.Path, f.Rows, f.Bytes, injectedSummary(f.Injected))
+	}
+	return nil
+}
+
+func injectedSummary(injected map[string]int) string {
+	if len(injected) == 0 {
+		return ""
+	}
+	kinds := make([]string, 0, len(injected))
+	for kind := range injected {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for i, kind := range kinds {
+		kinds[i] = fmt.Sprintf("%d %s", injected[kind], kind)
+	}
+	return " (injected " + strings.Join(kinds, ", ") + ")"
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"rootwritter/majoo_test_1_csv/csvproc"
+	"strings"
+)
+
+// openMasker parses the -mask rules and loads the key file if a rule needs
+// it.
+func openMasker(rules, keyPath string) (*csvproc.Masker, error) {
+	parsed, err := csvproc.ParseMaskRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	var key *csvproc.MaskKey
+	for _, rule := range parsed {
+		if rule.Policy != csvproc.MaskHash && rule.Policy != csvproc.MaskToken {
+			continue
+		}
+		if key, err = csvproc.LoadMaskKey(keyPath); err != nil {
+			return nil, fmt.Errorf("mask key: %w", err)
+		}
+		break
+	}
+	return csvproc.NewMasker(parsed, key)
+}
+
+func unmaskCommand(args []string) error {
+	fs := flag.NewFlagSet("unmask", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: csvproc unmask [flags] <file>")
+		fmt.Fprintln(fs.Output(), "Restores the columns of a CSV extract tokenised by run -mask. Other columns are copied as is.")
+		fs.PrintDefaults()
+	}
+
+	mask := fs.String("mask", "", "the -mask rules the extract was written with; only token columns are reversed")
+	maskKey := fs.String("mask-key", "./mask-key.json", "key file the extract was written with")
+	output := fs.String("output", "-", "where the restored rows are written (- for stdout)")
+
+	inputs, err := parseFlags(fs, args)
+	if err != nil {
+		if isHelp(err) {
+			return nil
+		}
+		return err
+	}
+	if len(inputs) != 1 {
+		fs.Usage()
+		return fmt.Errorf("unmask needs one input file")
+	}
+	rules, err := csvproc.ParseMaskRules(*mask)
+	if err != nil {
+		return err
+	}
+	var tokenised []string
+	for _, rule := range rules {
+		if rule.Policy == csvproc.MaskToken {
+			tokenised = append(tokenised, rule.Column)
+		}
+	}
+	if len(tokenised) == 0 {
+		return fmt.Errorf("-mask has no token columns to reverse")
+	}
+	if _, err := os.Stat(*maskKey); err != nil {
+		return fmt.Errorf("mask key: %w", err)
+	}
+	masker, err := openMasker(*mask, *maskKey)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(inputs[0])
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out := os.Stdout
+	if *output != "-" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	w := csv.NewWriter(out)
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	cols := make(map[int]string)
+	for i, col := range header {
+		col = strings.TrimSpace(col)
+		for _, t := range tokenised {
+			if col == t {
+				cols[i] = col
+			}
+		}
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for i, col := range cols {
+			if i < len(record) && record[i] != "" {
+				if record[i], err = masker.Unmask(col, record[i]); err != nil {
+					return err
+				}
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if out != os.Stdout {
+		return out.Close()
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"rootwritter/majoo_test_1_csv/csvproc"
+	"syscall"
+	"time"
+)
+
+func runCommand(args []string) (err error) {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: csvproc run [flags] [files|dirs|globs...]")
+		fmt.Fprintln(fs.Output(), "Inputs default to ./data when none are given.")
+		fs.PrintDefaults()
+	}
+
+	opts := addProcessFlags(fs)
+	recursive := fs.Bool("recursive", false, "walk directories recursively")
+	extensions := fs.String("ext", ".csv,.csv.gz,.csv.zst,.zip", "comma separated file extensions to pick up from directories, globs and zip archives")
+	minSize := fs.String("min-size", "", "skip files smaller than this size (e.g. 1KB)")
+	maxSize := fs.String("max-size", "", "skip files larger than this size (e.g. 5GB)")
+	reportPath := fs.String("report", "", "write a run report to this file (- for stdout)")
+	resultsPath := fs.String("results", "", "stream one JSON line per file to this file instead of keeping all results in memory")
+	reportFormat := fs.String("report-format", "", "report format: json, csv or junit (default from the -report extension)")
+	failOnError := fs.Bool("fail-on-error", false, "exit with a non-zero status when any file failed")
+	generate := fs.Int("generate", 0, "generate this many sample files and process them instead of the inputs")
+	generateDir := fs.String("generate-dir", "./csv_files", "directory for generated sample files (removed afterwards)")
+
+	inputs, err := parseFlags(fs, args)
+	if err != nil {
+		if isHelp(err) {
+			return nil
+		}
+		return err
+	}
+
+	discover := csvproc.DiscoverOptions{
+		Recursive:  *recursive,
+		Extensions: csvproc.SplitList(*extensions),
+	}
+	if discover.MinSize, err = csvproc.ParseSize(*minSize); err != nil {
+		return err
+	}
+	if discover.MaxSize, err = csvproc.ParseSize(*maxSize); err != nil {
+		return err
+	}
+	sess, err := opts.open()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	fmt.Println("Concurrent CSV File Processor")
+	fmt.Println("==========================================================")
+	fmt.Println()
+
+	var files []string
+	if *generate > 0 {
+		fmt.Printf("Creating %d sample files...\n", *generate)
+		files, err = csvproc.CreateSampleFiles(*generateDir, *generate)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created files in %s\n\n", *generateDir)
+		defer os.RemoveAll(*generateDir) // Auto cleanup
+	} else {
+		if len(inputs) == 0 {
+			inputs = []string{"./data"}
+		}
+		files, err = csvproc.DiscoverFiles(inputs, discover)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no input files found in %v", inputs)
+		}
+		fmt.Printf("Processing %d existing files...\n\n", len(files))
+	}
+
+	// Setup signal handling for graceful shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	processor := sess.newProcessor(ctx, len(files)).WithExtensions(discover.Extensions)
+	fmt.Printf("Processing with %d workers...\n\n", processor.Workers())
+
+	if *resultsPath != "" {
+		resultsFile, ferr := os.Create(*resultsPath)
+		if ferr != nil {
+			return ferr
+		}
+		defer resultsFile.Close()
+		enc := json.NewEncoder(resultsFile)
+		var encErr error
+		processor.WithResultHandler(func(r csvproc.ProcessResult) {
+			if encErr == nil {
+				encErr = enc.Encode(csvproc.NewFileReport(r))
+			}
+		})
+		defer func() {
+			if encErr != nil && err == nil {
+				err = fmt.Errorf("write results: %w", encErr)
+			}
+		}()
+	}
+
+	// Goroutine to handle cancellation signals
+	go func() {
+		select {
+		case sig := <-sigChan:
+			fmt.Printf("\nReceived signal %v, cancelling processing...\n", sig)
+			processor.Cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	start := time.Now()
+	processor.ProcessFiles(files)
+	if err := sess.finish(ctx, processor); err != nil {
+		return err
+	}
+	report := processor.Report(start, time.Now())
+
+	processor.PrintSummary()
+	fmt.Printf("Total Time: %v\n\n", time.Since(start))
+
+	if err := sess.writeAggregate(); err != nil {
+		return err
+	}
+
+	if *reportPath != "" {
+		if err := csvproc.WriteReport(report, *reportPath, *reportFormat); err != nil {
+			return fmt.Errorf("write report: %w", err)
+		}
+	}
+
+	fmt.Println("Done!")
+	if *failOnError && report.Totals.Failed > 0 {
+		return fmt.Errorf("%d of %d files failed", report.Totals.Failed, report.Totals.Files)
+	}
+	return nil
+}
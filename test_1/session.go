@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"rootwritter/majoo_test_1_csv/csvproc"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// processOptions are the processing flags shared by the run and watch
+// commands.
+type processOptions struct {
+	workers            *int
+	adaptive           *bool
+	minWorkers         *int
+	maxWorkers         *int
+	resizeInterval     *time.Duration
+	chunkSize          *string
+	maxInFlight        *int
+	rowBuffer          *int
+	readAhead          *string
+	rowsPerSec         *float64
+	bytesPerSec        *string
+	fileRowsPerSec     *float64
+	fileBytesPerSec    *string
+	delimiter          *string
+	quote              *string
+	encoding           *string
+	noHeader           *bool
+	columns            *string
+	columnMap          *string
+	schemaPath         *string
+	rejectsPath        *string
+	parseUsers         *bool
+	retries            *int
+	retryDelay         *time.Duration
+	retryMaxDelay      *time.Duration
+	checkpointPath     *string
+	checkpointInterval *time.Duration
+	resume             *bool
+	sinkKind           *string
+	output             *string
+	sqlDriver          *string
+	sqlTable           *string
+	sqlBatch           *int
+	sinkBuffer         *int
+	ordered            *bool
+	loadUsers          *string
+	loadBatch          *int
+	loadBcryptCost     *int
+	lookAhead          *int
+	filter             *string
+	mask               *string
+	maskKey            *string
+	dedupKey           *string
+	dedupPolicy        *string
+	conflictsPath      *string
+	groupBy            *string
+	aggregates         *string
+	aggOutput          *string
+	aggFormat          *string
+	metricsAddr        *string
+}
+
+func addProcessFlags(fs *flag.FlagSet) *processOptions {
+	return &processOptions{
+		workers:            fs.Int("workers", 0, "number of workers (0 = based on CPU and file count)"),
+		adaptive:           fs.Bool("adaptive", false, "grow and shrink the worker pool at runtime based on throughput"),
+		minWorkers:         fs.Int("min-workers", 1, "lower bound of the adaptive pool"),
+		maxWorkers:         fs.Int("max-workers", runtime.NumCPU()*4, "upper bound of the adaptive pool"),
+		resizeInterval:     fs.Duration("resize-interval", time.Second, "how often the adaptive pool samples throughput"),
+		chunkSize:          fs.String("chunk-size", "64MB", "split plain files larger than twice this size into parallel chunks (0 disables)"),
+		maxInFlight:        fs.Int("max-in-flight", 0, "jobs queued or in progress at once (0 = four per worker)"),
+		rowBuffer:          fs.Int("row-buffer", 0, "rows each worker reads ahead of the row being handled (0 = none)"),
+		readAhead:          fs.String("read-ahead", "", "read buffer per input file (e.g. 1MB, default 4KB)"),
+		rowsPerSec:         fs.Float64("rows-per-sec", 0, "cap the rows processed per second over all files (0 = unlimited)"),
+		bytesPerSec:        fs.String("bytes-per-sec", "", "cap the bytes processed per second over all files (e.g. 10MB)"),
+		fileRowsPerSec:     fs.Float64("file-rows-per-sec", 0, "cap the rows processed per second of every file (0 = unlimited)"),
+		fileBytesPerSec:    fs.String("file-bytes-per-sec", "", "cap the bytes processed per second of every file (e.g. 1MB)"),
+		delimiter:          fs.String("delimiter", "", "field delimiter: a character, comma, semicolon, tab or pipe (default sniffed)"),
+		quote:              fs.String("quote", "", `quote character, " or ' (default sniffed)`),
+		encoding:           fs.String("encoding", "auto", "input encoding: auto, utf-8, utf-16le, utf-16be or latin-1"),
+		noHeader:           fs.Bool("no-header", false, "inputs have no header line; columns are named by -columns or the schema"),
+		columns:            fs.String("columns", "", "comma separated column names for -no-header inputs"),
+		columnMap:          fs.String("column-map", "", "JSON or YAML file renaming input columns to schema columns, e.g. {\"user_email\": \"Email\"}"),
+		schemaPath:         fs.String("schema", "", "schema file for row validation, e.g. ./schemas/users.json (default no validation)"),
+		rejectsPath:        fs.String("rejects", "", "where invalid rows are written, unparsable records included (default not written)"),
+		parseUsers:         fs.Bool("parse-users", false, "parse rows into user records, rejecting rows whose ID or Age is not an integer"),
+		retries:            fs.Int("retries", 3, "attempts per file for transient I/O errors"),
+		retryDelay:         fs.Duration("retry-delay", 500*time.Millisecond, "backoff before the first retry, doubled on every further attempt"),
+		retryMaxDelay:      fs.Duration("retry-max-delay", 30*time.Second, "upper bound of the retry backoff"),
+		checkpointPath:     fs.String("checkpoint", "", "write progress to this checkpoint file"),
+		checkpointInterval: fs.Duration("checkpoint-interval", 5*time.Second, "how often the checkpoint file is written"),
+		resume:             fs.Bool("resume", false, "skip files completed in -checkpoint and continue partial ones, appending to the -output file and -rejects; not with -group-by or -agg"),
+		sinkKind:           fs.String("sink", "", "write processed rows to a sink: csv, tsv, jsonl or sql"),
+		output:             fs.String("output", "", "output file of the sink, or the DSN for -sink sql"),
+		sqlDriver:          fs.String("sql-driver", "pgx", "database/sql driver for -sink sql"),
+		sqlTable:           fs.String("sql-table", "users", "table the sql sink inserts into"),
+		sqlBatch:           fs.Int("sql-batch", 500, "rows per INSERT batch for -sink sql"),
+		sinkBuffer:         fs.Int("sink-buffer", 1024, "rows buffered in front of the sink before workers block"),
+		loadUsers:          fs.String("load-users", "", "upsert valid rows by email into the users table of the blog API at this PostgreSQL DSN"),
+		loadBatch:          fs.Int("load-batch", 500, "users per transaction for -load-users"),
+		loadBcryptCost:     fs.Int("load-bcrypt-cost", bcrypt.DefaultCost, "bcrypt cost for passwords of new users from the Password column with -load-users; each step doubles the hashing time"),
+		ordered:            fs.Bool("ordered", false, "emit results and sink rows in input file and row order"),
+		lookAhead:          fs.Int("look-ahead", 0, "with -ordered, how many jobs workers may run ahead of the oldest unfinished one (0 = -max-in-flight)"),
+		filter:             fs.String("filter", "", `only process rows matching this expression, e.g. 'Age >= 30 && endsWith(Email, "@gmail.com")'`),
+		mask:               fs.String("mask", "", "comma separated Column:policy rules masking the sink, rejects and conflicts output; policies: redact, partial, hash or token"),
+		maskKey:            fs.String("mask-key", "./mask-key.json", "key file with the salt of -mask hash and the key of -mask token, created if missing"),
+		dedupKey:           fs.String("dedup-key", "", "comma separated key columns for cross-file deduplication (e.g. ID or Email)"),
+		dedupPolicy:        fs.String("dedup-policy", csvproc.DedupKeepFirst, "on duplicate keys: keep-first, keep-last, fail or conflicts; all but fail keep one row per key in memory until the end of the run; fail reports whichever row the workers reach second"),
+		conflictsPath:      fs.String("conflicts", "./conflicts.csv", "where conflicting rows are written with -dedup-policy conflicts"),
+		groupBy:            fs.String("group-by", "", "comma separated columns to aggregate by; Col/10 buckets numbers, domain(Col) takes an email domain"),
+		aggregates:         fs.String("agg", "", "comma separated aggregates: count, sum:Col, min:Col, max:Col, avg:Col, distinct:Col (default count)"),
+		aggOutput:          fs.String("agg-output", "-", "where aggregates are written (- for stdout)"),
+		metricsAddr:        fs.String("metrics-addr", "", "serve Prometheus /metrics and JSON /progress on this address (e.g. :9090)"),
+		aggFormat:          fs.String("agg-format", "", "aggregate format: table or json (default from the -agg-output extension)"),
+	}
+}
+
+// session holds everything that outlives a single ProcessFiles call: the
+// pipeline, the rejects and conflicts files, the sink, the deduper and the
+// checkpoint.
+type session struct {
+	opts       *processOptions
+	chunkBytes int64
+	csv        csvproc.CSVOptions
+	limits     csvproc.Limits
+	rate       csvproc.RateLimit
+	fileRate   csvproc.RateLimit
+	pipeline   *csvproc.Pipeline
+	schema     *csvproc.Schema
+	rejects    *csvproc.RejectWriter
+	checkpoint *csvproc.Checkpoint
+	deduper    *csvproc.Deduper
+	aggregator *csvproc.Aggregator
+	loader     *csvproc.UserLoader
+	filter     *csvproc.Filter
+	metrics    *csvproc.Metrics
+	sink       *csvproc.AsyncSink
+	closers    []func() error
+}
+
+func (o *processOptions) open() (s *session, err error) {
+	sess := &session{opts: o, pipeline: csvproc.NewPipeline()}
+	s = sess
+	defer func() {
+		if err != nil {
+			sess.Close()
+		}
+	}()
+
+	if s.chunkBytes, err = csvproc.ParseSize(*o.chunkSize); err != nil {
+		return nil, err
+	}
+	readAhead, err := csvproc.ParseSize(*o.readAhead)
+	if err != nil {
+		return nil, err
+	}
+	s.limits = csvproc.Limits{
+		InFlightFiles: *o.maxInFlight,
+		RowBuffer:     *o.rowBuffer,
+		ReadAhead:     int(readAhead),
+	}
+	s.rate.RowsPerSec, s.fileRate.RowsPerSec = *o.rowsPerSec, *o.fileRowsPerSec
+	if s.rate.BytesPerSec, err = csvproc.ParseSize(*o.bytesPerSec); err != nil {
+		return nil, err
+	}
+	if s.fileRate.BytesPerSec, err = csvproc.ParseSize(*o.fileBytesPerSec); err != nil {
+		return nil, err
+	}
+	if *o.resume && *o.checkpointPath == "" {
+		return nil, fmt.Errorf("-resume requires -checkpoint")
+	}
+	// Rows skipped on resume were aggregated by the interrupted run, whose
+	// partial aggregates are not in the checkpoint
+	if *o.resume && (*o.groupBy != "" || *o.aggregates != "") {
+		return nil, fmt.Errorf("-resume cannot be combined with -group-by or -agg")
+	}
+
+	if s.csv, err = o.csvOptions(); err != nil {
+		return nil, err
+	}
+
+	if *o.schemaPath != "" {
+		if s.schema, err = csvproc.LoadSchema(*o.schemaPath); err != nil {
+			return nil, err
+		}
+	}
+	if *o.rejectsPath != "" {
+		// A resumed run continues the outputs of the interrupted one, whose
+		// committed rows it skips
+		open := csvproc.NewRejectWriter
+		if *o.resume {
+			open = csvproc.AppendRejectWriter
+		}
+		if s.rejects, err = open(*o.rejectsPath); err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, s.rejects.Close)
+	}
+
+	if *o.parseUsers {
+		s.pipeline.WithUserParse()
+	}
+	if s.schema != nil {
+		s.pipeline.Validate = s.schema.Validate
+	}
+
+	if *o.filter != "" {
+		if s.filter, err = csvproc.CompileFilter(*o.filter); err != nil {
+			return nil, err
+		}
+	}
+
+	if *o.sinkKind != "" {
+		if *o.output == "" {
+			return nil, fmt.Errorf("-sink requires -output")
+		}
+		sink, err := csvproc.NewSink(csvproc.SinkConfig{
+			Kind:      *o.sinkKind,
+			Output:    *o.output,
+			Driver:    *o.sqlDriver,
+			Table:     *o.sqlTable,
+			BatchSize: *o.sqlBatch,
+			Append:    *o.resume,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("open sink: %w", err)
+		}
+		s.sink = csvproc.NewAsyncSink(sink, *o.sinkBuffer)
+		s.closers = append(s.closers, s.sink.Close)
+		s.pipeline.Sink = s.sink.WriteRow
+		if *o.ordered {
+			s.pipeline.Sink = csvproc.Ordered(s.pipeline.Sink)
+		}
+	}
+
+	var masker *csvproc.Masker
+	if *o.mask != "" {
+		if s.sink == nil {
+			return nil, fmt.Errorf("-mask requires -sink")
+		}
+		if masker, err = openMasker(*o.mask, *o.maskKey); err != nil {
+			return nil, err
+		}
+		// Masked before the ordered gate so that the workers do the work
+		s.pipeline.Sink = masker.Wrap(s.pipeline.Sink)
+		if s.rejects != nil {
+			s.rejects.WithMasker(masker)
+		}
+	}
+
+	if *o.loadUsers != "" {
+		if err := csvproc.CheckBcryptCost(*o.loadBcryptCost); err != nil {
+			return nil, fmt.Errorf("-load-bcrypt-cost: %w", err)
+		}
+		s.loader, err = csvproc.OpenUserLoader(*o.loadUsers, csvproc.UserLoadConfig{
+			BatchSize:  *o.loadBatch,
+			BcryptCost: *o.loadBcryptCost,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("open users database: %w", err)
+		}
+		s.closers = append(s.closers, s.loader.Close)
+	}
+
+	if *o.checkpointPath != "" {
+		s.checkpoint = csvproc.NewCheckpoint(*o.checkpointPath)
+		if *o.resume {
+			if s.checkpoint, err = csvproc.LoadCheckpoint(*o.checkpointPath); err != nil {
+				return nil, err
+			}
+			if err := s.checkpoint.CheckChunkSize(s.chunkBytes); err != nil {
+				return nil, fmt.Errorf("%w; resume with the -chunk-size of that run", err)
+			}
+		}
+	}
+
+	if *o.dedupKey != "" {
+		var conflicts *csvproc.ConflictWriter
+		if *o.dedupPolicy == csvproc.DedupConflicts {
+			if conflicts, err = csvproc.NewConflictWriter(*o.conflictsPath); err != nil {
+				return nil, err
+			}
+			s.closers = append(s.closers, conflicts.Close)
+		}
+		if s.deduper, err = csvproc.NewDeduper(csvproc.SplitList(*o.dedupKey), *o.dedupPolicy, conflicts); err != nil {
+			return nil, err
+		}
+		s.deduper.WithMasker(masker)
+		if s.checkpoint != nil && s.deduper.Holds() {
+			// Held rows are only written at the end of the run, a checkpoint
+			// would commit rows that an interrupted run never wrote
+			return nil, fmt.Errorf("-checkpoint requires -dedup-policy %s", csvproc.DedupFail)
+		}
+		if s.deduper.Holds() && (s.limits != csvproc.Limits{}) {
+			// Held rows stay in memory until the end of the run, which the
+			// limits cannot bound
+			return nil, fmt.Errorf("-max-in-flight, -row-buffer and -read-ahead require -dedup-policy %s", csvproc.DedupFail)
+		}
+		s.pipeline.Transform = s.deduper.Stage
+	}
+
+	if s.csv.NoHeader && len(s.csv.Columns) == 0 && s.schema == nil {
+		return nil, fmt.Errorf("-no-header requires -columns or -schema")
+	}
+
+	if *o.metricsAddr != "" {
+		s.metrics = csvproc.NewMetrics()
+		serveCtx, stopServing := context.WithCancel(context.Background())
+		s.closers = append(s.closers, func() error {
+			stopServing()
+			return nil
+		})
+		if err = s.metrics.Serve(serveCtx, *o.metricsAddr); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Serving /metrics and /progress on %s\n", *o.metricsAddr)
+	}
+
+	if *o.groupBy != "" || *o.aggregates != "" {
+		if s.aggregator, err = csvproc.NewAggregator(*o.groupBy, *o.aggregates); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (o *processOptions) csvOptions() (opts csvproc.CSVOptions, err error) {
+	if opts.Delimiter, err = csvproc.ParseDelimiter(*o.delimiter); err != nil {
+		return opts, err
+	}
+	switch *o.quote {
+	case "":
+	case `"`, "'":
+		opts.Quote = rune((*o.quote)[0])
+	default:
+		return opts, fmt.Errorf("invalid quote %q", *o.quote)
+	}
+	if opts.Encoding, err = csvproc.ParseEncoding(*o.encoding); err != nil {
+		return opts, err
+	}
+	opts.NoHeader = *o.noHeader
+	opts.Columns = csvproc.SplitList(*o.columns)
+	if *o.columnMap != "" {
+		if opts.Mapping, err = csvproc.LoadColumnMap(*o.columnMap); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// newProcessor returns a processor for fileCount files wired to the session.
+func (s *session) newProcessor(ctx context.Context, fileCount int) *csvproc.ConcurrentProcessor {
+	o := s.opts
+	workerCount := *o.workers
+	if workerCount <= 0 {
+		workerCount = csvproc.CalculateOptimalWorkers(fileCount)
+	}
+
+	opts := []csvproc.Option{
+		csvproc.WithWorkers(workerCount),
+		csvproc.WithHandler(s.pipeline),
+		csvproc.WithChunkSize(s.chunkBytes),
+		csvproc.WithLimits(s.limits),
+		csvproc.WithRateLimit(s.rate, s.fileRate),
+		csvproc.WithCSVOptions(s.csv),
+		csvproc.WithRetry(csvproc.RetryConfig{
+			MaxAttempts: *o.retries,
+			BaseDelay:   *o.retryDelay,
+			MaxDelay:    *o.retryMaxDelay,
+		}),
+	}
+	if *o.adaptive {
+		opts = append(opts, csvproc.WithAdaptivePool(csvproc.AdaptiveConfig{
+			MinWorkers: *o.minWorkers,
+			MaxWorkers: *o.maxWorkers,
+			Interval:   *o.resizeInterval,
+		}))
+	}
+	if s.schema != nil {
+		opts = append(opts, csvproc.WithSchema(s.schema, s.rejects))
+	}
+	if s.rejects != nil {
+		opts = append(opts, csvproc.WithRejects(s.rejects))
+	}
+	if s.checkpoint != nil {
+		opts = append(opts,
+			csvproc.WithCheckpoint(s.checkpoint, *o.resume),
+			csvproc.WithCheckpointInterval(*o.checkpointInterval),
+		)
+		if s.sink != nil {
+			opts = append(opts, csvproc.WithSinkFlush(s.sink.Flush))
+		}
+	}
+	if s.metrics != nil {
+		opts = append(opts, csvproc.WithMetrics(s.metrics))
+	}
+	if s.aggregator != nil {
+		opts = append(opts, csvproc.WithAggregator(s.aggregator))
+	}
+	if s.loader != nil {
+		opts = append(opts, csvproc.WithUserLoader(s.loader))
+	}
+	if s.filter != nil {
+		opts = append(opts, csvproc.WithFilter(s.filter))
+	}
+	if *o.ordered {
+		opts = append(opts, csvproc.WithOrdered(*o.lookAhead))
+	}
+	return csvproc.New(opts...).WithContext(ctx)
+}
+
+// finish emits the rows held back until all input was seen through
+// processor, which adds their counts to its results, flushes the sink and
+// saves the checkpoint. It is called once no more files will be processed.
+func (s *session) finish(ctx context.Context, processor *csvproc.ConcurrentProcessor) error {
+	if s.deduper != nil {
+		if err := processor.FlushHeld(ctx, s.deduper, s.pipeline.Sink); err != nil {
+			return fmt.Errorf("dedup: %w", err)
+		}
+	}
+	if s.sink != nil {
+		if err := s.sink.Close(); err != nil {
+			return fmt.Errorf("sink: %w", err)
+		}
+	}
+	if s.checkpoint != nil {
+		// ProcessFiles only logs a failed final save. The checkpoint stays
+		// dirty then, so saving again retries it and fails the run.
+		if err := s.checkpoint.Save(); err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeAggregate writes the aggregates of all rows processed so far.
+func (s *session) writeAggregate() error {
+	if s.aggregator == nil {
+		return nil
+	}
+	if err := csvproc.WriteAggregate(s.aggregator.Result(), *s.opts.aggOutput, *s.opts.aggFormat); err != nil {
+		return fmt.Errorf("write aggregates: %w", err)
+	}
+	return nil
+}
+
+// Close releases the session's files.
+func (s *session) Close() error {
+	var first error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if err := s.closers[i](); err != nil && first == nil {
+			first = err
+		}
+	}
+	s.closers = nil
+	return first
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"rootwritter/majoo_test_1_csv/csvproc"
+	"runtime"
+	"syscall"
+)
+
+func sortCommand(args []string) error {
+	fs := flag.NewFlagSet("sort", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: csvproc sort [flags] <inputs...>")
+		fmt.Fprintln(fs.Output(), "Sorts the combined rows of all inputs, spilling sorted runs to disk beyond -memory,")
+		fmt.Fprintln(fs.Output(), "and writes them to one file or to partition files.")
+		fs.PrintDefaults()
+	}
+
+	by := fs.String("by", "", "comma separated sort columns, each optionally followed by :desc, e.g. City,Age:desc (default input order)")
+	output := fs.String("output", "-", "where the sorted rows are written when not partitioning (- for stdout)")
+	partitionBy := fs.String("partition-by", "", "comma separated columns; writes one file per distinct value, or hashes them with -buckets")
+	buckets := fs.Int("buckets", 0, "spread rows over this many hash bucket files instead of one file per value")
+	outputDir := fs.String("output-dir", "partitions", "directory for the partition files")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of concurrent workers")
+	chunkSize := fs.String("chunk-size", "", "split plain files larger than twice this size into parallel chunks (e.g. 64MB)")
+	memory := fs.String("memory", "256MB", "memory budget for buffered rows before sorted runs are spilled to disk")
+	tempDir := fs.String("temp-dir", "", "directory for the spilled runs (default the system temp dir)")
+	recursive := fs.Bool("recursive", false, "walk directories recursively")
+	extensions := fs.String("ext", ".csv,.csv.gz,.csv.zst,.zip", "comma separated file extensions to pick up from directories, globs and zip archives")
+	delimiter := fs.String("delimiter", "", "field delimiter: a character, comma, semicolon, tab or pipe (default sniffed)")
+	encoding := fs.String("encoding", "auto", "input encoding: auto, utf-8, utf-16le, utf-16be or latin-1")
+
+	inputs, err := parseFlags(fs, args)
+	if err != nil {
+		if isHelp(err) {
+			return nil
+		}
+		return err
+	}
+	if len(inputs) == 0 {
+		fs.Usage()
+		return fmt.Errorf("no inputs given")
+	}
+
+	discover := csvproc.DiscoverOptions{
+		Recursive:  *recursive,
+		Extensions: csvproc.SplitList(*extensions),
+	}
+	files, err := csvproc.DiscoverFiles(inputs, discover)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no input files found")
+	}
+
+	keys, err := csvproc.ParseSortKeys(*by)
+	if err != nil {
+		return err
+	}
+	var csvOpts csvproc.CSVOptions
+	if csvOpts.Delimiter, err = csvproc.ParseDelimiter(*delimiter); err != nil {
+		return err
+	}
+	if csvOpts.Encoding, err = csvproc.ParseEncoding(*encoding); err != nil {
+		return err
+	}
+	chunkBytes, err := csvproc.ParseSize(*chunkSize)
+	if err != nil {
+		return err
+	}
+	budget, err := csvproc.ParseSize(*memory)
+	if err != nil {
+		return err
+	}
+	partition := csvproc.PartitionOptions{
+		Dir:     *outputDir,
+		By:      csvproc.SplitList(*partitionBy),
+		Buckets: *buckets,
+	}
+	partitioned := len(partition.By) > 0 || partition.Buckets > 0
+
+	sorter, err := csvproc.NewSorter(csvproc.SortOptions{
+		Keys:         keys,
+		MemoryBudget: budget,
+		TempDir:      *tempDir,
+	})
+	if err != nil {
+		return err
+	}
+	defer sorter.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	go func() {
+		select {
+		case sig := <-sigChan:
+			fmt.Fprintf(os.Stderr, "\nReceived signal %v, cancelling sort...\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	processor := csvproc.New(
+		csvproc.WithWorkers(*workers),
+		csvproc.WithHandler(sorter),
+		csvproc.WithExtensions(discover.Extensions),
+		csvproc.WithChunkSize(chunkBytes),
+		csvproc.WithCSVOptions(csvOpts),
+		csvproc.WithLogOutput(io.Discard),
+	)
+	var failed []csvproc.ProcessResult
+	for result := range processor.Process(ctx, files) {
+		if result.Error != nil {
+			failed = append(failed, result)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for _, r := range failed {
+		fmt.Fprintf(os.Stderr, "✗ %s: %v\n", r.FileName, r.Error)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d files could not be read", len(failed))
+	}
+
+	var stats csvproc.SortStats
+	if partitioned {
+		pw, err := csvproc.NewPartitionWriter(sorter.Columns(), partition)
+		if err != nil {
+			return err
+		}
+		stats, err = sorter.Merge(pw.Write)
+		if cerr := pw.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "wrote %d partitions to %s\n", len(pw.Partitions()), *outputDir)
+	} else {
+		out := os.Stdout
+		if *output != "-" {
+			if out, err = os.Create(*output); err != nil {
+				return err
+			}
+		}
+		if stats, err = writeSorted(out, sorter); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "sorted %d rows in %d spilled runs and %d merge passes\n", stats.Rows, stats.Runs, stats.MergePasses)
+	return nil
+}
+
+// writeSorted writes the header and the sorted rows to out and closes it
+// unless it is stdout.
+func writeSorted(out *os.File, sorter *csvproc.Sorter) (csvproc.SortStats, error) {
+	w := csv.NewWriter(out)
+	var stats csvproc.SortStats
+	err := w.Write(sorter.Columns())
+	if err == nil {
+		stats, err = sorter.Merge(w.Write)
+	}
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+	if out != os.Stdout {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return stats, err
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"rootwritter/majoo_test_1_csv/csvproc"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func watchCommand(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: csvproc watch [flags] <dir>")
+		fmt.Fprintln(fs.Output(), "Processes files dropped into dir until interrupted. Each file is moved to")
+		fmt.Fprintln(fs.Output(), "-processed-dir or -failed-dir together with its <name>.report.json.")
+		fs.PrintDefaults()
+	}
+
+	opts := addProcessFlags(fs)
+	extensions := fs.String("ext", ".csv,.csv.gz,.csv.zst,.zip", "comma separated file extensions to pick up, also from zip archives")
+	interval := fs.Duration("interval", 2*time.Second, "how often the directory is rescanned")
+	stableFor := fs.Duration("stable-for", 5*time.Second, "a file is ready once its size has not changed for this long")
+	marker := fs.String("marker", ".done", "a file is ready at once when <file><marker> exists (empty disables)")
+	poll := fs.Bool("poll", false, "poll the directory instead of using inotify")
+	processedDir := fs.String("processed-dir", "", "where processed files are moved (default <dir>/processed)")
+	failedDir := fs.String("failed-dir", "", "where failed files are moved (default <dir>/failed)")
+
+	inputs, err := parseFlags(fs, args)
+	if err != nil {
+		if isHelp(err) {
+			return nil
+		}
+		return err
+	}
+	if len(inputs) != 1 {
+		fs.Usage()
+		return fmt.Errorf("watch needs exactly one directory")
+	}
+
+	w := &dirWatcher{
+		dir:          inputs[0],
+		discover:     csvproc.DiscoverOptions{Extensions: csvproc.SplitList(*extensions)},
+		stableFor:    *stableFor,
+		marker:       *marker,
+		processedDir: *processedDir,
+		failedDir:    *failedDir,
+		pending:      make(map[string]fileState),
+		unmoved:      make(map[string]fileState),
+	}
+	if info, err := os.Stat(w.dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", w.dir)
+	}
+	if w.processedDir == "" {
+		w.processedDir = filepath.Join(w.dir, "processed")
+	}
+	if w.failedDir == "" {
+		w.failedDir = filepath.Join(w.dir, "failed")
+	}
+	for _, dir := range []string{w.processedDir, w.failedDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	if w.sess, err = opts.open(); err != nil {
+		return err
+	}
+	defer w.sess.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	go func() {
+		select {
+		case sig := <-sigChan:
+			fmt.Printf("\nReceived signal %v, stopping watch...\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	fmt.Printf("Watching %s for new files (Ctrl+C to stop)...\n\n", w.dir)
+	if err := w.Run(ctx, *interval, *poll); err != nil {
+		return err
+	}
+	// Rows held back by the deduper are only emitted on shutdown, as later
+	// files may still replace them. The files have been moved by then, so
+	// what the deduper dropped is only logged.
+	processor := w.sess.newProcessor(context.Background(), 1)
+	if err := w.sess.finish(context.Background(), processor); err != nil {
+		return err
+	}
+	if t := processor.Report(time.Now(), time.Now()).Totals; t.Duplicates > 0 {
+		fmt.Printf("%d duplicates dropped, %d conflicting\n", t.Duplicates, t.Conflicts)
+	}
+	if err := w.sess.writeAggregate(); err != nil {
+		return err
+	}
+	fmt.Println("Done!")
+	return nil
+}
+
+// fileState is the last observed size of a file waiting to become stable.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// dirWatcher picks up files from a directory once they are completely
+// written and feeds them to the processor in batches.
+type dirWatcher struct {
+	dir          string
+	discover     csvproc.DiscoverOptions
+	stableFor    time.Duration
+	marker       string
+	processedDir string
+	failedDir    string
+	sess         *session
+	pending      map[string]fileState
+	unmoved      map[string]fileState // processed files that could not be moved away
+}
+
+// Run rescans the directory on every change notification and every interval
+// until ctx is cancelled. Without inotify it falls back to polling.
+func (w *dirWatcher) Run(ctx context.Context, interval time.Duration, poll bool) error {
+	var changes <-chan struct{}
+	if !poll {
+		var err error
+		if changes, err = notifyDir(ctx, w.dir); err != nil {
+			fmt.Printf("inotify unavailable (%v), polling every %v\n", err, interval)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := w.scan(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case _, ok := <-changes:
+			if !ok {
+				fmt.Printf("inotify stopped, polling every %v\n", interval)
+				changes = nil
+			}
+		}
+	}
+}
+
+// scan processes the files that are ready: those whose size and modification
+// time did not change for stableFor, or that have a marker file next to them.
+func (w *dirWatcher) scan(ctx context.Context) error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	present := make(map[string]bool, len(entries))
+	var ready []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !w.discover.MatchExtension(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // removed in the meantime
+		}
+
+		path := filepath.Join(w.dir, e.Name())
+		present[path] = true
+		if st, ok := w.unmoved[path]; ok {
+			if st.size == info.Size() && st.modTime.Equal(info.ModTime()) {
+				continue
+			}
+			delete(w.unmoved, path) // replaced, process the new content
+		}
+		st, ok := w.pending[path]
+		if !ok || st.size != info.Size() || !st.modTime.Equal(info.ModTime()) {
+			st = fileState{size: info.Size(), modTime: info.ModTime(), since: now}
+			w.pending[path] = st
+		}
+		if now.Sub(st.since) >= w.stableFor || w.hasMarker(path) {
+			ready = append(ready, path)
+		}
+	}
+	for path := range w.pending {
+		if !present[path] {
+			delete(w.pending, path)
+		}
+	}
+	for path := range w.unmoved {
+		if !present[path] {
+			delete(w.unmoved, path)
+		}
+	}
+
+	if len(ready) == 0 || ctx.Err() != nil {
+		return nil
+	}
+	sort.Strings(ready)
+	w.process(ctx, ready)
+	return nil
+}
+
+func (w *dirWatcher) hasMarker(path string) bool {
+	if w.marker == "" {
+		return false
+	}
+	_, err := os.Stat(path + w.marker)
+	return err == nil
+}
+
+// process runs one batch and moves every file to the processed or failed
+// directory. Files interrupted by a shutdown stay where they are so that
+// they are picked up again on the next start. Failures to move a file or
+// write its report are logged; a file left in place is ignored until it
+// changes.
+func (w *dirWatcher) process(ctx context.Context, files []string) {
+	fmt.Printf("Processing %d new files...\n\n", len(files))
+	processor := w.sess.newProcessor(ctx, len(files)).WithExtensions(w.discover.Extensions)
+	start := time.Now()
+	results := processor.ProcessFiles(files)
+	finished := time.Now()
+	processor.PrintSummary()
+
+	for _, path := range files {
+		delete(w.pending, path)
+
+		var (
+			own         []csvproc.ProcessResult
+			failed      bool
+			interrupted = ctx.Err() != nil
+		)
+		for _, r := range results {
+			if !resultOf(r, path) {
+				continue
+			}
+			own = append(own, r)
+			if r.Error != nil {
+				failed = true
+				if csvproc.ErrorClass(r.Error) != csvproc.ClassCancelled {
+					interrupted = false
+				}
+			}
+		}
+		if interrupted && (failed || len(own) == 0) {
+			continue
+		}
+
+		dest := w.processedDir
+		if failed {
+			dest = w.failedDir
+		}
+		report := csvproc.NewRunReport(own, processor.Workers(), start, finished)
+		if err := w.move(path, dest, report); err != nil {
+			fmt.Printf("✗ %v\n", err)
+			if info, err := os.Stat(path); err == nil {
+				w.unmoved[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+			}
+		}
+	}
+}
+
+// resultOf reports whether r belongs to path, including the entries of a
+// zip archive which are named "archive.zip:entry".
+func resultOf(r csvproc.ProcessResult, path string) bool {
+	base := filepath.Base(path)
+	return r.FileName == base || strings.HasPrefix(r.FileName, base+":")
+}
+
+// move moves path into dir, writes the report next to it and removes the
+// marker file. A file of the same name already in dir is not overwritten.
+func (w *dirWatcher) move(path, dir string, report *csvproc.RunReport) error {
+	target := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(target); err == nil {
+		target = filepath.Join(dir, time.Now().Format("20060102T150405.000")+"-"+filepath.Base(path))
+	}
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("move %s: %w", path, err)
+	}
+	if w.marker != "" {
+		if err := os.Remove(path + w.marker); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("✗ remove marker: %v\n", err)
+		}
+	}
+	if err := csvproc.WriteReport(report, target+".report.json", csvproc.ReportJSON); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	fmt.Printf("%s -> %s\n", filepath.Base(path), target)
+	return nil
+}
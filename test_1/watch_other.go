@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// notifyDir is only implemented on Linux; elsewhere the watcher polls.
+func notifyDir(ctx context.Context, dir string) (<-chan struct{}, error) {
+	return nil, errors.New("not supported on this platform")
+}